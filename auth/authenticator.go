@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/emersion/go-sasl"
+)
+
+// Authenticator produces the SASL clients used to log into IMAP and SMTP
+// servers. Providers only ever talk to an Authenticator, so supporting a new
+// way of authenticating means adding an implementation here.
+type Authenticator interface {
+	// Mechanisms lists the SASL mechanisms this authenticator can speak,
+	// most preferred first
+	Mechanisms() []string
+
+	// SASLClient returns a client for one of Mechanisms, logging into the
+	// server at host:port
+	SASLClient(mechanism, host string, port int) (sasl.Client, error)
+}
+
+// NewAuthenticator picks the Authenticator matching how the account was set up
+func NewAuthenticator(creds Credentials) (Authenticator, error) {
+	switch {
+	case creds.Email == "":
+		return nil, fmt.Errorf("missing email address - please run: tmail auth")
+	case creds.OAuthTokenCommand != "":
+		return &OAuth2Authenticator{Username: creds.Email, TokenCommand: creds.OAuthTokenCommand}, nil
+	case creds.UsesOAuth2():
+		if creds.OAuth2 == nil || creds.OAuth2.Token == nil {
+			return nil, fmt.Errorf("no OAuth2 token - please run: tmail auth --oauth2")
+		}
+		return &OAuth2Authenticator{Username: creds.Email, Account: creds.Account, OAuth2: creds.OAuth2}, nil
+	case creds.PasswordCommand != "":
+		return &CommandAuthenticator{Username: creds.Email, Command: creds.PasswordCommand}, nil
+	case creds.AppPassword != "":
+		return &PasswordAuthenticator{Username: creds.Email, Password: creds.AppPassword}, nil
+	default:
+		return nil, fmt.Errorf("missing password - please run: tmail auth")
+	}
+}
+
+// passwordMechanisms are the mechanisms that log in with a plain password
+var passwordMechanisms = []string{sasl.Plain, sasl.Login}
+
+// passwordClient returns a PLAIN or LOGIN client for a password
+func passwordClient(mechanism, username, password string) (sasl.Client, error) {
+	switch mechanism {
+	case sasl.Plain:
+		return sasl.NewPlainClient("", username, password), nil
+	case sasl.Login:
+		return sasl.NewLoginClient(username, password), nil
+	default:
+		return nil, fmt.Errorf("mechanism %s cannot be used with a password", mechanism)
+	}
+}
+
+// PasswordAuthenticator logs in with a stored app password
+type PasswordAuthenticator struct {
+	Username string
+	Password string
+}
+
+func (a *PasswordAuthenticator) Mechanisms() []string {
+	return passwordMechanisms
+}
+
+func (a *PasswordAuthenticator) SASLClient(mechanism, host string, port int) (sasl.Client, error) {
+	return passwordClient(mechanism, a.Username, a.Password)
+}
+
+// CommandAuthenticator logs in with a password printed by an external
+// command, such as `pass show mail/work`, so it never has to be stored
+type CommandAuthenticator struct {
+	Username string
+	Command  string
+}
+
+func (a *CommandAuthenticator) Mechanisms() []string {
+	return passwordMechanisms
+}
+
+func (a *CommandAuthenticator) SASLClient(mechanism, host string, port int) (sasl.Client, error) {
+	password, err := runSecretCommand("password", a.Command, 0)
+	if err != nil {
+		return nil, err
+	}
+	return passwordClient(mechanism, a.Username, password)
+}
+
+// OAuth2Authenticator logs in with an OAuth2 access token. The token either
+// comes from TokenCommand, or from OAuth2, where it is refreshed when it has
+// expired and saved back to Account.
+type OAuth2Authenticator struct {
+	Username     string
+	Account      string
+	OAuth2       *OAuth2Credentials
+	TokenCommand string
+
+	mu sync.Mutex // Connections logging in at once refresh the token only once
+}
+
+func (a *OAuth2Authenticator) Mechanisms() []string {
+	return []string{XOAuth2, sasl.OAuthBearer}
+}
+
+func (a *OAuth2Authenticator) SASLClient(mechanism, host string, port int) (sasl.Client, error) {
+	if mechanism != XOAuth2 && mechanism != sasl.OAuthBearer {
+		return nil, fmt.Errorf("mechanism %s cannot be used with OAuth2", mechanism)
+	}
+
+	token, err := a.accessToken()
+	if err != nil {
+		return nil, err
+	}
+
+	if mechanism == sasl.OAuthBearer {
+		return sasl.NewOAuthBearerClient(&sasl.OAuthBearerOptions{
+			Username: a.Username,
+			Token:    token,
+			Host:     host,
+			Port:     port,
+		}), nil
+	}
+	return NewXOAuth2Client(a.Username, token), nil
+}
+
+// accessToken returns a current access token
+func (a *OAuth2Authenticator) accessToken() (string, error) {
+	if a.TokenCommand != "" {
+		return runSecretCommand("oauth token", a.TokenCommand, tokenCacheTTL)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	token, refreshed, err := a.OAuth2.AccessToken(context.Background())
+	if err != nil {
+		return "", err
+	}
+	if refreshed && a.Account != "" {
+		if err := SaveOAuth2Token(a.Account, a.OAuth2.Token); err != nil {
+			log.Printf("Unable to save refreshed OAuth2 token: %v", err)
+		}
+	}
+	return token, nil
+}
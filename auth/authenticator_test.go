@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-sasl"
+	"golang.org/x/oauth2"
+)
+
+func TestNewAuthenticator(t *testing.T) {
+	oauth := &OAuth2Credentials{Token: &oauth2.Token{AccessToken: "token", Expiry: time.Now().Add(time.Hour)}}
+
+	tests := []struct {
+		name  string
+		creds Credentials
+		want  string // Type of the authenticator, empty for an error
+	}{
+		{"app password", Credentials{Email: "a@example.com", AppPassword: "pw"}, "*auth.PasswordAuthenticator"},
+		{"password command", Credentials{Email: "a@example.com", PasswordCommand: "echo pw"}, "*auth.CommandAuthenticator"},
+		{"oauth2", Credentials{Email: "a@example.com", AuthMethod: AuthMethodOAuth2, OAuth2: oauth}, "*auth.OAuth2Authenticator"},
+		{"missing secret", Credentials{Email: "a@example.com"}, ""},
+		{"missing email", Credentials{AppPassword: "pw"}, ""},
+		{"oauth2 without token", Credentials{Email: "a@example.com", AuthMethod: AuthMethodOAuth2}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := NewAuthenticator(tt.creds)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("Expected error, got %T", a)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewAuthenticator returned error: %v", err)
+			}
+			if got := fmt.Sprintf("%T", a); got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPasswordAuthenticatorClients(t *testing.T) {
+	a := &PasswordAuthenticator{Username: "me@example.com", Password: "secret"}
+
+	client, err := a.SASLClient(sasl.Plain, "mail.example.com", 993)
+	if err != nil {
+		t.Fatalf("Failed to create PLAIN client: %v", err)
+	}
+	mech, ir, _ := client.Start()
+	if mech != sasl.Plain || string(ir) != "\x00me@example.com\x00secret" {
+		t.Errorf("Unexpected PLAIN start %s %q", mech, ir)
+	}
+
+	if _, err := a.SASLClient(XOAuth2, "mail.example.com", 993); err == nil {
+		t.Error("Expected error asking a password for an XOAUTH2 client")
+	}
+}
+
+func TestCommandAuthenticator(t *testing.T) {
+	a := &CommandAuthenticator{Username: "me@example.com", Command: "printf 'from-command\\nignored\\n'"}
+
+	client, err := a.SASLClient(sasl.Plain, "mail.example.com", 993)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	_, ir, _ := client.Start()
+	if string(ir) != "\x00me@example.com\x00from-command" {
+		t.Errorf("Expected the first line of the command output, got %q", ir)
+	}
+
+	a.Command = "exit 3"
+	if _, err := a.SASLClient(sasl.Plain, "mail.example.com", 993); err == nil {
+		t.Error("Expected error from a failing command")
+	}
+}
+
+func TestNegotiateMechanism(t *testing.T) {
+	a := &PasswordAuthenticator{}
+
+	got := NegotiateMechanism(a, func(m string) bool { return m == sasl.Login })
+	if got != sasl.Login {
+		t.Errorf("Expected LOGIN, got %q", got)
+	}
+	got = NegotiateMechanism(a, func(m string) bool { return true })
+	if got != sasl.Plain {
+		t.Errorf("Expected PLAIN to be preferred, got %q", got)
+	}
+	if got := NegotiateMechanism(a, func(string) bool { return false }); got != "" {
+		t.Errorf("Expected no mechanism, got %q", got)
+	}
+}
+
+func TestOAuth2AuthenticatorConcurrentRefresh(t *testing.T) {
+	useTempHome(t)
+	tokens := newTokenServer(t)
+	var refreshes atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		refreshes.Add(1)
+		tokens.Config.Handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	oauth := &OAuth2Credentials{
+		ClientID: "client",
+		TokenURL: srv.URL,
+		Token:    &oauth2.Token{AccessToken: "stale-token", RefreshToken: "refresh-me", Expiry: time.Now().Add(-time.Hour)},
+	}
+	creds := Credentials{Email: "me@example.com", AuthMethod: AuthMethodOAuth2, OAuth2: oauth}
+	if err := SaveAccount("personal", creds); err != nil {
+		t.Fatalf("Failed to save account: %v", err)
+	}
+	a := &OAuth2Authenticator{Username: creds.Email, Account: "personal", OAuth2: oauth}
+
+	// The main connection and the IDLE connection log in at the same time
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := a.SASLClient(XOAuth2, "mail.example.com", 993); err != nil {
+				t.Errorf("Failed to create XOAUTH2 client: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := refreshes.Load(); n != 1 {
+		t.Errorf("Token refreshed %d times, want once", n)
+	}
+	saved, err := LoadAccount("personal")
+	if err != nil || saved.OAuth2 == nil || saved.OAuth2.Token.AccessToken != "fresh-token" {
+		t.Errorf("Expected the refreshed token to be saved, got %+v (%v)", saved.OAuth2, err)
+	}
+}
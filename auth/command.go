@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// secretCommandTimeout bounds how long a password or token command may run.
+// It is generous because helpers like the 1Password CLI may wait for the
+// user to approve access.
+var secretCommandTimeout = 30 * time.Second
+
+// tokenCacheTTL is how long the output of an oauth_token_command is reused.
+// Access tokens expire, so unlike passwords they are not kept for the whole
+// process; the helpers cache and refresh tokens themselves.
+const tokenCacheTTL = 5 * time.Minute
+
+// maxStderr caps how much of a failing command's stderr ends up in the error
+const maxStderr = 512
+
+// cachedSecret is the output of a secret command and when it stops being valid
+type cachedSecret struct {
+	value   string
+	expires time.Time // Zero for secrets kept for the process lifetime
+}
+
+var (
+	secretCacheMu sync.Mutex
+	secretCache   = map[string]cachedSecret{}
+)
+
+// runSecretCommand runs command through the shell and returns the first line
+// it prints. The result is cached for ttl, or for the process lifetime when
+// ttl is zero, so helpers that prompt the user only do so once.
+func runSecretCommand(kind, command string, ttl time.Duration) (string, error) {
+	secretCacheMu.Lock()
+	defer secretCacheMu.Unlock()
+
+	if cached, ok := secretCache[command]; ok {
+		if cached.expires.IsZero() || time.Now().Before(cached.expires) {
+			return cached.value, nil
+		}
+		delete(secretCache, command)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), secretCommandTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// Don't wait forever on grandchildren that inherited the output pipes
+	cmd.WaitDelay = time.Second
+
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", fmt.Errorf("%s command timed out after %v", kind, secretCommandTimeout)
+		}
+		if msg := lastBytes(strings.TrimSpace(stderr.String()), maxStderr); msg != "" {
+			return "", fmt.Errorf("%s command failed: %v: %s", kind, err, msg)
+		}
+		return "", fmt.Errorf("%s command failed: %v", kind, err)
+	}
+
+	secret := strings.TrimSpace(strings.SplitN(stdout.String(), "\n", 2)[0])
+	if secret == "" {
+		return "", fmt.Errorf("%s command printed nothing", kind)
+	}
+
+	cached := cachedSecret{value: secret}
+	if ttl > 0 {
+		cached.expires = time.Now().Add(ttl)
+	}
+	secretCache[command] = cached
+	return secret, nil
+}
+
+// lastBytes returns at most the last n bytes of s
+func lastBytes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return "..." + s[len(s)-n:]
+}
@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeHelper writes an executable shell script and returns its path
+func writeHelper(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "helper.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0700); err != nil {
+		t.Fatalf("Failed to write helper: %v", err)
+	}
+	return path
+}
+
+func resetSecretCache(t *testing.T) {
+	secretCache = map[string]cachedSecret{}
+	t.Cleanup(func() { secretCache = map[string]cachedSecret{} })
+}
+
+func TestRunSecretCommandCachesOutput(t *testing.T) {
+	resetSecretCache(t)
+	counter := filepath.Join(t.TempDir(), "runs")
+	helper := writeHelper(t, `echo run >> "`+counter+`"; echo "s3cret"; echo "second line"`)
+
+	for i := 0; i < 3; i++ {
+		secret, err := runSecretCommand("password", helper, 0)
+		if err != nil {
+			t.Fatalf("Command failed: %v", err)
+		}
+		if secret != "s3cret" {
+			t.Errorf("Expected the first line of output, got %q", secret)
+		}
+	}
+
+	runs, _ := os.ReadFile(counter)
+	if n := strings.Count(string(runs), "run"); n != 1 {
+		t.Errorf("Expected the helper to run once, ran %d times", n)
+	}
+
+	// Expired token output is fetched again
+	secretCache[helper] = cachedSecret{value: "old", expires: time.Now().Add(-time.Second)}
+	secret, err := runSecretCommand("oauth token", helper, tokenCacheTTL)
+	if err != nil || secret != "s3cret" {
+		t.Errorf("Expected a fresh secret, got %q (%v)", secret, err)
+	}
+}
+
+func TestRunSecretCommandErrors(t *testing.T) {
+	resetSecretCache(t)
+
+	_, err := runSecretCommand("password", writeHelper(t, `echo "vault is locked" >&2; exit 1`), 0)
+	if err == nil || !strings.Contains(err.Error(), "vault is locked") {
+		t.Errorf("Expected the helper's stderr in the error, got %v", err)
+	}
+
+	_, err = runSecretCommand("password", writeHelper(t, `exit 0`), 0)
+	if err == nil || !strings.Contains(err.Error(), "printed nothing") {
+		t.Errorf("Expected an error for empty output, got %v", err)
+	}
+
+	original := secretCommandTimeout
+	secretCommandTimeout = 200 * time.Millisecond
+	defer func() { secretCommandTimeout = original }()
+
+	start := time.Now()
+	_, err = runSecretCommand("password", writeHelper(t, `sleep 10; echo late`), 0)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected a timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Timed out command took %v to return", elapsed)
+	}
+}
@@ -7,40 +7,90 @@ import (
 	"golang.org/x/term"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
-// TODO: Create an interface for auth that allows for multiple provider authentication.
+// DefaultAccountName is the account name used for credentials saved before
+// tmail supported multiple accounts, and for the first account set up.
+const DefaultAccountName = "default"
 
 // Credentials stores user authentication information
 type Credentials struct {
-	Email       string `json:"email"`
-	AppPassword string `json:"app_password"`
-	Name        string `json:"name"`
+	Account           string             `json:"-"` // Name of the account, set when loaded
+	Email             string             `json:"email"`
+	AppPassword       string             `json:"app_password,omitempty"`
+	PasswordCommand   string             `json:"password_command,omitempty"`    // Prints the password, used instead of AppPassword
+	OAuthTokenCommand string             `json:"oauth_token_command,omitempty"` // Prints an OAuth2 access token, used instead of OAuth2
+	Name              string             `json:"name"`
+	Signature         string             `json:"signature,omitempty"`
+	AuthMethod        string             `json:"auth_method,omitempty"` // password (default) or oauth2
+	OAuth2            *OAuth2Credentials `json:"oauth2,omitempty"`
 }
 
-var isAuthed = false
+// UsesOAuth2 reports whether the account authenticates with OAuth2 tokens
+func (c *Credentials) UsesOAuth2() bool {
+	return c.AuthMethod == AuthMethodOAuth2 || c.OAuthTokenCommand != ""
+}
 
-// PromptForAuthentication prompts the user for their credentials
-func PromptForAuthentication() error {
-	var creds Credentials
-	reader := bufio.NewReader(os.Stdin)
+// HasSecret reports whether the credentials carry what is needed to log in
+func (c *Credentials) HasSecret() bool {
+	if c.OAuthTokenCommand != "" {
+		return true
+	}
+	if c.UsesOAuth2() {
+		return c.OAuth2 != nil && c.OAuth2.Token != nil
+	}
+	return c.AppPassword != "" || c.PasswordCommand != ""
+}
 
-	// Get name
-	fmt.Print("Hi! Welcome to tmail, what's your name? ")
-	name, err := reader.ReadString('\n')
+// credentialStore is the on-disk layout of credentials.json
+type credentialStore struct {
+	Default  string                 `json:"default"`
+	Accounts map[string]Credentials `json:"accounts"`
+}
+
+// activeAccount is the account selected with --account, empty means the default
+var activeAccount string
+
+// SetActiveAccount selects the account used by LoadUser for the rest of the process.
+func SetActiveAccount(name string) {
+	activeAccount = name
+}
+
+// ActiveAccount returns the name of the account LoadUser will load: the one
+// selected with SetActiveAccount, otherwise the stored default.
+func ActiveAccount() (string, error) {
+	if activeAccount != "" {
+		return activeAccount, nil
+	}
+	store, err := loadStore()
 	if err != nil {
-		return fmt.Errorf("failed to read name: %v", err)
+		return "", err
 	}
-	creds.Name = strings.TrimSpace(name)
+	if store.Default == "" {
+		return DefaultAccountName, nil
+	}
+	return store.Default, nil
+}
 
-	// Get email
-	fmt.Printf("Thanks %s! What is your email address? ", creds.Name)
-	email, err := reader.ReadString('\n')
+// PromptForAuthentication prompts the user for the credentials of the active account
+func PromptForAuthentication() error {
+	name, err := ActiveAccount()
 	if err != nil {
-		return fmt.Errorf("failed to read email: %v", err)
+		// A missing or unreadable store just means this is the first account
+		name = DefaultAccountName
+	}
+	return PromptForAccount(name)
+}
+
+// PromptForAccount prompts the user for the credentials of the named account
+func PromptForAccount(account string) error {
+	reader := bufio.NewReader(os.Stdin)
+	creds, err := promptIdentity(reader)
+	if err != nil {
+		return err
 	}
-	creds.Email = strings.TrimSpace(email)
 
 	// Get app password
 	fmt.Print("What is your Gmail app password? If you haven't set one up, please see the README for instructions. ")
@@ -49,8 +99,17 @@ func PromptForAuthentication() error {
 		return fmt.Errorf("failed to read password: %v", err)
 	}
 	creds.AppPassword = strings.TrimSpace(string(bytePassword))
+
+	// Get signature
+	fmt.Print("\nSignature to append to new messages (press Enter to skip): ")
+	signature, err := reader.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read signature: %v", err)
+	}
+	creds.Signature = strings.TrimSpace(signature)
+
 	// Confirm the information
-	fmt.Printf("\nName: %s\nEmail: %s\nPassword: (hidden)\n", creds.Name, creds.Email)
+	fmt.Printf("\nAccount: %s\nName: %s\nEmail: %s\nPassword: (hidden)\n", account, creds.Name, creds.Email)
 	fmt.Print("Is this information correct? (y/n): ")
 	confirm, err := reader.ReadString('\n')
 	if err != nil {
@@ -62,7 +121,42 @@ func PromptForAuthentication() error {
 	}
 
 	// Save credentials
-	if err := SaveCredentials(creds); err != nil {
+	if err := SaveAccount(account, creds); err != nil {
+		return fmt.Errorf("failed to save credentials: %v", err)
+	}
+
+	fmt.Println("Authentication setup complete!")
+	return nil
+}
+
+// PromptForCommandAccount sets up the named account to get its password or
+// OAuth2 access token from a helper command instead of storing it
+func PromptForCommandAccount(account, passwordCommand, tokenCommand string) error {
+	reader := bufio.NewReader(os.Stdin)
+	creds, err := promptIdentity(reader)
+	if err != nil {
+		return err
+	}
+	creds.PasswordCommand = passwordCommand
+	creds.OAuthTokenCommand = tokenCommand
+
+	// Make sure the helper works before saving it
+	authenticator, err := NewAuthenticator(creds)
+	if err != nil {
+		return err
+	}
+	if _, err := authenticator.SASLClient(authenticator.Mechanisms()[0], "", 0); err != nil {
+		return err
+	}
+
+	fmt.Print("Signature to append to new messages (press Enter to skip): ")
+	signature, err := reader.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read signature: %v", err)
+	}
+	creds.Signature = strings.TrimSpace(signature)
+
+	if err := SaveAccount(account, creds); err != nil {
 		return fmt.Errorf("failed to save credentials: %v", err)
 	}
 
@@ -70,6 +164,29 @@ func PromptForAuthentication() error {
 	return nil
 }
 
+// promptIdentity asks for the user's name and email address
+func promptIdentity(reader *bufio.Reader) (Credentials, error) {
+	var creds Credentials
+
+	// Get name
+	fmt.Print("Hi! Welcome to tmail, what's your name? ")
+	name, err := reader.ReadString('\n')
+	if err != nil {
+		return creds, fmt.Errorf("failed to read name: %v", err)
+	}
+	creds.Name = strings.TrimSpace(name)
+
+	// Get email
+	fmt.Printf("Thanks %s! What is your email address? ", creds.Name)
+	email, err := reader.ReadString('\n')
+	if err != nil {
+		return creds, fmt.Errorf("failed to read email: %v", err)
+	}
+	creds.Email = strings.TrimSpace(email)
+
+	return creds, nil
+}
+
 // getConfigDir returns the directory where configuration is stored
 func getConfigDir() (string, error) {
 	// Get user's home directory
@@ -99,65 +216,206 @@ func getCredentialsPath() (string, error) {
 	return filepath.Join(configDir, "credentials.json"), nil
 }
 
-// SaveCredentials stores user credentials to the config file
-func SaveCredentials(creds Credentials) error {
+// loadStore reads every stored account, unlocking the file first when it is
+// encrypted. A missing file yields an empty store, and a pre-multi-account
+// file is read as the default account.
+func loadStore() (*credentialStore, error) {
+	path, err := getCredentialsPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &credentialStore{Accounts: map[string]Credentials{}}, nil
+		}
+		return nil, fmt.Errorf("unable to read credentials file: %v", err)
+	}
+
+	if isEncryptedStore(data) {
+		if data, err = decryptStore(data); err != nil {
+			return nil, err
+		}
+	} else {
+		unlockedKey = nil
+	}
+
+	// Unmarshal JSON
+	store := &credentialStore{}
+	if err := json.Unmarshal(data, store); err != nil {
+		return nil, fmt.Errorf("unable to parse credentials file: %v", err)
+	}
+
+	if store.Accounts == nil {
+		// Legacy single-account file
+		var legacy Credentials
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			return nil, fmt.Errorf("unable to parse credentials file: %v", err)
+		}
+		store.Accounts = map[string]Credentials{}
+		if legacy.Email != "" {
+			store.Accounts[DefaultAccountName] = legacy
+			store.Default = DefaultAccountName
+		}
+	}
+
+	return store, nil
+}
+
+// saveStore writes every account back to the credentials file, encrypted
+// when it was unlocked with a passphrase
+func saveStore(store *credentialStore) error {
 	path, err := getCredentialsPath()
 	if err != nil {
 		return err
 	}
 
-	data, err := json.MarshalIndent(creds, "", "  ")
+	data, err := json.MarshalIndent(store, "", "  ")
 	if err != nil {
 		return fmt.Errorf("unable to marshal credentials: %v", err)
 	}
 
+	if unlockedKey != nil {
+		if data, err = encryptStore(data, unlockedKey); err != nil {
+			return fmt.Errorf("unable to encrypt credentials: %v", err)
+		}
+	}
+
 	if err := os.WriteFile(path, data, 0600); err != nil {
 		return fmt.Errorf("unable to write credentials file: %v", err)
 	}
 
-	isAuthed = true
-
 	return nil
 }
 
-// LoadCredentials reads user credentials from the config file
-func LoadUser() (Credentials, error) {
-	path, err := getCredentialsPath()
-	var creds Credentials
-	if isAuthed == true {
-		return creds, nil
+// SaveCredentials stores the credentials of the active account
+func SaveCredentials(creds Credentials) error {
+	name, err := ActiveAccount()
+	if err != nil {
+		return err
+	}
+	return SaveAccount(name, creds)
+}
+
+// SaveAccount stores credentials under the given account name. The first
+// account saved becomes the default.
+func SaveAccount(name string, creds Credentials) error {
+	if name == "" {
+		return fmt.Errorf("account name cannot be empty")
 	}
+
+	store, err := loadStore()
+	if err != nil {
+		return err
+	}
+
+	store.Accounts[name] = creds
+	if _, ok := store.Accounts[store.Default]; !ok {
+		store.Default = name
+	}
+
+	return saveStore(store)
+}
+
+// LoadUser reads the credentials of the active account
+func LoadUser() (Credentials, error) {
+	name, err := ActiveAccount()
 	if err != nil {
 		return Credentials{}, err
 	}
+	return LoadAccount(name)
+}
 
-	// Read file
-	data, err := os.ReadFile(path)
+// LoadAccount reads the credentials of the named account
+func LoadAccount(name string) (Credentials, error) {
+	store, err := loadStore()
 	if err != nil {
-		return Credentials{}, fmt.Errorf("unable to read credentials file: %v", err)
+		return Credentials{}, err
 	}
 
-	// Unmarshal JSON
-	if err := json.Unmarshal(data, &creds); err != nil {
-		return Credentials{}, fmt.Errorf("unable to parse credentials file: %v", err)
+	creds, ok := store.Accounts[name]
+	if !ok {
+		return Credentials{}, fmt.Errorf("no credentials found for account %q", name)
 	}
+	creds.Account = name
 
 	return creds, nil
 }
 
-// RemoveCredentials deletes the credentials file
-func RemoveCredentials() error {
-	if isAuthed == false {
-		return nil
+// ListAccounts returns the stored account names in alphabetical order along with the default account
+func ListAccounts() ([]string, string, error) {
+	store, err := loadStore()
+	if err != nil {
+		return nil, "", err
 	}
-	path, err := getCredentialsPath()
+
+	names := make([]string, 0, len(store.Accounts))
+	for name := range store.Accounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, store.Default, nil
+}
+
+// SetDefaultAccount makes the named account the one used when --account is not given
+func SetDefaultAccount(name string) error {
+	store, err := loadStore()
 	if err != nil {
 		return err
 	}
 
-	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("unable to remove credentials file: %v", err)
+	if _, ok := store.Accounts[name]; !ok {
+		return fmt.Errorf("no credentials found for account %q", name)
 	}
-	isAuthed = false
-	return nil
+	store.Default = name
+
+	return saveStore(store)
+}
+
+// RemoveAccount deletes the named account. If it was the default, the
+// alphabetically first remaining account takes over.
+func RemoveAccount(name string) error {
+	store, err := loadStore()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := store.Accounts[name]; !ok {
+		return fmt.Errorf("no credentials found for account %q", name)
+	}
+	delete(store.Accounts, name)
+
+	if store.Default == name {
+		store.Default = ""
+		names := make([]string, 0, len(store.Accounts))
+		for other := range store.Accounts {
+			names = append(names, other)
+		}
+		sort.Strings(names)
+		if len(names) > 0 {
+			store.Default = names[0]
+		}
+	}
+
+	return saveStore(store)
+}
+
+// RemoveCredentials deletes the credentials of the active account
+func RemoveCredentials() error {
+	name, err := ActiveAccount()
+	if err != nil {
+		return err
+	}
+
+	store, err := loadStore()
+	if err != nil {
+		return err
+	}
+	if _, ok := store.Accounts[name]; !ok {
+		return nil // Nothing to remove
+	}
+
+	return RemoveAccount(name)
 }
@@ -77,3 +77,113 @@ func TestCredentialsSaveLoad(t *testing.T) {
 		t.Errorf("Credentials file still exists after removal")
 	}
 }
+
+// useTempHome points the config directory at a fresh temp dir for the test
+func useTempHome(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_RUNTIME_DIR", filepath.Join(home, "run"))
+	activeAccount = ""
+	unlockedKey = nil
+	t.Cleanup(func() {
+		activeAccount = ""
+		unlockedKey = nil
+	})
+	return home
+}
+
+func TestMultipleAccounts(t *testing.T) {
+	useTempHome(t)
+
+	work := Credentials{Email: "me@work.example", AppPassword: "work-pw", Name: "Me", Signature: "Work Me"}
+	personal := Credentials{Email: "me@home.example", AppPassword: "home-pw", Name: "Me"}
+
+	if err := SaveAccount("work", work); err != nil {
+		t.Fatalf("Failed to save work account: %v", err)
+	}
+	if err := SaveAccount("personal", personal); err != nil {
+		t.Fatalf("Failed to save personal account: %v", err)
+	}
+
+	names, defaultName, err := ListAccounts()
+	if err != nil {
+		t.Fatalf("Failed to list accounts: %v", err)
+	}
+	if len(names) != 2 || names[0] != "personal" || names[1] != "work" {
+		t.Errorf("Expected [personal work], got %v", names)
+	}
+	if defaultName != "work" {
+		t.Errorf("Expected the first saved account to be the default, got %q", defaultName)
+	}
+
+	// LoadUser follows the default until another account is selected
+	creds, err := LoadUser()
+	if err != nil {
+		t.Fatalf("Failed to load user: %v", err)
+	}
+	work.Account = "work"
+	if creds != work {
+		t.Errorf("Expected work credentials, got %+v", creds)
+	}
+
+	SetActiveAccount("personal")
+	creds, err = LoadUser()
+	if err != nil {
+		t.Fatalf("Failed to load user: %v", err)
+	}
+	if creds.Email != personal.Email {
+		t.Errorf("Expected personal credentials, got %+v", creds)
+	}
+	SetActiveAccount("")
+
+	if err := SetDefaultAccount("personal"); err != nil {
+		t.Fatalf("Failed to set default account: %v", err)
+	}
+	if err := SetDefaultAccount("missing"); err == nil {
+		t.Error("Expected error setting a missing account as default")
+	}
+
+	if err := RemoveAccount("personal"); err != nil {
+		t.Fatalf("Failed to remove account: %v", err)
+	}
+	names, defaultName, _ = ListAccounts()
+	if len(names) != 1 || defaultName != "work" {
+		t.Errorf("Expected work to take over as default, got %v / %q", names, defaultName)
+	}
+	if _, err := LoadAccount("personal"); err == nil {
+		t.Error("Expected error loading a removed account")
+	}
+}
+
+func TestLegacyCredentialsFile(t *testing.T) {
+	home := useTempHome(t)
+
+	legacy := Credentials{Email: "old@example.com", AppPassword: "old-pw", Name: "Old"}
+	data, _ := json.Marshal(legacy)
+	dir := filepath.Join(home, ".config", "tmail")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatalf("Failed to create config dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "credentials.json"), data, 0600); err != nil {
+		t.Fatalf("Failed to write legacy credentials: %v", err)
+	}
+
+	creds, err := LoadUser()
+	if err != nil {
+		t.Fatalf("Failed to load legacy credentials: %v", err)
+	}
+	legacy.Account = DefaultAccountName
+	if creds != legacy {
+		t.Errorf("Expected %+v, got %+v", legacy, creds)
+	}
+
+	// Saving another account keeps the legacy one as the default
+	if err := SaveAccount("work", Credentials{Email: "me@work.example", AppPassword: "pw"}); err != nil {
+		t.Fatalf("Failed to save account: %v", err)
+	}
+	names, defaultName, _ := ListAccounts()
+	if len(names) != 2 || defaultName != DefaultAccountName {
+		t.Errorf("Expected legacy account to stay default, got %v / %q", names, defaultName)
+	}
+}
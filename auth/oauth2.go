@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Supported values for Credentials.AuthMethod
+const (
+	AuthMethodPassword = "password" // App password, the default
+	AuthMethodOAuth2   = "oauth2"   // OAuth2 access token used with XOAUTH2/OAUTHBEARER
+)
+
+// Google's OAuth2 endpoints and the scope that grants IMAP and SMTP access
+const (
+	GoogleAuthURL   = "https://accounts.google.com/o/oauth2/auth"
+	GoogleTokenURL  = "https://oauth2.googleapis.com/token"
+	GoogleMailScope = "https://mail.google.com/"
+)
+
+// authorizeTimeout bounds how long we wait for the browser to come back
+const authorizeTimeout = 5 * time.Minute
+
+// OAuth2Credentials stores the OAuth2 client registration and the current token
+type OAuth2Credentials struct {
+	ClientID     string        `json:"client_id"`
+	ClientSecret string        `json:"client_secret,omitempty"`
+	AuthURL      string        `json:"auth_url"`
+	TokenURL     string        `json:"token_url"`
+	Scopes       []string      `json:"scopes"`
+	Token        *oauth2.Token `json:"token,omitempty"`
+}
+
+// config builds the oauth2 client configuration for the given redirect URL
+func (o *OAuth2Credentials) config(redirectURL string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     o.ClientID,
+		ClientSecret: o.ClientSecret,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  o.AuthURL,
+			TokenURL: o.TokenURL,
+		},
+		RedirectURL: redirectURL,
+		Scopes:      o.Scopes,
+	}
+}
+
+// AccessToken returns a valid access token, refreshing it with the refresh
+// token when it has expired. The second return value reports whether the
+// token was refreshed and should be saved. Callers sharing the credentials
+// between connections must not call it at the same time.
+func (o *OAuth2Credentials) AccessToken(ctx context.Context) (string, bool, error) {
+	if o.Token == nil {
+		return "", false, fmt.Errorf("no OAuth2 token - please run: tmail auth --oauth2")
+	}
+	if o.Token.Valid() {
+		return o.Token.AccessToken, false, nil
+	}
+
+	token, err := o.config("").TokenSource(ctx, o.Token).Token()
+	if err != nil {
+		return "", false, fmt.Errorf("failed to refresh OAuth2 token: %v", err)
+	}
+	o.Token = token
+	return token.AccessToken, true, nil
+}
+
+// AuthorizeOAuth2 runs the authorization-code flow with PKCE. It listens on a
+// loopback port for the redirect, hands the consent URL to open (which should
+// send the user's browser there) and exchanges the returned code for a token.
+func AuthorizeOAuth2(ctx context.Context, o *OAuth2Credentials, open func(url string) error) error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("unable to start redirect listener: %v", err)
+	}
+	defer listener.Close()
+
+	redirectURL := fmt.Sprintf("http://%s/", listener.Addr().String())
+	config := o.config(redirectURL)
+
+	state, err := randomState()
+	if err != nil {
+		return err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	type result struct {
+		code string
+		err  error
+	}
+	results := make(chan result, 1)
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		var res result
+		switch {
+		case query.Get("state") != state:
+			res.err = fmt.Errorf("OAuth2 redirect has an unexpected state")
+		case query.Get("error") != "":
+			res.err = fmt.Errorf("authorization denied: %s", query.Get("error"))
+		case query.Get("code") == "":
+			res.err = fmt.Errorf("OAuth2 redirect is missing the authorization code")
+		default:
+			res.code = query.Get("code")
+		}
+
+		if res.err != nil {
+			http.Error(w, res.err.Error(), http.StatusBadRequest)
+		} else {
+			fmt.Fprintln(w, "tmail is authorized. You can close this window.")
+		}
+
+		select {
+		case results <- res:
+		default: // Only the first redirect counts
+		}
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	authURL := config.AuthCodeURL(state,
+		oauth2.AccessTypeOffline,
+		oauth2.ApprovalForce,
+		oauth2.S256ChallengeOption(verifier))
+	if err := open(authURL); err != nil {
+		return fmt.Errorf("unable to open authorization page: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, authorizeTimeout)
+	defer cancel()
+
+	var res result
+	select {
+	case res = <-results:
+	case <-ctx.Done():
+		return fmt.Errorf("timed out waiting for authorization")
+	}
+	if res.err != nil {
+		return res.err
+	}
+
+	token, err := config.Exchange(ctx, res.code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return fmt.Errorf("failed to exchange authorization code: %v", err)
+	}
+	if token.RefreshToken == "" {
+		return fmt.Errorf("authorization server did not return a refresh token")
+	}
+
+	o.Token = token
+	return nil
+}
+
+// SaveOAuth2Token stores a refreshed token for the named account without
+// touching any of its other fields.
+func SaveOAuth2Token(account string, token *oauth2.Token) error {
+	store, err := loadStore()
+	if err != nil {
+		return err
+	}
+
+	creds, ok := store.Accounts[account]
+	if !ok || creds.OAuth2 == nil {
+		return fmt.Errorf("no OAuth2 credentials found for account %q", account)
+	}
+	creds.OAuth2.Token = token
+	store.Accounts[account] = creds
+
+	return saveStore(store)
+}
+
+// PromptForOAuth2Account sets up the named account with OAuth2 instead of an app password
+func PromptForOAuth2Account(account string, oauth OAuth2Credentials) error {
+	reader := bufio.NewReader(os.Stdin)
+	creds, err := promptIdentity(reader)
+	if err != nil {
+		return err
+	}
+	creds.AuthMethod = AuthMethodOAuth2
+
+	if oauth.ClientID == "" {
+		fmt.Print("OAuth2 client ID (see the README for how to create one): ")
+		clientID, err := reader.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("failed to read client ID: %v", err)
+		}
+		oauth.ClientID = strings.TrimSpace(clientID)
+	}
+	if oauth.ClientSecret == "" {
+		fmt.Print("OAuth2 client secret (press Enter if there is none): ")
+		secret, err := reader.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("failed to read client secret: %v", err)
+		}
+		oauth.ClientSecret = strings.TrimSpace(secret)
+	}
+
+	err = AuthorizeOAuth2(context.Background(), &oauth, func(url string) error {
+		fmt.Printf("\nOpen this page in your browser to authorize tmail:\n\n  %s\n\n", url)
+		// Opening the browser is a convenience; the printed URL always works
+		openBrowser(url)
+		fmt.Println("Waiting for authorization...")
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	creds.OAuth2 = &oauth
+
+	if err := SaveAccount(account, creds); err != nil {
+		return fmt.Errorf("failed to save credentials: %v", err)
+	}
+
+	fmt.Println("Authentication setup complete!")
+	return nil
+}
+
+// openBrowser tries to open url in the user's default browser
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	return cmd.Start()
+}
+
+// randomState returns an unguessable value for the OAuth2 state parameter
+func randomState() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("unable to generate OAuth2 state: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
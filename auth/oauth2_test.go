@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// newTokenServer starts a fake OAuth2 token endpoint. It hands out
+// "fresh-token" for refresh_token grants and for authorization_code grants
+// carrying the expected code and a PKCE verifier.
+func newTokenServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch r.Form.Get("grant_type") {
+		case "refresh_token":
+			if r.Form.Get("refresh_token") != "refresh-me" {
+				http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+				return
+			}
+		case "authorization_code":
+			if r.Form.Get("code") != "the-code" || r.Form.Get("code_verifier") == "" {
+				http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, `{"error":"unsupported_grant_type"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  "fresh-token",
+			"refresh_token": "refresh-me",
+			"token_type":    "Bearer",
+			"expires_in":    3600,
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestOAuth2AccessTokenRefresh(t *testing.T) {
+	srv := newTokenServer(t)
+
+	o := &OAuth2Credentials{
+		ClientID: "client",
+		TokenURL: srv.URL,
+		Token: &oauth2.Token{
+			AccessToken:  "stale-token",
+			RefreshToken: "refresh-me",
+			Expiry:       time.Now().Add(-time.Hour),
+		},
+	}
+
+	token, refreshed, err := o.AccessToken(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to refresh token: %v", err)
+	}
+	if token != "fresh-token" || !refreshed {
+		t.Errorf("Expected refreshed fresh-token, got %q (refreshed=%v)", token, refreshed)
+	}
+
+	// A valid token is returned as is
+	token, refreshed, err = o.AccessToken(context.Background())
+	if err != nil || token != "fresh-token" || refreshed {
+		t.Errorf("Expected cached token, got %q (refreshed=%v, err=%v)", token, refreshed, err)
+	}
+
+	if _, _, err := (&OAuth2Credentials{}).AccessToken(context.Background()); err == nil {
+		t.Error("Expected error without a token")
+	}
+}
+
+func TestAuthorizeOAuth2(t *testing.T) {
+	srv := newTokenServer(t)
+
+	o := &OAuth2Credentials{
+		ClientID: "client",
+		AuthURL:  srv.URL + "/auth",
+		TokenURL: srv.URL,
+		Scopes:   []string{GoogleMailScope},
+	}
+
+	// Stand in for the browser: follow the consent URL straight back to the
+	// redirect with an authorization code
+	open := func(consent string) error {
+		u, err := url.Parse(consent)
+		if err != nil {
+			return err
+		}
+		q := u.Query()
+		if q.Get("code_challenge") == "" {
+			t.Error("Expected a PKCE code challenge")
+		}
+		redirect := q.Get("redirect_uri") + "?code=the-code&state=" + url.QueryEscape(q.Get("state"))
+		go func() {
+			resp, err := http.Get(redirect)
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+		return nil
+	}
+
+	if err := AuthorizeOAuth2(context.Background(), o, open); err != nil {
+		t.Fatalf("Authorization failed: %v", err)
+	}
+	if o.Token == nil || o.Token.AccessToken != "fresh-token" || o.Token.RefreshToken != "refresh-me" {
+		t.Errorf("Unexpected token %+v", o.Token)
+	}
+}
+
+func TestAuthorizeOAuth2BadState(t *testing.T) {
+	srv := newTokenServer(t)
+	o := &OAuth2Credentials{ClientID: "client", AuthURL: srv.URL + "/auth", TokenURL: srv.URL}
+
+	open := func(consent string) error {
+		u, _ := url.Parse(consent)
+		redirect := u.Query().Get("redirect_uri") + "?code=the-code&state=forged"
+		go func() {
+			resp, err := http.Get(redirect)
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+		return nil
+	}
+
+	if err := AuthorizeOAuth2(context.Background(), o, open); err == nil {
+		t.Error("Expected error for a forged state")
+	}
+}
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/smtp"
+	"strings"
+
+	"github.com/emersion/go-sasl"
+)
+
+// XOAuth2 is the name of Google's pre-standard OAuth2 SASL mechanism
+const XOAuth2 = "XOAUTH2"
+
+// xoauth2Client implements the XOAUTH2 SASL mechanism, which go-sasl lacks.
+// See https://developers.google.com/gmail/imap/xoauth2-protocol
+type xoauth2Client struct {
+	username string
+	token    string
+}
+
+// NewXOAuth2Client returns a SASL client for XOAUTH2 with the given access token
+func NewXOAuth2Client(username, token string) sasl.Client {
+	return &xoauth2Client{username: username, token: token}
+}
+
+func (c *xoauth2Client) Start() (string, []byte, error) {
+	ir := []byte("user=" + c.username + "\x01auth=Bearer " + c.token + "\x01\x01")
+	return XOAuth2, ir, nil
+}
+
+// Next answers the JSON error challenge sent on failure with an empty
+// response so the server can finish the exchange with a proper error.
+func (c *xoauth2Client) Next(challenge []byte) ([]byte, error) {
+	return []byte{}, nil
+}
+
+// smtpAuth adapts an Authenticator to net/smtp's Auth interface
+type smtpAuth struct {
+	auth      Authenticator
+	mechanism string
+	host      string
+	port      int
+	client    sasl.Client
+}
+
+// NewSMTPAuth wraps an Authenticator for use with net/smtp. An empty
+// mechanism picks the first of the authenticator's mechanisms that the server
+// advertises. Like smtp.PlainAuth it refuses to authenticate over an
+// unencrypted connection unless the server is on localhost.
+func NewSMTPAuth(a Authenticator, mechanism, host string, port int) smtp.Auth {
+	return &smtpAuth{auth: a, mechanism: mechanism, host: host, port: port}
+}
+
+func (a *smtpAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !IsLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+
+	mechanism := a.mechanism
+	if mechanism == "" {
+		mechanism = NegotiateMechanism(a.auth, func(m string) bool {
+			for _, advertised := range server.Auth {
+				if strings.EqualFold(advertised, m) {
+					return true
+				}
+			}
+			return false
+		})
+		if mechanism == "" {
+			return "", nil, fmt.Errorf("server supports none of %s", strings.Join(a.auth.Mechanisms(), ", "))
+		}
+	}
+
+	client, err := a.auth.SASLClient(mechanism, a.host, a.port)
+	if err != nil {
+		return "", nil, err
+	}
+	a.client = client
+	return client.Start()
+}
+
+func (a *smtpAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	return a.client.Next(fromServer)
+}
+
+// NegotiateMechanism returns the first of the authenticator's mechanisms the
+// server supports, or "" when there is none
+func NegotiateMechanism(a Authenticator, supported func(mechanism string) bool) string {
+	for _, mechanism := range a.Mechanisms() {
+		if supported(mechanism) {
+			return mechanism
+		}
+	}
+	return ""
+}
+
+// IsLocalhost reports whether a host name is this machine, the only place
+// credentials may be sent without encryption
+func IsLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
@@ -0,0 +1,27 @@
+//go:build !unix
+
+package auth
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// sessionID identifies the terminal session by the shell that started tmail.
+// It cannot be told apart from a later shell with the same process ID.
+func sessionID() (id string, bound bool) {
+	return strconv.Itoa(os.Getppid()), false
+}
+
+// checkPrivateDir makes sure dir is a real directory, not a link elsewhere
+func checkPrivateDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+//go:build unix
+
+package auth
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// sessionID identifies the terminal session, shared by every process started
+// from the same shell. Session IDs are reused once the session leader exits,
+// so the leader's start time is part of the ID where the system provides it;
+// bound reports whether it is.
+func sessionID() (id string, bound bool) {
+	sid, err := unix.Getsid(0)
+	if err != nil {
+		sid = unix.Getppid()
+	}
+	if start := processStartTime(sid); start != "" {
+		return strconv.Itoa(sid) + "-" + start, true
+	}
+	return strconv.Itoa(sid), false
+}
+
+// processStartTime returns when a process started, in clock ticks since
+// boot, from /proc, or "" where there is none
+func processStartTime(pid int) string {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return ""
+	}
+	// The command name in parentheses may contain spaces, so the fields are
+	// counted from after it; the start time is the 22nd field (proc(5))
+	i := strings.LastIndexByte(string(data), ')')
+	if i < 0 {
+		return ""
+	}
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 20 {
+		return ""
+	}
+	return fields[19]
+}
+
+// checkPrivateDir makes sure dir is a real directory owned by the user that
+// only the user can access
+func checkPrivateDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if info.Mode().Perm() != 0700 {
+		return fmt.Errorf("%s is accessible to other users", dir)
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("%s belongs to another user", dir)
+	}
+	return nil
+}
@@ -0,0 +1,362 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+// Parameters for deriving the credentials key from a passphrase with scrypt
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = chacha20poly1305.KeySize
+	saltSize     = 16
+)
+
+// unlockAttempts is how many times a wrong passphrase may be entered
+const unlockAttempts = 3
+
+// sessionCacheTTL bounds how long an unlocked key is remembered for a terminal session
+const sessionCacheTTL = 12 * time.Hour
+
+// unboundSessionCacheTTL bounds it when the session cannot be told apart
+// from a later one with the same ID
+const unboundSessionCacheTTL = time.Hour
+
+// encryptedStore is the on-disk layout of an encrypted credentials.json. The
+// credential store is sealed with XChaCha20-Poly1305 under a key derived
+// from the passphrase with scrypt.
+type encryptedStore struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// storeKey is an unlocked credentials key along with the salt it was derived with
+type storeKey struct {
+	Salt []byte `json:"salt"`
+	Key  []byte `json:"key"`
+}
+
+// unlockedKey is the key of the encrypted store once it has been unlocked in
+// this process. It is nil while the store is in plain text.
+var unlockedKey *storeKey
+
+// readPassphrase asks the user for a passphrase without echoing it
+var readPassphrase = func(prompt string) (string, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("credentials are encrypted and stdin is not a terminal")
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %v", err)
+	}
+	return string(passphrase), nil
+}
+
+// isEncryptedStore reports whether data is an encrypted credentials file
+func isEncryptedStore(data []byte) bool {
+	var probe struct {
+		Ciphertext []byte `json:"ciphertext"`
+	}
+	return json.Unmarshal(data, &probe) == nil && len(probe.Ciphertext) > 0
+}
+
+// deriveKey derives a credentials key from passphrase with scrypt
+func deriveKey(passphrase string, salt []byte, n, r, p int) (*storeKey, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, n, r, p, scryptKeyLen)
+	if err != nil {
+		return nil, fmt.Errorf("unable to derive key: %v", err)
+	}
+	return &storeKey{Salt: salt, Key: key}, nil
+}
+
+// newStoreKey derives a key for passphrase with a fresh random salt
+func newStoreKey(passphrase string) (*storeKey, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("unable to generate salt: %v", err)
+	}
+	return deriveKey(passphrase, salt, scryptN, scryptR, scryptP)
+}
+
+// encryptStore seals plaintext under key
+func encryptStore(plaintext []byte, key *storeKey) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(key.Key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("unable to generate nonce: %v", err)
+	}
+
+	sealed := encryptedStore{
+		Version: 1,
+		KDF:     "scrypt",
+		N:       scryptN,
+		R:       scryptR,
+		P:       scryptP,
+		Salt:    key.Salt,
+		Nonce:   nonce,
+	}
+	sealed.Ciphertext = aead.Seal(nil, nonce, plaintext, sealed.additionalData())
+
+	return json.MarshalIndent(sealed, "", "  ")
+}
+
+// additionalData binds the KDF parameters to the ciphertext
+func (e *encryptedStore) additionalData() []byte {
+	return []byte(fmt.Sprintf("tmail-credentials/v%d/%s/%d/%d/%d", e.Version, e.KDF, e.N, e.R, e.P))
+}
+
+// open decrypts the store with key
+func (e *encryptedStore) open(key *storeKey) ([]byte, error) {
+	if !bytes.Equal(key.Salt, e.Salt) {
+		return nil, fmt.Errorf("key does not belong to this credentials file")
+	}
+	aead, err := chacha20poly1305.NewX(key.Key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, e.Nonce, e.Ciphertext, e.additionalData())
+	if err != nil {
+		return nil, fmt.Errorf("wrong passphrase")
+	}
+	return plaintext, nil
+}
+
+// decryptStore unlocks an encrypted credentials file, trying the key already
+// unlocked in this process, then the terminal session cache, and finally
+// prompting for the passphrase
+func decryptStore(data []byte) ([]byte, error) {
+	var sealed encryptedStore
+	if err := json.Unmarshal(data, &sealed); err != nil {
+		return nil, fmt.Errorf("unable to parse credentials file: %v", err)
+	}
+	if sealed.Version != 1 || sealed.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported credentials encryption %s v%d", sealed.KDF, sealed.Version)
+	}
+
+	for _, key := range []*storeKey{unlockedKey, loadSessionKey()} {
+		if key == nil {
+			continue
+		}
+		if plaintext, err := sealed.open(key); err == nil {
+			unlockedKey = key
+			return plaintext, nil
+		}
+	}
+	clearSessionKey()
+
+	var lastErr error
+	for attempt := 0; attempt < unlockAttempts; attempt++ {
+		passphrase, err := readPassphrase("Passphrase to unlock tmail credentials: ")
+		if err != nil {
+			return nil, err
+		}
+		key, err := deriveKey(passphrase, sealed.Salt, sealed.N, sealed.R, sealed.P)
+		if err != nil {
+			return nil, err
+		}
+		plaintext, err := sealed.open(key)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		unlockedKey = key
+		saveSessionKey(key)
+		return plaintext, nil
+	}
+	return nil, fmt.Errorf("unable to unlock credentials: %v", lastErr)
+}
+
+// EncryptCredentials converts the credentials file to the encrypted format,
+// asking for a new passphrase twice
+func EncryptCredentials() error {
+	store, err := loadStore()
+	if err != nil {
+		return err
+	}
+	if unlockedKey != nil {
+		return fmt.Errorf("credentials are already encrypted")
+	}
+
+	passphrase, err := readPassphrase("New passphrase for tmail credentials: ")
+	if err != nil {
+		return err
+	}
+	if passphrase == "" {
+		return fmt.Errorf("passphrase cannot be empty")
+	}
+	confirm, err := readPassphrase("Repeat the passphrase: ")
+	if err != nil {
+		return err
+	}
+	if passphrase != confirm {
+		return fmt.Errorf("passphrases do not match")
+	}
+
+	key, err := newStoreKey(passphrase)
+	if err != nil {
+		return err
+	}
+	unlockedKey = key
+	if err := saveStore(store); err != nil {
+		unlockedKey = nil
+		return err
+	}
+	saveSessionKey(key)
+	return nil
+}
+
+// CredentialsEncrypted reports whether the credentials file is encrypted
+func CredentialsEncrypted() (bool, error) {
+	path, err := getCredentialsPath()
+	if err != nil {
+		return false, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("unable to read credentials file: %v", err)
+	}
+	return isEncryptedStore(data), nil
+}
+
+// LockCredentials forgets the unlocked key for this process and terminal
+// session so the passphrase is asked for again
+func LockCredentials() {
+	unlockedKey = nil
+	clearSessionKey()
+}
+
+// sessionKeyDir returns the directory remembering unlocked keys. It lives in
+// the user's runtime directory, which is private to the user and usually not
+// backed by a disk, or else in a directory of the user's own under the
+// shared temporary directory. The directory is only used if it belongs to
+// the user and no one else can reach into it, since another user could
+// have created it first.
+func sessionKeyDir() (string, error) {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "tmail-"+strconv.Itoa(os.Getuid()))
+		if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+			return "", err
+		}
+	} else {
+		dir = filepath.Join(dir, "tmail")
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return "", err
+		}
+	}
+	if err := checkPrivateDir(dir); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// sessionKeyPath returns the file remembering the unlocked key for the
+// current terminal session
+func sessionKeyPath() (string, error) {
+	dir, err := sessionKeyDir()
+	if err != nil {
+		return "", err
+	}
+	id, _ := sessionID()
+	return filepath.Join(dir, "session-"+id+".key"), nil
+}
+
+// sessionTTL is how long the key of the current terminal session is
+// remembered. A session whose leader's start time is unknown could be a
+// later one that reused its ID, so its key is kept for less time.
+func sessionTTL() time.Duration {
+	if _, bound := sessionID(); !bound {
+		return unboundSessionCacheTTL
+	}
+	return sessionCacheTTL
+}
+
+// loadSessionKey returns the key cached for this terminal session, if any
+func loadSessionKey() *storeKey {
+	path, err := sessionKeyPath()
+	if err != nil {
+		return nil
+	}
+	info, err := os.Lstat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	if time.Since(info.ModTime()) > sessionTTL() {
+		os.Remove(path)
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var key storeKey
+	if err := json.Unmarshal(data, &key); err != nil || len(key.Key) != scryptKeyLen {
+		return nil
+	}
+	return &key
+}
+
+// saveSessionKey remembers key for the rest of this terminal session. Failing
+// to do so only means the passphrase is asked for again.
+func saveSessionKey(key *storeKey) {
+	path, err := sessionKeyPath()
+	if err != nil {
+		return
+	}
+	data, err := json.Marshal(key)
+	if err != nil {
+		return
+	}
+
+	// Written to a new file and renamed over the old one, so the key never
+	// ends up in a file someone else opened or linked beforehand
+	temp, err := os.CreateTemp(filepath.Dir(path), ".session-*")
+	if err != nil {
+		return
+	}
+	_, err = temp.Write(data)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+	}
+}
+
+// clearSessionKey removes the key cached for this terminal session
+func clearSessionKey() {
+	if path, err := sessionKeyPath(); err == nil {
+		os.Remove(path)
+	}
+}
@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// usePassphrases answers passphrase prompts with the given values in order
+func usePassphrases(t *testing.T, answers ...string) {
+	t.Helper()
+	original := readPassphrase
+	readPassphrase = func(prompt string) (string, error) {
+		if len(answers) == 0 {
+			return "", fmt.Errorf("unexpected prompt %q", prompt)
+		}
+		answer := answers[0]
+		answers = answers[1:]
+		return answer, nil
+	}
+	t.Cleanup(func() { readPassphrase = original })
+}
+
+func TestEncryptedCredentials(t *testing.T) {
+	home := useTempHome(t)
+
+	creds := Credentials{Email: "me@example.com", AppPassword: "super-secret", Name: "Me"}
+	if err := SaveAccount("personal", creds); err != nil {
+		t.Fatalf("Failed to save account: %v", err)
+	}
+
+	usePassphrases(t, "hunter2", "hunter2")
+	if err := EncryptCredentials(); err != nil {
+		t.Fatalf("Failed to encrypt credentials: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(home, ".config", "tmail", "credentials.json"))
+	if err != nil {
+		t.Fatalf("Failed to read credentials file: %v", err)
+	}
+	if bytes.Contains(data, []byte("super-secret")) || bytes.Contains(data, []byte("me@example.com")) {
+		t.Error("Encrypted credentials file contains plain-text secrets")
+	}
+	if encrypted, _ := CredentialsEncrypted(); !encrypted {
+		t.Error("Expected credentials to be reported as encrypted")
+	}
+
+	// A new process in the same terminal session uses the cached key
+	unlockedKey = nil
+	usePassphrases(t)
+	loaded, err := LoadAccount("personal")
+	if err != nil {
+		t.Fatalf("Failed to load with the session key: %v", err)
+	}
+	if loaded.AppPassword != "super-secret" {
+		t.Errorf("Expected the stored password, got %q", loaded.AppPassword)
+	}
+
+	// Accounts saved while unlocked stay encrypted
+	if err := SaveAccount("work", Credentials{Email: "me@work.example", AppPassword: "work-pw"}); err != nil {
+		t.Fatalf("Failed to save account: %v", err)
+	}
+	data, _ = os.ReadFile(filepath.Join(home, ".config", "tmail", "credentials.json"))
+	if bytes.Contains(data, []byte("work-pw")) {
+		t.Error("Account saved after encryption was written in plain text")
+	}
+
+	// After locking, a wrong passphrase is retried
+	LockCredentials()
+	usePassphrases(t, "wrong", "hunter2")
+	names, _, err := ListAccounts()
+	if err != nil {
+		t.Fatalf("Failed to unlock: %v", err)
+	}
+	if len(names) != 2 {
+		t.Errorf("Expected 2 accounts, got %v", names)
+	}
+
+	LockCredentials()
+	usePassphrases(t, "wrong", "wrong", "wrong")
+	if _, err := LoadAccount("personal"); err == nil {
+		t.Error("Expected error after too many wrong passphrases")
+	}
+}
+
+func TestEncryptCredentialsMismatch(t *testing.T) {
+	useTempHome(t)
+
+	if err := SaveAccount("personal", Credentials{Email: "me@example.com", AppPassword: "pw"}); err != nil {
+		t.Fatalf("Failed to save account: %v", err)
+	}
+
+	usePassphrases(t, "one", "two")
+	if err := EncryptCredentials(); err == nil {
+		t.Error("Expected error when the passphrases do not match")
+	}
+	if encrypted, _ := CredentialsEncrypted(); encrypted {
+		t.Error("Credentials should stay in plain text")
+	}
+}
+
+func TestSessionKeyUntrustedDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Directory permissions are Unix-only")
+	}
+	home := useTempHome(t)
+	key := &storeKey{Salt: []byte("salt"), Key: bytes.Repeat([]byte{1}, scryptKeyLen)}
+
+	// A directory others can reach into is not used
+	dir := filepath.Join(home, "run", "tmail")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.Chmod(dir, 0755)
+	saveSessionKey(key)
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Session key written to a shared directory: %d files", len(entries))
+	}
+	if loadSessionKey() != nil {
+		t.Error("Session key loaded from a shared directory")
+	}
+
+	// Nor is a link to somewhere else
+	os.Remove(dir)
+	elsewhere := t.TempDir()
+	if err := os.Symlink(elsewhere, dir); err != nil {
+		t.Fatal(err)
+	}
+	saveSessionKey(key)
+	if entries, _ := os.ReadDir(elsewhere); len(entries) != 0 {
+		t.Error("Session key written through a symlink")
+	}
+}
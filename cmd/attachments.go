@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/jacobbanks/tmail/email"
+	"github.com/spf13/cobra"
+)
+
+var (
+	attachmentsFolder string // Mailbox the email is in
+	attachmentsDir    string // Where to save the attachments
+	attachmentsMatch  string // Pattern the names of the attachments must match
+	attachmentsList   bool   // Only list the attachments
+)
+
+var attachmentsCmd = &cobra.Command{
+	Use:   "attachments <message-id|uid>",
+	Short: "List or save the attachments of an email",
+	Long: `Save the attachments of an email and print a JSON manifest of them, with
+their filename, path, size, content type and sha256.
+
+The email is given by its UID in --folder, as listed by tmail search, or by
+its Message-ID. Attachments are saved to --dir, or the download_dir setting
+(~/Downloads unless configured). Existing files are never overwritten and
+files only appear once complete. With --list nothing is saved.
+
+Errors go to standard error, so standard output is only the manifest.
+Examples:
+  tmail attachments 1234
+  tmail attachments 1234 --list
+  tmail attachments "<CAF=abc@mail.gmail.com>" --folder "[Gmail]/All Mail"
+  tmail attachments 1234 --dir ./invoices --match '*.pdf'`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if _, err := path.Match(attachmentsMatch, ""); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid --match pattern %q\n", attachmentsMatch)
+			os.Exit(1)
+		}
+
+		dir := ""
+		if !attachmentsList {
+			var err error
+			if dir, err = attachmentsDirectory(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		provider, err := email.CreateDefaultMailProvider()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error setting up mail provider:", err)
+			fmt.Fprintln(os.Stderr, "Please run: tmail auth")
+			os.Exit(1)
+		}
+		defer provider.Disconnect()
+
+		msg, err := findEmail(provider, attachmentsFolder, args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		raw, err := provider.FetchRawMessage(msg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error fetching email %s: %v\n", args[0], err)
+			os.Exit(1)
+		}
+
+		saved, err := email.ExtractAttachments(bytes.NewReader(raw), dir, func(part *email.MIMEPart) bool {
+			return matchAttachment(attachmentsMatch, email.SanitizeFilename(part.Filename))
+		})
+
+		// What was saved before an error is still reported
+		if saved == nil {
+			saved = []email.SavedAttachment{}
+		}
+		out := json.NewEncoder(os.Stdout)
+		out.SetIndent("", "  ")
+		out.Encode(saved)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// attachmentsDirectory returns the directory to save attachments to, from
+// --dir or the download_dir setting
+func attachmentsDirectory() (string, error) {
+	if attachmentsDir == "" {
+		config, _ := email.LoadUserConfig()
+		return config.DownloadDirectory()
+	}
+	if err := os.MkdirAll(attachmentsDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create %s: %v", attachmentsDir, err)
+	}
+	return attachmentsDir, nil
+}
+
+// findEmail finds an email by its UID in a mailbox, or by its Message-ID
+func findEmail(provider email.MailProvider, mailbox, id string) (*email.IncomingMessage, error) {
+	if uid, err := strconv.ParseUint(id, 10, 32); err == nil && uid > 0 {
+		status, err := provider.MailboxStatus(mailbox)
+		if err != nil {
+			return nil, err
+		}
+		return &email.IncomingMessage{Mailbox: mailbox, UIDValidity: status.UIDValidity, UID: uint32(uid)}, nil
+	}
+
+	messageID := strings.Trim(strings.TrimSpace(id), "<>")
+	query, err := email.ParseSearchQuery("msgid:" + messageID)
+	if err != nil {
+		return nil, err
+	}
+	emails, err := provider.Search(mailbox, query, 1)
+	if err != nil {
+		return nil, fmt.Errorf("error searching %s: %v", mailbox, err)
+	}
+	if len(emails) == 0 {
+		return nil, fmt.Errorf("no email with Message-ID <%s> in %s", messageID, mailbox)
+	}
+	return emails[0], nil
+}
+
+// matchAttachment reports whether an attachment's name matches a shell
+// pattern such as *.pdf, ignoring case; an empty pattern matches any name
+func matchAttachment(pattern, filename string) bool {
+	if pattern == "" {
+		return true
+	}
+	matched, _ := path.Match(strings.ToLower(pattern), strings.ToLower(filename))
+	return matched
+}
+
+func init() {
+	attachmentsCmd.Flags().StringVar(&attachmentsFolder, "folder", email.InboxName, "Mailbox the email is in, see: tmail folders")
+	attachmentsCmd.Flags().StringVar(&attachmentsDir, "dir", "", "Directory to save the attachments to (default: the download_dir setting)")
+	attachmentsCmd.Flags().StringVar(&attachmentsMatch, "match", "", "Only attachments whose name matches this pattern, e.g. '*.pdf'")
+	attachmentsCmd.Flags().BoolVar(&attachmentsList, "list", false, "List the attachments without saving them")
+	rootCmd.AddCommand(attachmentsCmd)
+}
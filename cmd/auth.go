@@ -5,13 +5,24 @@ import (
 	"os"
 
 	"github.com/jacobbanks/tmail/auth"
+	"github.com/jacobbanks/tmail/email"
 	"github.com/spf13/cobra"
 )
 
 var authCmd = &cobra.Command{
 	Use:   "auth",
 	Short: "Set up email authentication",
-	Long:  "Configure your Gmail account credentials",
+	Long: `Configure the credentials of your email accounts.
+Without a subcommand, sets up the account selected with --account (or the default account).
+Examples:
+  tmail auth
+  tmail auth add work
+  tmail auth add work --oauth2 --client-id <id> --client-secret <secret>
+  tmail auth add work --password-command "pass show mail/work"
+  tmail auth list
+  tmail auth default work
+  tmail auth remove work
+  tmail auth migrate`,
 	Run: func(cmd *cobra.Command, args []string) {
 		_, err := auth.LoadUser()
 		if err == nil {
@@ -24,10 +35,184 @@ var authCmd = &cobra.Command{
 			}
 		}
 
-		err = auth.PromptForAuthentication()
+		name, err := auth.ActiveAccount()
 		if err != nil {
+			name = auth.DefaultAccountName
+		}
+		err = promptForAccount(name)
+		if err != nil {
+			fmt.Printf("Authentication setup failed: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var authAddCmd = &cobra.Command{
+	Use:   "add <account>",
+	Short: "Add a named account",
+	Long: `Add a named account such as work or personal.
+Accounts use Gmail by default; point one at another server with:
+  tmail --account <account> config set provider imap`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if _, err := auth.LoadAccount(args[0]); err == nil {
+			fmt.Printf("Account %s already exists. Remove it first or run: tmail --account %s auth\n", args[0], args[0])
+			os.Exit(1)
+		}
+
+		if err := promptForAccount(args[0]); err != nil {
 			fmt.Printf("Authentication setup failed: %v\n", err)
 			os.Exit(1)
 		}
 	},
 }
+
+var authListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List configured accounts",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		names, defaultName, err := auth.ListAccounts()
+		if err != nil {
+			fmt.Printf("Error loading accounts: %v\n", err)
+			os.Exit(1)
+		}
+		if len(names) == 0 {
+			fmt.Println("No accounts configured. Please run: tmail auth add <account>")
+			return
+		}
+
+		config, _ := email.LoadUserConfig()
+		for _, name := range names {
+			creds, err := auth.LoadAccount(name)
+			if err != nil {
+				continue
+			}
+			marker := " "
+			if name == defaultName {
+				marker = "*"
+			}
+			provider := config.Account(name).Provider
+			if provider == "" {
+				provider = email.ProviderGmail
+			}
+			fmt.Printf("%s %-12s %-30s %s\n", marker, name, creds.Email, provider)
+		}
+	},
+}
+
+var authRemoveCmd = &cobra.Command{
+	Use:   "remove <account>",
+	Short: "Remove a named account",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := auth.RemoveAccount(args[0]); err != nil {
+			fmt.Printf("Error removing account: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Account %s removed.\n", args[0])
+	},
+}
+
+var authDefaultCmd = &cobra.Command{
+	Use:   "default <account>",
+	Short: "Set the default account",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := auth.SetDefaultAccount(args[0]); err != nil {
+			fmt.Printf("Error setting default account: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Default account set to: %s\n", args[0])
+	},
+}
+
+var authMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Encrypt the credentials file with a passphrase",
+	Long: `Convert a plain-text credentials file to the encrypted format.
+The passphrase is asked for once per terminal session when tmail needs the credentials.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		encrypted, err := auth.CredentialsEncrypted()
+		if err != nil {
+			fmt.Printf("Error reading credentials: %v\n", err)
+			os.Exit(1)
+		}
+		if encrypted {
+			fmt.Println("Credentials are already encrypted.")
+			return
+		}
+
+		if err := auth.EncryptCredentials(); err != nil {
+			fmt.Printf("Error encrypting credentials: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("Credentials encrypted.")
+	},
+}
+
+var authLockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Forget the passphrase for this terminal session",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		auth.LockCredentials()
+		fmt.Println("Credentials locked.")
+	},
+}
+
+// Flags shared by auth and auth add
+var (
+	passwordCommand   string
+	tokenCommand      string
+	useOAuth2         bool
+	oauthClientID     string
+	oauthClientSecret string
+	oauthAuthURL      string
+	oauthTokenURL     string
+	oauthScopes       []string
+)
+
+// oauth2Settings builds the OAuth2 client registration from the flags
+func oauth2Settings() auth.OAuth2Credentials {
+	return auth.OAuth2Credentials{
+		ClientID:     oauthClientID,
+		ClientSecret: oauthClientSecret,
+		AuthURL:      oauthAuthURL,
+		TokenURL:     oauthTokenURL,
+		Scopes:       oauthScopes,
+	}
+}
+
+// promptForAccount sets up the named account the way the flags ask for
+func promptForAccount(name string) error {
+	switch {
+	case passwordCommand != "" || tokenCommand != "":
+		return auth.PromptForCommandAccount(name, passwordCommand, tokenCommand)
+	case useOAuth2:
+		return auth.PromptForOAuth2Account(name, oauth2Settings())
+	default:
+		return auth.PromptForAccount(name)
+	}
+}
+
+func init() {
+	for _, c := range []*cobra.Command{authCmd, authAddCmd} {
+		c.Flags().StringVar(&passwordCommand, "password-command", "", "Command that prints the password, e.g. \"pass show mail/work\"")
+		c.Flags().StringVar(&tokenCommand, "oauth-token-command", "", "Command that prints an OAuth2 access token")
+		c.Flags().BoolVar(&useOAuth2, "oauth2", false, "Authenticate with OAuth2 in the browser instead of an app password")
+		c.Flags().StringVar(&oauthClientID, "client-id", "", "OAuth2 client ID (prompted for when empty)")
+		c.Flags().StringVar(&oauthClientSecret, "client-secret", "", "OAuth2 client secret")
+		c.Flags().StringVar(&oauthAuthURL, "auth-url", auth.GoogleAuthURL, "OAuth2 authorization endpoint")
+		c.Flags().StringVar(&oauthTokenURL, "token-url", auth.GoogleTokenURL, "OAuth2 token endpoint")
+		c.Flags().StringSliceVar(&oauthScopes, "scope", []string{auth.GoogleMailScope}, "OAuth2 scopes to request")
+	}
+
+	authCmd.AddCommand(authAddCmd)
+	authCmd.AddCommand(authListCmd)
+	authCmd.AddCommand(authRemoveCmd)
+	authCmd.AddCommand(authDefaultCmd)
+	authCmd.AddCommand(authMigrateCmd)
+	authCmd.AddCommand(authLockCmd)
+}
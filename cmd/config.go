@@ -15,7 +15,13 @@ var configCmd = &cobra.Command{
 Examples:
   tmail config show
   tmail config set theme blue
-  tmail config set default_mails 25`,
+  tmail config set default_mails 25
+  tmail config set download_dir ~/Documents/mail
+  tmail config set provider imap
+  tmail config set imap_host imap.fastmail.com
+  tmail --account work config set provider imap
+
+With --account, provider and server settings apply to that account only.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) == 0 {
 			cmd.Help()
@@ -54,6 +60,25 @@ func showConfig() {
 	fmt.Println("--------------------")
 	fmt.Printf("Theme: %s\n", config.Theme)
 	fmt.Printf("Default emails to fetch: %d\n", config.DefaultNumMails)
+	downloadDir := config.DownloadDir
+	if downloadDir == "" {
+		downloadDir = "~/Downloads"
+	}
+	fmt.Printf("Download directory: %s\n", downloadDir)
+
+	account := email.AccountConfig{Provider: config.Provider, Server: config.Server}
+	if accountName != "" {
+		fmt.Printf("Account: %s\n", accountName)
+		account = config.Account(accountName)
+	}
+	fmt.Printf("Provider: %s\n", account.Provider)
+	if account.Provider == email.ProviderIMAP && account.Server != nil {
+		fmt.Printf("IMAP server: %s (%s)\n", account.Server.GetIMAPAddress(), account.Server.GetIMAPTLSMode())
+		fmt.Printf("SMTP server: %s (%s)\n", account.Server.GetSMTPAddress(), account.Server.GetSMTPTLSMode())
+		if account.Server.AuthMechanism != "" {
+			fmt.Printf("Auth mechanism: %s\n", account.Server.AuthMechanism)
+		}
+	}
 }
 
 func setSetting(setting, value string) {
@@ -63,6 +88,12 @@ func setSetting(setting, value string) {
 		return
 	}
 
+	// Provider and server settings go to the selected account, if any
+	account := email.AccountConfig{Provider: config.Provider, Server: config.Server}
+	if accountName != "" {
+		account = config.Accounts[accountName]
+	}
+
 	switch setting {
 	case "theme":
 		if value != "blue" && value != "dark" && value != "light" {
@@ -80,14 +111,82 @@ func setSetting(setting, value string) {
 		}
 		config.DefaultNumMails = num
 		fmt.Printf("Default emails to fetch set to: %d\n", num)
+
+	case "download_dir":
+		config.DownloadDir = value
+		fmt.Printf("Download directory set to: %s\n", value)
+
+	case "provider":
+		if value != email.ProviderGmail && value != email.ProviderIMAP {
+			fmt.Println("Invalid provider. Valid options: gmail, imap")
+			return
+		}
+		account.Provider = value
+		fmt.Printf("Provider set to: %s\n", value)
+
+	case "imap_host", "imap_port", "imap_tls", "smtp_host", "smtp_port", "smtp_tls", "auth_mechanism":
+		if account.Server == nil {
+			account.Server = &email.Config{}
+		}
+		if !setServerSetting(account.Server, setting, value) {
+			return
+		}
+		fmt.Printf("%s set to: %s\n", setting, value)
+
 	default:
 		fmt.Printf("Unknown setting: %s\n", setting)
-		fmt.Println("Valid settings: theme, default_mails")
+		fmt.Println("Valid settings: theme, default_mails, download_dir, provider, imap_host, imap_port, imap_tls, smtp_host, smtp_port, smtp_tls, auth_mechanism")
 		return
 	}
 
+	if accountName != "" {
+		config.SetAccount(accountName, account)
+	} else {
+		config.Provider = account.Provider
+		config.Server = account.Server
+	}
+
 	if err := email.SaveUserConfig(config); err != nil {
 		fmt.Printf("Error saving configuration: %v\n", err)
 		return
 	}
 }
+
+// setServerSetting updates a single server field, reporting invalid values
+func setServerSetting(server *email.Config, setting, value string) bool {
+	switch setting {
+	case "imap_host":
+		server.IMAPHost = value
+	case "smtp_host":
+		server.SMTPHost = value
+	case "imap_port", "smtp_port":
+		if port, err := strconv.Atoi(value); err != nil || port < 1 || port > 65535 {
+			fmt.Println("Invalid port. Please specify a number between 1 and 65535")
+			return false
+		}
+		if setting == "imap_port" {
+			server.IMAPPort = value
+		} else {
+			server.SMTPPort = value
+		}
+	case "imap_tls", "smtp_tls":
+		if value != email.TLSModeTLS && value != email.TLSModeStartTLS && value != email.TLSModeNone {
+			fmt.Println("Invalid TLS mode. Valid options: tls, starttls, none")
+			return false
+		}
+		if setting == "imap_tls" {
+			server.IMAPTLS = value
+		} else {
+			server.SMTPTLS = value
+		}
+	case "auth_mechanism":
+		switch value {
+		case email.AuthMechanismPlain, email.AuthMechanismLogin, email.AuthMechanismXOAuth2, email.AuthMechanismOAuthBearer:
+		default:
+			fmt.Println("Invalid auth mechanism. Valid options: plain, login, xoauth2, oauthbearer")
+			return false
+		}
+		server.AuthMechanism = value
+	}
+	return true
+}
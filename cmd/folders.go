@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jacobbanks/tmail/email"
+	"github.com/spf13/cobra"
+)
+
+var foldersCmd = &cobra.Command{
+	Use:   "folders",
+	Short: "List mailboxes",
+	Long:  "List the mailboxes of the account with their message and unread counts",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		provider, err := email.CreateDefaultMailProvider()
+		if err != nil {
+			fmt.Println("Error setting up mail provider:", err)
+			fmt.Println("Please run: tmail auth")
+			os.Exit(1)
+		}
+		defer provider.Disconnect()
+
+		mailboxes, err := provider.ListMailboxes()
+		if err != nil {
+			fmt.Printf("Error listing mailboxes: %v\n", err)
+			os.Exit(1)
+		}
+
+		for _, mailbox := range mailboxes {
+			if !mailbox.Selectable() {
+				continue
+			}
+			counts := ""
+			if status, err := provider.MailboxStatus(mailbox.Name); err == nil {
+				counts = fmt.Sprintf("%d messages, %d unread", status.Messages, status.Unseen)
+			}
+			fmt.Printf("%-30s %-10s %s\n", mailbox.Name, mailbox.SpecialUse, counts)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(foldersCmd)
+}
@@ -9,10 +9,16 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// Mailbox to open instead of the inbox
+var readFolder string
+
 var readCmd = &cobra.Command{
 	Use:   "read",
 	Short: "Read emails",
-	Long:  "Fetch and read emails in a terminal UI",
+	Long: `Fetch and read emails in a terminal UI.
+Examples:
+  tmail read
+  tmail read --folder "[Gmail]/Sent Mail"`,
 	Run: func(cmd *cobra.Command, args []string) {
 		// Create provider without connecting to start UI faster
 		provider, err := email.CreateDefaultMailProvider()
@@ -24,10 +30,16 @@ var readCmd = &cobra.Command{
 
 		// Start UI immediately with empty emails list
 		// Emails will be loaded in the background
-		reader := ui.NewEmailReader(nil, provider)
-		if err := reader.Run(); err != nil {
+		reader := ui.NewMailboxReader(readFolder, provider)
+		err = reader.Run()
+		provider.Disconnect()
+		if err != nil {
 			fmt.Printf("Error: %v\n", err)
 			os.Exit(1)
 		}
 	},
 }
+
+func init() {
+	readCmd.Flags().StringVar(&readFolder, "folder", email.InboxName, "Mailbox to read, see: tmail folders")
+}
@@ -4,12 +4,21 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/jacobbanks/tmail/auth"
 	"github.com/spf13/cobra"
 )
 
+// Account selected with --account, empty means the default account
+var accountName string
+
 var rootCmd = &cobra.Command{
 	Use:   "tmail",
 	Short: "A simple CLI for email",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if accountName != "" {
+			auth.SetActiveAccount(accountName)
+		}
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		fmt.Println("Welcome to tmail. Use 'tmail help' for commands.")
 	},
@@ -26,6 +35,7 @@ func Execute() {
 }
 
 func init() {
+	rootCmd.PersistentFlags().StringVar(&accountName, "account", "", "Account to use instead of the default account")
 	rootCmd.AddCommand(readCmd)
 	rootCmd.AddCommand(authCmd)
 	rootCmd.AddCommand(sendCmd)
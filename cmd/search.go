@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jacobbanks/tmail/email"
+	"github.com/spf13/cobra"
+)
+
+var (
+	searchFolder  string // Mailbox to search
+	searchLimit   int    // Most results to show
+	searchOffline bool   // Search the local index instead of the server
+)
+
+var searchCmd = &cobra.Command{
+	Use:   "search <query>",
+	Short: "Search emails on the server",
+	Long: `Search a mailbox on the server and list the newest matching emails.
+
+Queries combine these terms:
+  from:alice  to:bob  subject:"lunch plans"
+  since:2024-01-31  before:2024-03-01
+  has:attachment  is:unread  is:read  is:starred  msgid:<id@example.com>
+  body:invoice  "exact phrase"  budg*  free text
+
+Gmail accounts hand the query to Gmail's own search, so its other operators work too.
+
+--offline searches the emails cached by tmail read instead, in every folder
+unless --folder is given. Bodies are only found for emails that were opened.
+Examples:
+  tmail search from:alice is:unread
+  tmail search --folder "[Gmail]/All Mail" subject:invoice since:2024-01-01
+  tmail search --offline "quarterly budget"`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		query, err := email.ParseSearchQuery(joinSearchArgs(args))
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		provider, err := email.CreateDefaultMailProvider()
+		if err != nil {
+			fmt.Println("Error setting up mail provider:", err)
+			fmt.Println("Please run: tmail auth")
+			os.Exit(1)
+		}
+		defer provider.Disconnect()
+
+		var emails []*email.IncomingMessage
+		if searchOffline {
+			folder := searchFolder
+			if !cmd.Flags().Changed("folder") {
+				folder = "" // Every folder
+			}
+			emails, err = provider.SearchOffline(folder, query, searchLimit)
+		} else {
+			emails, err = provider.Search(searchFolder, query, searchLimit)
+		}
+		if err != nil {
+			fmt.Printf("Error searching %s: %v\n", searchFolder, err)
+			os.Exit(1)
+		}
+		if len(emails) == 0 {
+			fmt.Println("No emails found.")
+			return
+		}
+
+		for _, msg := range emails {
+			if searchOffline {
+				fmt.Printf("%-20.20s ", msg.Mailbox)
+			}
+			fmt.Printf("%-8d %s  %-30.30s %s\n", msg.UID, msg.Date.Format("2006-01-02 15:04"), msg.From, msg.Subject)
+		}
+	},
+}
+
+// joinSearchArgs joins the arguments into one query, quoting the ones the
+// shell unquoted so subject:"lunch plans" keeps its space
+func joinSearchArgs(args []string) string {
+	terms := make([]string, len(args))
+	for i, arg := range args {
+		terms[i] = email.QuoteSearchTerm(arg)
+	}
+	return strings.Join(terms, " ")
+}
+
+func init() {
+	searchCmd.Flags().StringVar(&searchFolder, "folder", email.InboxName, "Mailbox to search, see: tmail folders")
+	searchCmd.Flags().IntVarP(&searchLimit, "limit", "n", 20, "Most emails to list")
+	searchCmd.Flags().BoolVar(&searchOffline, "offline", false, "Search the emails cached on this computer instead of the server")
+	rootCmd.AddCommand(searchCmd)
+}
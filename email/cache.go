@@ -0,0 +1,331 @@
+package email
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// GetCacheDir returns the directory holding cached messages, following
+// XDG_CACHE_HOME where the platform uses it
+func GetCacheDir() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get cache directory: %v", err)
+	}
+	return filepath.Join(cacheDir, "tmail"), nil
+}
+
+// MessageCache keeps the envelopes, flags and bodies of one account's
+// messages on disk, so the reader can open without waiting for the server.
+// Messages are stored under account/mailbox/UIDVALIDITY/UID: when a
+// mailbox's UIDVALIDITY changes its UIDs mean nothing anymore and the whole
+// mailbox is dropped.
+//
+// A cache without a directory keeps the indexes in memory instead, so
+// accounts that cannot be cached on disk still sync incrementally.
+//
+// The cached messages are also kept in a SearchIndex so they can be searched
+// offline.
+type MessageCache struct {
+	mu      sync.Mutex
+	dir     string
+	indexes map[string]*cachedMailbox // Used instead of dir when it is empty
+	search  *SearchIndex              // Loaded on first use
+}
+
+// cachedMailbox is the index of a cached mailbox. It holds a contiguous run
+// of the newest messages, newest first, without their bodies.
+type cachedMailbox struct {
+	UIDValidity uint32             `json:"uid_validity"`
+	ModSeq      uint64             `json:"modseq,omitempty"` // Highest MODSEQ seen, for CONDSTORE servers
+	Messages    []*IncomingMessage `json:"messages"`
+}
+
+// NewMessageCache opens the cache of an account
+func NewMessageCache(account string) (*MessageCache, error) {
+	if account == "" {
+		return nil, fmt.Errorf("cache needs an account name")
+	}
+	cacheDir, err := GetCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	return &MessageCache{dir: filepath.Join(cacheDir, url.PathEscape(account))}, nil
+}
+
+// newMemoryCache returns a cache that forgets everything when the process exits
+func newMemoryCache() *MessageCache {
+	return &MessageCache{indexes: map[string]*cachedMailbox{}}
+}
+
+// mailboxDir returns the directory of a mailbox. Mailbox names are escaped
+// since they may contain the hierarchy delimiter, e.g. "[Gmail]/Sent Mail".
+func (c *MessageCache) mailboxDir(mailbox string) string {
+	return filepath.Join(c.dir, url.PathEscape(mailbox))
+}
+
+// validityDir returns the directory of a mailbox's messages for one UIDVALIDITY
+func (c *MessageCache) validityDir(mailbox string, uidValidity uint32) string {
+	return filepath.Join(c.mailboxDir(mailbox), strconv.FormatUint(uint64(uidValidity), 10))
+}
+
+// load reads the index of a mailbox. A mailbox that was never cached comes
+// back empty.
+func (c *MessageCache) load(mailbox string) (*cachedMailbox, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.dir == "" {
+		cached, ok := c.indexes[mailbox]
+		if !ok {
+			return &cachedMailbox{}, nil
+		}
+		return cached.clone(), nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(c.mailboxDir(mailbox), "index.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &cachedMailbox{}, nil
+		}
+		return nil, fmt.Errorf("failed to read cache of %s: %v", mailbox, err)
+	}
+
+	var cached cachedMailbox
+	if err := json.Unmarshal(data, &cached); err != nil {
+		// A corrupt index is only a missing cache
+		return &cachedMailbox{}, nil
+	}
+	return &cached, nil
+}
+
+// searchIndex returns the search index, loading it on first use. The
+// caller must hold the lock.
+func (c *MessageCache) searchIndex() *SearchIndex {
+	if c.search == nil {
+		if c.dir == "" {
+			c.search = newSearchIndex("")
+		} else {
+			c.search = loadSearchIndex(filepath.Join(c.dir, "search.gob"))
+		}
+	}
+	return c.search
+}
+
+// save writes the index of a mailbox, dropping the messages cached for any
+// other UIDVALIDITY, and adds its messages to the search index
+func (c *MessageCache) save(mailbox string, cached *cachedMailbox) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.dir == "" {
+		c.indexes[mailbox] = cached.clone()
+		c.indexMailbox(mailbox, cached)
+		return nil
+	}
+
+	dir := c.mailboxDir(mailbox)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create cache directory: %v", err)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	current := strconv.FormatUint(uint64(cached.UIDValidity), 10)
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != current {
+			os.RemoveAll(filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	data, err := json.Marshal(cached.clone())
+	if err != nil {
+		return fmt.Errorf("failed to encode cache of %s: %v", mailbox, err)
+	}
+	if err := writeFileAtomic(filepath.Join(dir, "index.json"), data); err != nil {
+		return err
+	}
+	c.indexMailbox(mailbox, cached)
+	return nil
+}
+
+// indexMailbox brings the search index up to date with the cached messages
+// of a mailbox. The caller must hold the lock. A search index that fails to
+// save only misses messages until the next sync.
+func (c *MessageCache) indexMailbox(mailbox string, cached *cachedMailbox) {
+	search := c.searchIndex()
+	search.keepValidity(mailbox, cached.UIDValidity)
+	for _, email := range cached.Messages {
+		search.add(email)
+	}
+	search.save()
+}
+
+// loadBody returns the raw message cached for an email
+func (c *MessageCache) loadBody(email *IncomingMessage) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.dir == "" {
+		return nil, os.ErrNotExist
+	}
+	path := filepath.Join(c.validityDir(email.Mailbox, email.UIDValidity), strconv.FormatUint(uint64(email.UID), 10)+".eml")
+	return os.ReadFile(path)
+}
+
+// saveBody caches the raw message of an email
+func (c *MessageCache) saveBody(email *IncomingMessage, raw []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.dir == "" {
+		return nil // Bodies are only kept on disk
+	}
+	dir := c.validityDir(email.Mailbox, email.UIDValidity)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create cache directory: %v", err)
+	}
+	return writeFileAtomic(filepath.Join(dir, strconv.FormatUint(uint64(email.UID), 10)+".eml"), raw)
+}
+
+// removeMessages forgets the bodies and search entries of messages that
+// left the mailbox
+func (c *MessageCache) removeMessages(mailbox string, uidValidity uint32, uids []uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	search := c.searchIndex()
+	search.remove(mailbox, uidValidity, uids)
+	search.save()
+
+	if c.dir == "" {
+		return
+	}
+	dir := c.validityDir(mailbox, uidValidity)
+	for _, uid := range uids {
+		os.Remove(filepath.Join(dir, strconv.FormatUint(uint64(uid), 10)+".eml"))
+	}
+}
+
+// indexBody adds the body of a downloaded message to the search index. The
+// index is written with the next sync or flushIndex rather than for every
+// message opened.
+func (c *MessageCache) indexBody(email *IncomingMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.searchIndex().add(email)
+}
+
+// flushIndex writes the search index if it has changes not yet saved
+func (c *MessageCache) flushIndex() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.search == nil {
+		return nil
+	}
+	return c.search.save()
+}
+
+// searchMessages searches the cached messages of a mailbox ("" for all of
+// them) and returns up to n of the newest matches
+func (c *MessageCache) searchMessages(mailbox string, query *SearchQuery, n int) []*IncomingMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.searchIndex().search(mailbox, query, n)
+}
+
+// writeFileAtomic replaces path with data so readers never see half a file
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
+
+// clone copies the index and its messages without their bodies, so callers
+// may change what they get without touching the cache
+func (m *cachedMailbox) clone() *cachedMailbox {
+	clone := &cachedMailbox{UIDValidity: m.UIDValidity, ModSeq: m.ModSeq}
+	clone.Messages = make([]*IncomingMessage, len(m.Messages))
+	for i, email := range m.Messages {
+		clone.Messages[i] = email.summary()
+	}
+	return clone
+}
+
+// highestUID returns the UID of the newest cached message, or 0
+func (m *cachedMailbox) highestUID() uint32 {
+	if len(m.Messages) == 0 {
+		return 0
+	}
+	return m.Messages[0].UID
+}
+
+// lowestUID returns the UID of the oldest cached message, or 0
+func (m *cachedMailbox) lowestUID() uint32 {
+	if len(m.Messages) == 0 {
+		return 0
+	}
+	return m.Messages[len(m.Messages)-1].UID
+}
+
+// contains reports whether the message with uid is cached
+func (m *cachedMailbox) contains(uid uint32) bool {
+	i := sort.Search(len(m.Messages), func(i int) bool { return m.Messages[i].UID <= uid })
+	return i < len(m.Messages) && m.Messages[i].UID == uid
+}
+
+// merge adds emails to the index, replacing the cached copies of ones it
+// already has
+func (m *cachedMailbox) merge(emails []*IncomingMessage) {
+	byUID := make(map[uint32]*IncomingMessage, len(m.Messages)+len(emails))
+	for _, email := range m.Messages {
+		byUID[email.UID] = email
+	}
+	for _, email := range emails {
+		byUID[email.UID] = email
+	}
+
+	m.Messages = m.Messages[:0]
+	for _, email := range byUID {
+		m.Messages = append(m.Messages, email)
+	}
+	sort.Slice(m.Messages, func(i, j int) bool { return m.Messages[i].UID > m.Messages[j].UID })
+}
+
+// newest returns up to n of the newest messages with a UID below beforeUID
+// (0 for no bound)
+func (m *cachedMailbox) newest(beforeUID uint32, n int) []*IncomingMessage {
+	start := 0
+	if beforeUID != 0 {
+		start = sort.Search(len(m.Messages), func(i int) bool { return m.Messages[i].UID < beforeUID })
+	}
+	end := start + n
+	if n <= 0 || end > len(m.Messages) {
+		end = len(m.Messages)
+	}
+	page := make([]*IncomingMessage, end-start)
+	copy(page, m.Messages[start:end])
+	return page
+}
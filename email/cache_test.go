@@ -0,0 +1,151 @@
+package email
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// useTempCache points the message cache at a temporary directory
+func useTempCache(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", dir)
+	t.Setenv("HOME", dir)
+}
+
+func cachedUIDs(emails []*IncomingMessage) []uint32 {
+	uids := make([]uint32, len(emails))
+	for i, email := range emails {
+		uids[i] = email.UID
+	}
+	return uids
+}
+
+func TestCachedMailboxMergeAndPage(t *testing.T) {
+	cached := &cachedMailbox{}
+	cached.merge([]*IncomingMessage{{UID: 5}, {UID: 7}, {UID: 6}})
+	cached.merge([]*IncomingMessage{{UID: 9, Subject: "new"}, {UID: 7, Subject: "updated"}})
+
+	if got := cachedUIDs(cached.Messages); len(got) != 4 || got[0] != 9 || got[3] != 5 {
+		t.Fatalf("Expected UIDs 9, 7, 6, 5, got %v", got)
+	}
+	if cached.Messages[1].Subject != "updated" {
+		t.Errorf("Expected merged copy to replace the cached one")
+	}
+	if cached.highestUID() != 9 || cached.lowestUID() != 5 {
+		t.Errorf("Unexpected bounds %d..%d", cached.lowestUID(), cached.highestUID())
+	}
+	if !cached.contains(6) || cached.contains(8) {
+		t.Errorf("contains gave the wrong answer")
+	}
+
+	if got := cachedUIDs(cached.newest(0, 2)); len(got) != 2 || got[0] != 9 || got[1] != 7 {
+		t.Errorf("Expected the first page to be 9, 7, got %v", got)
+	}
+	if got := cachedUIDs(cached.newest(7, 2)); len(got) != 2 || got[0] != 6 || got[1] != 5 {
+		t.Errorf("Expected the page below 7 to be 6, 5, got %v", got)
+	}
+	if got := cachedUIDs(cached.newest(5, 2)); len(got) != 0 {
+		t.Errorf("Expected nothing below the oldest message, got %v", got)
+	}
+}
+
+func TestMessageCacheRoundTrip(t *testing.T) {
+	useTempCache(t)
+
+	cache, err := NewMessageCache("work")
+	if err != nil {
+		t.Fatalf("NewMessageCache returned error: %v", err)
+	}
+
+	mailbox := "[Gmail]/Sent Mail"
+	email := &IncomingMessage{Mailbox: mailbox, UIDValidity: 3, UID: 42, Subject: "Hi", Body: "secret", BodyLoaded: true}
+	if err := cache.save(mailbox, &cachedMailbox{UIDValidity: 3, Messages: []*IncomingMessage{email}}); err != nil {
+		t.Fatalf("save returned error: %v", err)
+	}
+	if err := cache.saveBody(email, []byte("Subject: Hi\r\n\r\nsecret\r\n")); err != nil {
+		t.Fatalf("saveBody returned error: %v", err)
+	}
+
+	loaded, err := cache.load(mailbox)
+	if err != nil {
+		t.Fatalf("load returned error: %v", err)
+	}
+	if loaded.UIDValidity != 3 || len(loaded.Messages) != 1 || loaded.Messages[0].Subject != "Hi" {
+		t.Fatalf("Unexpected cached mailbox %+v", loaded)
+	}
+	if loaded.Messages[0].Body != "" || loaded.Messages[0].BodyLoaded {
+		t.Errorf("Expected the index to leave bodies out")
+	}
+	if raw, err := cache.loadBody(email); err != nil || len(raw) == 0 {
+		t.Errorf("Expected the cached body, got %q, %v", raw, err)
+	}
+
+	// A new UIDVALIDITY invalidates the cached bodies
+	if err := cache.save(mailbox, &cachedMailbox{UIDValidity: 4}); err != nil {
+		t.Fatalf("save returned error: %v", err)
+	}
+	if _, err := cache.loadBody(email); err == nil {
+		t.Errorf("Expected bodies of the old UIDVALIDITY to be removed")
+	}
+
+	// Mailbox names are escaped rather than creating directories
+	if _, err := os.Stat(filepath.Join(cache.dir, "[Gmail]")); err == nil {
+		t.Errorf("Mailbox name was not escaped")
+	}
+}
+
+func TestMessageCacheMissingMailbox(t *testing.T) {
+	useTempCache(t)
+
+	cache, err := NewMessageCache("work")
+	if err != nil {
+		t.Fatalf("NewMessageCache returned error: %v", err)
+	}
+	cached, err := cache.load(InboxName)
+	if err != nil {
+		t.Fatalf("load returned error: %v", err)
+	}
+	if len(cached.Messages) != 0 {
+		t.Errorf("Expected an empty mailbox, got %d messages", len(cached.Messages))
+	}
+
+	if _, err := NewMessageCache(""); err == nil {
+		t.Errorf("Expected an error without an account name")
+	}
+}
+
+func TestMessageCacheIndexBodyDeferred(t *testing.T) {
+	useTempCache(t)
+
+	cache, err := NewMessageCache("work")
+	if err != nil {
+		t.Fatalf("NewMessageCache returned error: %v", err)
+	}
+	if err := cache.save(InboxName, &cachedMailbox{UIDValidity: 1, Messages: []*IncomingMessage{{Mailbox: InboxName, UIDValidity: 1, UID: 1, Subject: "Hi"}}}); err != nil {
+		t.Fatalf("save returned error: %v", err)
+	}
+	path := filepath.Join(cache.dir, "search.gob")
+	synced, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Expected the sync to write the search index: %v", err)
+	}
+
+	// Opening a message only changes the index in memory
+	cache.indexBody(&IncomingMessage{Mailbox: InboxName, UIDValidity: 1, UID: 1, Subject: "Hi", Body: "quarterly numbers", BodyLoaded: true})
+	if info, _ := os.Stat(path); !info.ModTime().Equal(synced.ModTime()) || info.Size() != synced.Size() {
+		t.Error("Expected indexing a body not to rewrite the search index")
+	}
+	query, _ := ParseSearchQuery("quarterly")
+	if got := cache.searchMessages(InboxName, query, 10); len(got) != 1 {
+		t.Errorf("Expected the body to be searchable right away, got %d matches", len(got))
+	}
+
+	if err := cache.flushIndex(); err != nil {
+		t.Fatalf("flushIndex returned error: %v", err)
+	}
+	if got := loadSearchIndex(path).search(InboxName, query, 10); len(got) != 1 {
+		t.Errorf("Expected the flushed index to hold the body, got %d matches", len(got))
+	}
+}
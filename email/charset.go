@@ -0,0 +1,276 @@
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-message"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/transform"
+)
+
+func init() {
+	// Bodies, encoded-word headers and envelopes in any charset are
+	// decoded to UTF-8 as they are parsed
+	message.CharsetReader = charsetReader
+	imap.CharsetReader = charsetReader
+}
+
+// charsetSniffSize is how much of a text the charset is guessed from
+const charsetSniffSize = 64 * 1024
+
+// charsetReader converts input in the named charset to UTF-8 as it is read.
+// It never fails: text in a charset it does not know is decoded as best it
+// can, going by its beginning.
+func charsetReader(label string, input io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReaderSize(input, charsetSniffSize)
+	sample, err := buffered.Peek(charsetSniffSize)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	enc := detectCharset(label, trimPartialRune(sample, err == io.EOF))
+	if enc == nil {
+		return buffered, nil
+	}
+	return transform.NewReader(buffered, enc.NewDecoder()), nil
+}
+
+// trimPartialRune drops the start of a character cut off at the end of a
+// sample, unless the sample is the whole text
+func trimPartialRune(sample []byte, complete bool) []byte {
+	if complete {
+		return sample
+	}
+	for i := 1; i <= utf8.UTFMax && i <= len(sample); i++ {
+		if utf8.RuneStart(sample[len(sample)-i]) {
+			if !utf8.FullRune(sample[len(sample)-i:]) {
+				return sample[:len(sample)-i]
+			}
+			break
+		}
+	}
+	return sample
+}
+
+// statefulCharsets are charsets whose text can pass for ASCII or UTF-8, so
+// the label has to be trusted
+var statefulCharsets = map[string]bool{
+	"iso-2022-jp": true,
+	"utf-16be":    true,
+	"utf-16le":    true,
+}
+
+// decodeCharset converts text in the named charset to UTF-8
+func decodeCharset(label string, data []byte) string {
+	enc := detectCharset(label, data)
+	if enc == nil {
+		return string(data)
+	}
+	text, _ := enc.NewDecoder().Bytes(data)
+	return string(text)
+}
+
+// detectCharset returns the charset text labeled with the named charset is
+// really in, or nil for UTF-8. The label is second-guessed when it does not
+// fit the text: UTF-8 labeled as Latin-1 stays UTF-8, and text that is not
+// valid in its charset, or has none, is taken for the legacy charset it
+// most looks like.
+func detectCharset(label string, data []byte) encoding.Encoding {
+	enc, name := lookupCharset(label)
+	if enc != nil && statefulCharsets[name] {
+		if _, err := enc.NewDecoder().Bytes(data); err == nil {
+			return enc
+		}
+	}
+
+	// ASCII reads the same in the label's charset, which may still be
+	// needed further on than the text was looked at
+	if isASCII(data) {
+		if name == "utf-8" || statefulCharsets[name] {
+			return nil
+		}
+		return enc
+	}
+	// Real Latin-1 or Shift_JIS text is hardly ever valid UTF-8
+	if utf8.Valid(data) {
+		return nil
+	}
+	if enc == nil || name == "utf-8" {
+		return guessCharset(data)
+	}
+	// Latin-1 is what many mailers claim whatever the text, and Western
+	// European text has its accents one at a time, not in runs
+	if name == "windows-1252" && !latinLike(data) {
+		return guessCharset(data)
+	}
+
+	text, err := enc.NewDecoder().Bytes(data)
+	if err != nil || bytes.Count(text, []byte("\uFFFD"))*100 > utf8.RuneCount(text) {
+		return guessCharset(data)
+	}
+	return enc
+}
+
+// isASCII reports whether data is plain ASCII
+func isASCII(data []byte) bool {
+	for _, b := range data {
+		if b >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// lookupCharset finds a charset by any of its names, e.g. "latin1" or
+// "x-sjis", returning it with its canonical name, or nil if unknown
+func lookupCharset(label string) (encoding.Encoding, string) {
+	label = strings.ToLower(strings.Trim(strings.TrimSpace(label), `"'`))
+	if label == "" {
+		return nil, ""
+	}
+	// WHATWG labels know what mail clients really mean, e.g. windows-1252
+	// for iso-8859-1 and GBK for gb2312
+	if enc, err := htmlindex.Get(label); err == nil {
+		name, _ := htmlindex.Name(enc)
+		return enc, name
+	}
+	if enc, err := ianaindex.MIME.Encoding(label); err == nil && enc != nil {
+		name, _ := ianaindex.MIME.Name(enc)
+		return enc, strings.ToLower(name)
+	}
+	return nil, ""
+}
+
+// charsetGuess is a legacy charset text may be in, with the scripts its
+// letters belong to
+type charsetGuess struct {
+	name    string
+	scripts []*unicode.RangeTable
+	kana    bool // Japanese text without kana is most likely Chinese
+}
+
+// charsetGuesses are tried in order; on a tie the earlier one wins
+var charsetGuesses = []charsetGuess{
+	{name: "shift_jis", scripts: []*unicode.RangeTable{unicode.Han, unicode.Hiragana, unicode.Katakana}, kana: true},
+	{name: "euc-jp", scripts: []*unicode.RangeTable{unicode.Han, unicode.Hiragana, unicode.Katakana}, kana: true},
+	{name: "euc-kr", scripts: []*unicode.RangeTable{unicode.Hangul}},
+	{name: "gbk", scripts: []*unicode.RangeTable{unicode.Han}},
+	{name: "big5", scripts: []*unicode.RangeTable{unicode.Han}},
+	{name: "koi8-r", scripts: []*unicode.RangeTable{unicode.Cyrillic}},
+	{name: "windows-1251", scripts: []*unicode.RangeTable{unicode.Cyrillic}},
+}
+
+// guessCharset guesses the charset of text of unknown charset. Accented
+// letters dotted among ASCII are taken for Western European; longer runs of
+// non-ASCII bytes go to the charset whose decoding reads most like one
+// script.
+func guessCharset(data []byte) encoding.Encoding {
+	fallback, _ := htmlindex.Get("windows-1252")
+	if latinLike(data) {
+		return fallback
+	}
+
+	best, bestScore := fallback, 0.0
+	for _, guess := range charsetGuesses {
+		enc, err := htmlindex.Get(guess.name)
+		if err != nil {
+			continue
+		}
+		text, err := enc.NewDecoder().Bytes(data)
+		if err != nil {
+			continue
+		}
+		if score := guess.score(string(text)); score > bestScore {
+			best, bestScore = enc, score
+		}
+	}
+	return best
+}
+
+// latinLike reports whether the non-ASCII bytes of text mostly come one
+// at a time, as accented letters do, rather than in runs, as the letters
+// of Cyrillic or multibyte CJK charsets do
+func latinLike(data []byte) bool {
+	high, runs := 0, 0
+	for i, b := range data {
+		if b >= 0x80 {
+			high++
+			if i == 0 || data[i-1] < 0x80 {
+				runs++
+			}
+		}
+	}
+	return high < 2*runs
+}
+
+// score rates how much decoded text looks like the guessed charset's
+// languages, from 0 to a little over 1
+func (guess charsetGuess) score(text string) float64 {
+	total, inScript, kana, lower := 0, 0, 0, 0
+	for _, r := range text {
+		// Punctuation such as 。 is shared by the languages of a script
+		if r < utf8.RuneSelf || unicode.IsPunct(r) {
+			continue
+		}
+		total++
+		// Halfwidth katakana is what other charsets look like as Shift_JIS
+		if r == utf8.RuneError || (r >= 0xFF61 && r <= 0xFF9F) {
+			continue
+		}
+		if unicode.In(r, guess.scripts...) {
+			inScript++
+			if unicode.In(r, unicode.Hiragana, unicode.Katakana) {
+				kana++
+			}
+			if unicode.IsLower(r) {
+				lower++
+			}
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	score := float64(inScript) / float64(total)
+	if guess.kana && kana*20 < total {
+		score /= 2
+	}
+	// Running text is mostly lowercase, which tells KOI8-R from
+	// windows-1251 as they swap the cases
+	return score + 0.1*float64(lower)/float64(total)
+}
+
+// decodeHeaderText fixes header text sent as raw 8-bit bytes rather than
+// encoded words, which is not valid UTF-8 when it is in a legacy charset
+func decodeHeaderText(text string) string {
+	if utf8.ValidString(text) {
+		return text
+	}
+	return decodeCharset("", []byte(text))
+}
+
+// metaCharset matches the charset an HTML document declares in a <meta>
+// tag, in either its HTML5 or its http-equiv form
+var metaCharset = regexp.MustCompile(`(?i)<meta[^>]+charset\s*=\s*["']?([\w.:-]+)`)
+
+// htmlMetaCharset returns the charset an HTML part declares for itself,
+// or "" for other parts and HTML that does not say
+func htmlMetaCharset(contentType, text string) string {
+	if contentType != "text/html" {
+		return ""
+	}
+	if len(text) > 1024 {
+		text = text[:1024] // The declaration has to come first (HTML5 section 4.2.5.4)
+	}
+	if match := metaCharset.FindStringSubmatch(text); match != nil {
+		return match[1]
+	}
+	return ""
+}
@@ -0,0 +1,144 @@
+package email
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// encodeIn converts UTF-8 text to the named charset
+func encodeIn(t *testing.T, charset, text string) string {
+	t.Helper()
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		t.Fatalf("Unknown charset %s: %v", charset, err)
+	}
+	encoded, err := enc.NewEncoder().String(text)
+	if err != nil {
+		t.Fatalf("Failed to encode %q in %s: %v", text, charset, err)
+	}
+	return encoded
+}
+
+func TestDecodeCharset(t *testing.T) {
+	tests := []struct {
+		name    string
+		label   string
+		charset string // The charset the text is really in
+		text    string
+	}{
+		{"Latin-1", "iso-8859-1", "windows-1252", "Crème brûlée, s'il vous plaît"},
+		{"Windows-1252", "windows-1252", "windows-1252", "“Smart quotes” – and a dash"},
+		{"Shift_JIS", "Shift_JIS", "shift_jis", "会議の議事録を送ります"},
+		{"GB2312", "gb2312", "gbk", "请查收附件中的报告"},
+		{"KOI8-R", "koi8-r", "koi8-r", "Привет, как дела?"},
+		{"UTF-8 labeled as Latin-1", "iso-8859-1", "utf-8", "Crème brûlée"},
+		{"Latin-1 labeled as UTF-8", "utf-8", "windows-1252", "Crème brûlée"},
+		{"Shift_JIS labeled as Latin-1", "iso-8859-1", "shift_jis", "会議の議事録を送ります"},
+		{"Unknown label", "x-unheard-of", "windows-1252", "Café"},
+		{"Unlabeled Shift_JIS", "", "shift_jis", "お世話になっております。"},
+		{"Unlabeled EUC-KR", "", "euc-kr", "회의록을 보내드립니다."},
+		{"Unlabeled GBK", "", "gbk", "请查收附件中的报告"},
+		{"Unlabeled KOI8-R", "", "koi8-r", "Привет, как дела?"},
+		{"Unlabeled windows-1251", "", "windows-1251", "Привет, как дела?"},
+		{"Unlabeled Latin-1", "", "windows-1252", "Grüße aus München"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.text
+			if tt.charset != "utf-8" {
+				data = encodeIn(t, tt.charset, tt.text)
+			}
+			if got := decodeCharset(tt.label, []byte(data)); got != tt.text {
+				t.Errorf("decodeCharset(%q) = %q, want %q", tt.label, got, tt.text)
+			}
+		})
+	}
+}
+
+func TestParseLegacyCharsets(t *testing.T) {
+	raw := "From: =?koi8-r?B?" + "8NLJ18XU?= <ivan@example.com>\r\n" +
+		"To: me@example.com\r\n" +
+		"Subject: =?Shift_JIS?B?ie+LYw==?=\r\n" +
+		"Content-Type: multipart/alternative; boundary=b\r\n" +
+		"\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/plain; charset=koi8-r\r\n" +
+		"Content-Transfer-Encoding: 8bit\r\n" +
+		"\r\n" +
+		encodeIn(t, "koi8-r", "Привет, как дела?") + "\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/html\r\n" +
+		"\r\n" +
+		`<html><head><meta charset="shift_jis"></head><body>` + encodeIn(t, "shift_jis", "会議") + "</body></html>\r\n" +
+		"--b--\r\n"
+
+	email := &IncomingMessage{}
+	if err := email.parseRaw([]byte(raw)); err != nil {
+		t.Fatalf("Failed to parse message: %v", err)
+	}
+
+	if email.From != "Привет <ivan@example.com>" {
+		t.Errorf("From = %q, want the KOI8-R name decoded", email.From)
+	}
+	if email.Subject != "会議" {
+		t.Errorf("Subject = %q, want %q", email.Subject, "会議")
+	}
+	if email.Body != "Привет, как дела?" {
+		t.Errorf("Body = %q, want the KOI8-R text decoded", email.Body)
+	}
+	if !strings.Contains(email.HTMLBody, "会議") {
+		t.Errorf("HTMLBody = %q, want it decoded with its <meta> charset", email.HTMLBody)
+	}
+}
+
+func TestParseRawHeaderBytes(t *testing.T) {
+	raw := "From: " + encodeIn(t, "windows-1252", "José García") + " <jose@example.com>\r\n" +
+		"To: me@example.com\r\n" +
+		"Subject: " + encodeIn(t, "windows-1252", "Reunión mañana") + "\r\n" +
+		"\r\n" +
+		encodeIn(t, "windows-1252", "¿Nos vemos a las diez?") + "\r\n"
+
+	email := &IncomingMessage{}
+	if err := email.parseRaw([]byte(raw)); err != nil {
+		t.Fatalf("Failed to parse message: %v", err)
+	}
+
+	if email.From != "José García <jose@example.com>" {
+		t.Errorf("From = %q", email.From)
+	}
+	if email.Subject != "Reunión mañana" {
+		t.Errorf("Subject = %q", email.Subject)
+	}
+	if strings.TrimSpace(email.Body) != "¿Nos vemos a las diez?" {
+		t.Errorf("Body = %q", email.Body)
+	}
+}
+
+func TestCharsetReaderStreams(t *testing.T) {
+	// Only what is read of a long part is decoded
+	line := encodeIn(t, "koi8-r", "Привет, как дела?") + "\n"
+	input := &countingReader{r: strings.NewReader(strings.Repeat(line, 500000))}
+	reader, err := charsetReader("koi8-r", input)
+	if err != nil {
+		t.Fatalf("charsetReader returned error: %v", err)
+	}
+	text := readContent(reader)
+	if !strings.HasPrefix(text, "Привет, как дела?") {
+		t.Errorf("Decoded text starts with %q", text[:40])
+	}
+	if input.n > 2*1024*1024 {
+		t.Errorf("Read %d bytes to show %d", input.n, len(text))
+	}
+
+	// Accents past the part looked at still follow the label
+	long := strings.Repeat("Plain ASCII text. ", charsetSniffSize/10) + encodeIn(t, "windows-1252", "Crème brûlée")
+	reader, _ = charsetReader("iso-8859-1", strings.NewReader(long))
+	decoded, _ := io.ReadAll(reader)
+	if !strings.HasSuffix(string(decoded), "Crème brûlée") {
+		t.Errorf("Decoded text ends with %q", decoded[len(decoded)-20:])
+	}
+}
@@ -5,20 +5,56 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+)
+
+// Supported values for the provider field of UserConfig
+const (
+	ProviderGmail = "gmail" // Gmail with the built-in server settings
+	ProviderIMAP  = "imap"  // Any IMAP/SMTP server described by UserConfig.Server
+)
+
+// Supported connection security modes for IMAPTLS and SMTPTLS
+const (
+	TLSModeTLS      = "tls"      // Implicit TLS from the first byte (IMAP 993, SMTP 465)
+	TLSModeStartTLS = "starttls" // Plain connection upgraded with STARTTLS
+	TLSModeNone     = "none"     // No encryption, only sensible for local servers
+)
+
+// Supported values for AuthMechanism
+const (
+	AuthMechanismPlain       = "plain"       // AUTHENTICATE PLAIN on IMAP, AUTH PLAIN on SMTP
+	AuthMechanismLogin       = "login"       // LOGIN command on IMAP, AUTH LOGIN on SMTP
+	AuthMechanismXOAuth2     = "xoauth2"     // Google/Microsoft XOAUTH2 with an OAuth2 access token
+	AuthMechanismOAuthBearer = "oauthbearer" // RFC 7628 OAUTHBEARER with an OAuth2 access token
 )
 
 // Config holds email provider settings
 type Config struct {
-	SMTPHost string `json:"smtp_host"`
-	SMTPPort string `json:"smtp_port"`
-	IMAPHost string `json:"imap_host"`
-	IMAPPort string `json:"imap_port"`
+	SMTPHost      string `json:"smtp_host"`
+	SMTPPort      string `json:"smtp_port"`
+	IMAPHost      string `json:"imap_host"`
+	IMAPPort      string `json:"imap_port"`
+	IMAPTLS       string `json:"imap_tls,omitempty"`       // tls, starttls or none; derived from the port when empty
+	SMTPTLS       string `json:"smtp_tls,omitempty"`       // tls, starttls or none; derived from the port when empty
+	AuthMechanism string `json:"auth_mechanism,omitempty"` // plain, login, xoauth2 or oauthbearer; empty picks a default for the account
 }
 
 // UserConfig holds user preferences
 type UserConfig struct {
-	Theme           string `json:"theme"`         // UI color theme
-	DefaultNumMails int    `json:"default_mails"` // Number of emails to fetch
+	Theme           string                   `json:"theme"`                  // UI color theme
+	DefaultNumMails int                      `json:"default_mails"`          // Number of emails to fetch
+	Provider        string                   `json:"provider"`               // gmail or imap
+	Server          *Config                  `json:"server,omitempty"`       // Server settings for the imap provider
+	Accounts        map[string]AccountConfig `json:"accounts,omitempty"`     // Per-account overrides of Provider and Server
+	DownloadDir     string                   `json:"download_dir,omitempty"` // Where attachments are saved; ~/Downloads when empty
+}
+
+// AccountConfig holds the server settings of a single named account.
+// Credentials, identity name and signature live in the auth package.
+type AccountConfig struct {
+	Provider string  `json:"provider,omitempty"`
+	Server   *Config `json:"server,omitempty"`
 }
 
 // DefaultConfig provides standard connection settings for Gmail's SMTP and IMAP servers.
@@ -33,6 +69,7 @@ var DefaultConfig = Config{
 var DefaultUserConfig = UserConfig{
 	Theme:           "blue",
 	DefaultNumMails: 50,
+	Provider:        ProviderGmail,
 }
 
 // GetSMTPAddress returns the complete SMTP server address with port for email sending.
@@ -45,6 +82,93 @@ func (c *Config) GetIMAPAddress() string {
 	return c.IMAPHost + ":" + c.IMAPPort
 }
 
+// GetIMAPTLSMode returns the IMAP connection security, defaulting to implicit
+// TLS on port 993 and STARTTLS everywhere else.
+func (c *Config) GetIMAPTLSMode() string {
+	if c.IMAPTLS != "" {
+		return c.IMAPTLS
+	}
+	if c.IMAPPort == "993" {
+		return TLSModeTLS
+	}
+	return TLSModeStartTLS
+}
+
+// GetSMTPTLSMode returns the SMTP connection security, defaulting to implicit
+// TLS on port 465 and STARTTLS everywhere else.
+func (c *Config) GetSMTPTLSMode() string {
+	if c.SMTPTLS != "" {
+		return c.SMTPTLS
+	}
+	if c.SMTPPort == "465" {
+		return TLSModeTLS
+	}
+	return TLSModeStartTLS
+}
+
+// Validate checks that the settings are complete enough to connect with.
+func (c *Config) Validate() error {
+	if c.IMAPHost == "" || c.IMAPPort == "" {
+		return fmt.Errorf("missing IMAP server settings")
+	}
+	if c.SMTPHost == "" || c.SMTPPort == "" {
+		return fmt.Errorf("missing SMTP server settings")
+	}
+	for _, mode := range []string{c.IMAPTLS, c.SMTPTLS} {
+		switch mode {
+		case "", TLSModeTLS, TLSModeStartTLS, TLSModeNone:
+		default:
+			return fmt.Errorf("unknown TLS mode %q", mode)
+		}
+	}
+	switch c.AuthMechanism {
+	case "", AuthMechanismPlain, AuthMechanismLogin, AuthMechanismXOAuth2, AuthMechanismOAuthBearer:
+	default:
+		return fmt.Errorf("unknown auth mechanism %q", c.AuthMechanism)
+	}
+	return nil
+}
+
+// Account returns the settings of the named account, falling back to the
+// top-level provider and server for anything the account does not override.
+func (u *UserConfig) Account(name string) AccountConfig {
+	account := u.Accounts[name]
+	if account.Provider == "" {
+		account.Provider = u.Provider
+	}
+	if account.Server == nil {
+		account.Server = u.Server
+	}
+	return account
+}
+
+// SetAccount stores the settings of the named account
+func (u *UserConfig) SetAccount(name string, account AccountConfig) {
+	if u.Accounts == nil {
+		u.Accounts = map[string]AccountConfig{}
+	}
+	u.Accounts[name] = account
+}
+
+// DownloadDirectory returns the directory attachments are saved to,
+// creating it if needed. A leading ~ stands for the home directory.
+func (u *UserConfig) DownloadDirectory() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %v", err)
+	}
+	dir := filepath.Join(homeDir, "Downloads")
+	if u.DownloadDir == "~" || strings.HasPrefix(u.DownloadDir, "~/") {
+		dir = filepath.Join(homeDir, u.DownloadDir[1:])
+	} else if u.DownloadDir != "" {
+		dir = u.DownloadDir
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create download directory: %v", err)
+	}
+	return dir, nil
+}
+
 // GetConfigDir returns the configuration directory
 func GetConfigDir() (string, error) {
 	homeDir, err := os.UserHomeDir()
@@ -0,0 +1,185 @@
+package email
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxFilenameLength is the longest file name most file systems accept, in bytes
+const maxFilenameLength = 255
+
+// reservedNames are file names Windows refuses whatever their extension
+var reservedNames = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true, "com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true, "lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+// SanitizeFilename turns the file name an email gives an attachment into
+// one that is safe to save: without directories, so it cannot escape the
+// download directory, and without characters file systems reject
+func SanitizeFilename(name string) string {
+	// Senders can put any path in a name, with either kind of slash
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.ToValidUTF8(name, "_")
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`<>:"|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+
+	// Leading dots would hide the file, trailing ones Windows drops
+	name = strings.Trim(name, ". ")
+	if name == "" {
+		return "attachment"
+	}
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	if reservedNames[strings.ToLower(base)] {
+		name = "_" + name
+	}
+	return truncateFilename(name, maxFilenameLength)
+}
+
+// truncateFilename shortens a name to at most n bytes, keeping its
+// extension and whole characters
+func truncateFilename(name string, n int) string {
+	if len(name) <= n {
+		return name
+	}
+	ext := filepath.Ext(name)
+	if len(ext) > n/2 {
+		ext = ""
+	}
+	base := name[:n-len(ext)]
+	for len(base) > 0 && !utf8.ValidString(base) {
+		base = base[:len(base)-1]
+	}
+	return base + ext
+}
+
+// createUniqueFile creates a new file in dir named after name. When the
+// name is taken, a number is added as in "report (1).pdf", so existing
+// files are never overwritten.
+func createUniqueFile(dir, name string) (*os.File, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 0; i < 1000; i++ {
+		candidate := name
+		if i > 0 {
+			suffix := fmt.Sprintf(" (%d)", i)
+			candidate = truncateFilename(base, maxFilenameLength-len(suffix)-len(ext)) + suffix + ext
+		}
+		file, err := os.OpenFile(filepath.Join(dir, candidate), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) {
+			continue
+		}
+		return file, err
+	}
+	return nil, fmt.Errorf("too many files named %s in %s", name, dir)
+}
+
+// SavePart downloads a part of an email, such as an attachment, into dir
+// and returns the path it was saved to. The part goes straight from the
+// server to the file.
+func SavePart(provider MailProvider, email *IncomingMessage, part *MIMEPart, dir string) (string, error) {
+	path, err := SaveFile(dir, part.Filename, func(w io.Writer) error {
+		return provider.WritePart(email, part, w)
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to save %s: %v", part.Filename, err)
+	}
+	return path, nil
+}
+
+// SaveFile saves what write writes to a new file in dir and returns its
+// path. The file is named after name, made safe and numbered if taken. It
+// only appears under that name once write has succeeded, so a failed or
+// interrupted download never leaves half a file behind.
+func SaveFile(dir, name string, write func(w io.Writer) error) (string, error) {
+	// Claim the name first, so two downloads of the same name cannot
+	// overwrite each other
+	target, err := createUniqueFile(dir, SanitizeFilename(name))
+	if err != nil {
+		return "", fmt.Errorf("failed to create file: %v", err)
+	}
+	target.Close()
+	path := target.Name()
+
+	temp, err := os.CreateTemp(dir, ".tmail-download-*")
+	if err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("failed to create file: %v", err)
+	}
+	err = write(temp)
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+// SavedAttachment describes an attachment extracted from an email
+type SavedAttachment struct {
+	Filename    string `json:"filename"`       // Name the email gives it
+	Path        string `json:"path,omitempty"` // Where it was saved; empty when only listed
+	Size        int64  `json:"size"`           // Decoded size in bytes
+	ContentType string `json:"content_type"`
+	SHA256      string `json:"sha256"`  // Hex digest of the decoded content
+	Section     string `json:"section"` // IMAP section of the part, e.g. 2.1
+}
+
+// ExtractAttachments saves the attachments of a complete RFC 5322 message
+// that keep accepts into dir, or only describes them when dir is empty
+func ExtractAttachments(raw io.Reader, dir string, keep func(part *MIMEPart) bool) ([]SavedAttachment, error) {
+	var saved []SavedAttachment
+	err := readAttachments(raw, func(part *MIMEPart, content io.Reader) error {
+		if keep != nil && !keep(part) {
+			return nil
+		}
+		attachment := SavedAttachment{
+			Filename:    part.Filename,
+			ContentType: part.ContentType,
+			Section:     part.Section(),
+		}
+		hash := sha256.New()
+		write := func(w io.Writer) error {
+			n, err := io.Copy(io.MultiWriter(w, hash), content)
+			attachment.Size = n
+			return err
+		}
+
+		var err error
+		if dir == "" {
+			err = write(io.Discard)
+		} else {
+			attachment.Path, err = SaveFile(dir, part.Filename, write)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to save %s: %v", part.Filename, err)
+		}
+		attachment.SHA256 = hex.EncodeToString(hash.Sum(nil))
+		saved = append(saved, attachment)
+		return nil
+	})
+	return saved, err
+}
@@ -0,0 +1,168 @@
+package email
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"report.pdf", "report.pdf"},
+		{"Quarterly report.pdf", "Quarterly report.pdf"},
+		{"../../.bashrc", "bashrc"},
+		{"/etc/passwd", "passwd"},
+		{`C:\Windows\system32\evil.exe`, "evil.exe"},
+		{"what?.txt", "what_.txt"},
+		{"a<b>c:d|e*f\".txt", "a_b_c_d_e_f_.txt"},
+		{"tab\there\x00.txt", "tab_here_.txt"},
+		{"line\r\nbreak.txt", "line__break.txt"},
+		{"...", "attachment"},
+		{"", "attachment"},
+		{"trailing. ", "trailing"},
+		{"CON.txt", "_CON.txt"},
+		{"nul", "_nul"},
+		{"Résumé – 2024.pdf", "Résumé – 2024.pdf"},
+		{"bad\xffbyte.txt", "bad_byte.txt"},
+	}
+
+	for _, tt := range tests {
+		if got := SanitizeFilename(tt.name); got != tt.expected {
+			t.Errorf("SanitizeFilename(%q) = %q, want %q", tt.name, got, tt.expected)
+		}
+	}
+
+	long := SanitizeFilename(strings.Repeat("é", 200) + ".pdf")
+	if len(long) > maxFilenameLength || !strings.HasSuffix(long, ".pdf") || !strings.HasPrefix(long, "éé") {
+		t.Errorf("Long name sanitized to %d bytes: %q", len(long), long)
+	}
+}
+
+func TestSavePart(t *testing.T) {
+	dir := t.TempDir()
+	provider := NewMockProvider()
+	msg := &IncomingMessage{UID: 1}
+	part := &MIMEPart{ContentType: "application/pdf", Filename: "../report.pdf", Path: []int{2}}
+
+	var paths []string
+	for i := 0; i < 3; i++ {
+		path, err := SavePart(provider, msg, part, dir)
+		if err != nil {
+			t.Fatalf("SavePart returned error: %v", err)
+		}
+		paths = append(paths, path)
+	}
+
+	expected := []string{"report.pdf", "report (1).pdf", "report (2).pdf"}
+	for i, path := range paths {
+		if path != filepath.Join(dir, expected[i]) {
+			t.Errorf("Save %d went to %s, want %s", i+1, path, expected[i])
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read saved file: %v", err)
+		}
+		if string(content) != "content of ../report.pdf" {
+			t.Errorf("Saved content = %q", content)
+		}
+	}
+
+	// Nothing is left behind but the saved files
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != len(expected) {
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		t.Errorf("Download directory holds %v", names)
+	}
+}
+
+// brokenProvider loses the connection halfway through downloading a part
+type brokenProvider struct {
+	*MockProvider
+}
+
+func (p brokenProvider) WritePart(email *IncomingMessage, part *MIMEPart, w io.Writer) error {
+	io.WriteString(w, "half of it")
+	return fmt.Errorf("connection lost")
+}
+
+func TestSavePartFailure(t *testing.T) {
+	dir := t.TempDir()
+	msg := &IncomingMessage{UID: 1}
+	part := &MIMEPart{ContentType: "application/pdf", Filename: "report.pdf", Path: []int{2}}
+
+	if _, err := SavePart(brokenProvider{NewMockProvider()}, msg, part, dir); err == nil {
+		t.Fatal("Expected SavePart to fail")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("A failed download left %d files behind", len(entries))
+	}
+}
+
+func TestExtractAttachments(t *testing.T) {
+	dir := t.TempDir()
+	saved, err := ExtractAttachments(strings.NewReader(nestedTestMessage), dir, nil)
+	if err != nil {
+		t.Fatalf("ExtractAttachments returned error: %v", err)
+	}
+	if len(saved) != 2 {
+		t.Fatalf("Expected the PDF and the attached email, got %+v", saved)
+	}
+
+	pdf := saved[0]
+	digest := sha256.Sum256([]byte("%PDF-1.4\n"))
+	if pdf.Filename != "report.pdf" || pdf.ContentType != "application/pdf" || pdf.Section != "2" ||
+		pdf.Size != 9 || pdf.SHA256 != hex.EncodeToString(digest[:]) || pdf.Path != filepath.Join(dir, "report.pdf") {
+		t.Errorf("Unexpected PDF entry %+v", pdf)
+	}
+	if content, _ := os.ReadFile(pdf.Path); string(content) != "%PDF-1.4\n" {
+		t.Errorf("Expected the decoded PDF on disk, got %q", content)
+	}
+
+	// An attached email is saved whole, not taken apart
+	eml := saved[1]
+	content, _ := os.ReadFile(eml.Path)
+	if eml.Filename != "message.eml" || eml.ContentType != "message/rfc822" || !strings.HasPrefix(string(content), "From: Bob") ||
+		int64(len(content)) != eml.Size {
+		t.Errorf("Unexpected attached email entry %+v with content %q", eml, content)
+	}
+}
+
+func TestExtractAttachmentsFiltered(t *testing.T) {
+	dir := t.TempDir()
+	keep := func(part *MIMEPart) bool { return strings.HasSuffix(part.Filename, ".pdf") }
+
+	// Listing saves nothing
+	listed, err := ExtractAttachments(strings.NewReader(nestedTestMessage), "", keep)
+	if err != nil {
+		t.Fatalf("ExtractAttachments returned error: %v", err)
+	}
+	if len(listed) != 1 || listed[0].Filename != "report.pdf" || listed[0].Path != "" || listed[0].SHA256 == "" {
+		t.Errorf("Expected only the PDF to be listed, got %+v", listed)
+	}
+
+	// Saving twice keeps both copies
+	for i := 0; i < 2; i++ {
+		if _, err := ExtractAttachments(strings.NewReader(nestedTestMessage), dir, keep); err != nil {
+			t.Fatalf("ExtractAttachments returned error: %v", err)
+		}
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 || entries[0].Name() != "report (1).pdf" || entries[1].Name() != "report.pdf" {
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		t.Errorf("Expected two copies of the PDF, got %v", names)
+	}
+}
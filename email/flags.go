@@ -0,0 +1,265 @@
+package email
+
+import (
+	"fmt"
+
+	"github.com/emersion/go-imap"
+)
+
+// Flags the reader sets on messages
+const (
+	SeenFlag    = imap.SeenFlag
+	FlaggedFlag = imap.FlaggedFlag
+)
+
+// Seen reports whether the message has been read
+func (email *IncomingMessage) Seen() bool {
+	return email.HasFlag(SeenFlag)
+}
+
+// Flagged reports whether the message is starred
+func (email *IncomingMessage) Flagged() bool {
+	return email.HasFlag(FlaggedFlag)
+}
+
+// HasFlag reports whether the message has an IMAP flag
+func (email *IncomingMessage) HasFlag(flag string) bool {
+	for _, f := range email.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// SetFlag adds or removes an IMAP flag on the local copy of the message
+func (email *IncomingMessage) SetFlag(flag string, set bool) {
+	flags := make([]string, 0, len(email.Flags)+1)
+	for _, f := range email.Flags {
+		if f != flag {
+			flags = append(flags, f)
+		}
+	}
+	if set {
+		flags = append(flags, flag)
+	}
+	email.Flags = flags
+}
+
+// SetFlags adds (or with set false, removes) flags on messages of a mailbox,
+// e.g. \Seen to mark them read or \Flagged to star them
+func (p *GenericIMAPProvider) SetFlags(mailbox string, uids []uint32, flags []string, set bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(uids) == 0 || len(flags) == 0 {
+		return nil
+	}
+	if err := p.ensureConnected(); err != nil {
+		return err
+	}
+	if _, err := p.selectMailbox(mailbox); err != nil {
+		return err
+	}
+	if err := p.storeFlags(uids, flags, set); err != nil {
+		return err
+	}
+
+	// Keep the cache in step so the list is right before the next sync
+	return p.updateCache(mailbox, func(cached *cachedMailbox) {
+		changed := uidSet(uids)
+		for _, email := range cached.Messages {
+			if changed[email.UID] {
+				for _, flag := range flags {
+					email.SetFlag(flag, set)
+				}
+			}
+		}
+	})
+}
+
+// Expunge permanently deletes messages of a mailbox
+func (p *GenericIMAPProvider) Expunge(mailbox string, uids []uint32) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(uids) == 0 {
+		return nil
+	}
+	if err := p.ensureConnected(); err != nil {
+		return err
+	}
+	if _, err := p.selectMailbox(mailbox); err != nil {
+		return err
+	}
+	if err := p.checkExpunge(uids); err != nil {
+		return err
+	}
+	if err := p.storeFlags(uids, []string{imap.DeletedFlag}, true); err != nil {
+		return err
+	}
+	if err := p.expunge(uids); err != nil {
+		return err
+	}
+	return p.forget(mailbox, uids)
+}
+
+// MoveToTrash moves messages of a mailbox to the trash. Messages already in
+// the trash, or on servers without one, are deleted for good.
+func (p *GenericIMAPProvider) MoveToTrash(mailbox string, uids []uint32) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(uids) == 0 {
+		return nil
+	}
+	if err := p.ensureConnected(); err != nil {
+		return err
+	}
+	mailboxes, err := p.listMailboxes()
+	if err != nil {
+		return err
+	}
+	if _, err := p.selectMailbox(mailbox); err != nil {
+		return err
+	}
+
+	trash := FindSpecialUse(mailboxes, imap.TrashAttr)
+	if trash == nil || trash.Name == mailbox {
+		if err := p.checkExpunge(uids); err != nil {
+			return err
+		}
+		if err := p.storeFlags(uids, []string{imap.DeletedFlag}, true); err != nil {
+			return err
+		}
+		err = p.expunge(uids)
+	} else {
+		err = p.move(uids, trash.Name)
+	}
+	if err != nil {
+		return err
+	}
+	return p.forget(mailbox, uids)
+}
+
+// storeFlags adds or removes flags on messages of the selected mailbox
+func (p *GenericIMAPProvider) storeFlags(uids []uint32, flags []string, set bool) error {
+	op := imap.FlagsOp(imap.RemoveFlags)
+	if set {
+		op = imap.AddFlags
+	}
+	values := make([]interface{}, len(flags))
+	for i, flag := range flags {
+		values[i] = flag
+	}
+	if err := p.client.UidStore(uidSeqSet(uids), imap.FormatFlagsOp(op, true), values, nil); err != nil {
+		return fmt.Errorf("failed to change flags: %v", err)
+	}
+	return nil
+}
+
+// checkExpunge makes sure expunging the given messages of the selected
+// mailbox removes no others. Servers without UIDPLUS can only expunge every
+// \Deleted message of the mailbox at once, which is refused while messages
+// the user did not pick are marked \Deleted, e.g. by another mail client.
+// It is called before the messages are changed at all.
+func (p *GenericIMAPProvider) checkExpunge(uids []uint32) error {
+	if ok, _ := p.client.Support("UIDPLUS"); ok {
+		return nil
+	}
+	criteria := imap.NewSearchCriteria()
+	criteria.WithFlags = []string{imap.DeletedFlag}
+	deleted, err := p.client.UidSearch(criteria)
+	if err != nil {
+		return fmt.Errorf("failed to search for deleted messages: %v", err)
+	}
+	selected := uidSet(uids)
+	others := 0
+	for _, uid := range deleted {
+		if !selected[uid] {
+			others++
+		}
+	}
+	if others > 0 {
+		return fmt.Errorf("%d other messages in this folder are marked deleted and the server can only expunge them all at once; expunge them from another client first", others)
+	}
+	return nil
+}
+
+// expunge removes the given messages of the selected mailbox, which must
+// already be flagged \Deleted. Servers without UIDPLUS can only expunge
+// every deleted message of the mailbox at once, so callers make sure with
+// checkExpunge that there are no others first.
+func (p *GenericIMAPProvider) expunge(uids []uint32) error {
+	var err error
+	if ok, _ := p.client.Support("UIDPLUS"); ok {
+		var status *imap.StatusResp
+		status, err = p.client.Execute(&uidExpunge{SeqSet: uidSeqSet(uids)}, nil)
+		if err == nil {
+			err = status.Err()
+		}
+	} else {
+		err = p.client.Expunge(nil)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to expunge: %v", err)
+	}
+	return nil
+}
+
+// forget drops messages that left a mailbox from its cache
+func (p *GenericIMAPProvider) forget(mailbox string, uids []uint32) error {
+	var uidValidity uint32
+	err := p.updateCache(mailbox, func(cached *cachedMailbox) {
+		uidValidity = cached.UIDValidity
+		gone := uidSet(uids)
+		var kept []*IncomingMessage
+		for _, email := range cached.Messages {
+			if !gone[email.UID] {
+				kept = append(kept, email)
+			}
+		}
+		cached.Messages = kept
+	})
+	p.cache.removeMessages(mailbox, uidValidity, uids)
+	return err
+}
+
+// updateCache changes the cached index of a mailbox, if it has one
+func (p *GenericIMAPProvider) updateCache(mailbox string, update func(cached *cachedMailbox)) error {
+	cached, err := p.cache.load(mailbox)
+	if err != nil || len(cached.Messages) == 0 {
+		return err
+	}
+	update(cached)
+	return p.cache.save(mailbox, cached)
+}
+
+// uidExpunge is the UID EXPUNGE command of RFC 4315 (UIDPLUS), which unlike
+// EXPUNGE leaves other deleted messages alone
+type uidExpunge struct {
+	SeqSet *imap.SeqSet
+}
+
+func (cmd *uidExpunge) Command() *imap.Command {
+	return &imap.Command{
+		Name:      "UID",
+		Arguments: []interface{}{imap.RawString("EXPUNGE"), cmd.SeqSet},
+	}
+}
+
+// uidSeqSet returns a set holding the given UIDs
+func uidSeqSet(uids []uint32) *imap.SeqSet {
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(uids...)
+	return seqSet
+}
+
+// uidSet returns the given UIDs as a set for lookups
+func uidSet(uids []uint32) map[uint32]bool {
+	set := make(map[uint32]bool, len(uids))
+	for _, uid := range uids {
+		set[uid] = true
+	}
+	return set
+}
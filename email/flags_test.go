@@ -0,0 +1,144 @@
+package email
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-imap"
+)
+
+func TestMessageFlags(t *testing.T) {
+	msg := &IncomingMessage{Flags: []string{imap.SeenFlag}}
+	if !msg.Seen() || msg.Flagged() {
+		t.Fatalf("Expected a seen, unflagged message, got %v", msg.Flags)
+	}
+
+	msg.SetFlag(imap.FlaggedFlag, true)
+	msg.SetFlag(imap.FlaggedFlag, true)
+	msg.SetFlag(imap.SeenFlag, false)
+	if msg.Seen() || !msg.Flagged() || len(msg.Flags) != 1 {
+		t.Errorf("Expected only \\Flagged, got %v", msg.Flags)
+	}
+}
+
+func TestUIDExpungeCommand(t *testing.T) {
+	var b strings.Builder
+	w := imap.NewClientWriter(&b, nil)
+	command := (&uidExpunge{SeqSet: uidSeqSet([]uint32{3, 4, 7})}).Command()
+	command.Tag = "a1"
+	if err := command.WriteTo(w); err != nil {
+		t.Fatalf("WriteTo returned error: %v", err)
+	}
+	w.Flush()
+
+	expected := "a1 UID EXPUNGE 3:4,7\r\n"
+	if b.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, b.String())
+	}
+}
+
+func TestIMAPProviderMessageOperations(t *testing.T) {
+	useTempCache(t)
+	servers := startTestServers(t)
+	servers.createMailbox(t, "Trash")
+	for i := 1; i <= 3; i++ {
+		servers.addMessage(t, "INBOX", fmt.Sprintf("From: alice@example.com\r\nSubject: Message %d\r\n\r\nBody %d\r\n", i, i))
+	}
+
+	provider, err := NewGenericIMAPProvider(servers.config, testCredentials())
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Disconnect()
+
+	emails, err := provider.GetEmailsPage(InboxName, 0, 10)
+	if err != nil {
+		t.Fatalf("GetEmailsPage returned error: %v", err)
+	}
+	newest, oldest := emails[0], emails[len(emails)-1]
+
+	if err := provider.SetFlags(InboxName, []uint32{newest.UID}, []string{imap.SeenFlag, imap.FlaggedFlag}, true); err != nil {
+		t.Fatalf("SetFlags returned error: %v", err)
+	}
+	inbox := servers.memoryMailbox(t, InboxName)
+	if flags := inbox.Messages[len(inbox.Messages)-1].Flags; !sameFlags(flags, []string{imap.SeenFlag, imap.FlaggedFlag}) {
+		t.Errorf("Expected the server to have \\Seen and \\Flagged, got %v", flags)
+	}
+	cached, _ := provider.CachedEmails(InboxName, 10)
+	if !cached[0].Seen() || !cached[0].Flagged() {
+		t.Errorf("Expected the cache to follow the flags, got %v", cached[0].Flags)
+	}
+
+	if err := provider.SetFlags(InboxName, []uint32{newest.UID}, []string{imap.SeenFlag}, false); err != nil {
+		t.Fatalf("SetFlags returned error: %v", err)
+	}
+	if flags := inbox.Messages[len(inbox.Messages)-1].Flags; !sameFlags(flags, []string{imap.FlaggedFlag}) {
+		t.Errorf("Expected only \\Flagged to remain, got %v", flags)
+	}
+
+	if err := provider.MoveToTrash(InboxName, []uint32{oldest.UID}); err != nil {
+		t.Fatalf("MoveToTrash returned error: %v", err)
+	}
+	if got := len(servers.memoryMailbox(t, InboxName).Messages); got != 3 {
+		t.Errorf("Expected 3 emails left in the inbox, got %d", got)
+	}
+	trash := servers.memoryMailbox(t, "Trash")
+	if len(trash.Messages) != 1 {
+		t.Fatalf("Expected the email in the trash, got %d emails", len(trash.Messages))
+	}
+	cached, _ = provider.CachedEmails(InboxName, 10)
+	if len(cached) != len(emails)-1 {
+		t.Errorf("Expected the cache to forget the trashed email, got %d emails", len(cached))
+	}
+
+	// Deleting from the trash deletes for good
+	if err := provider.MoveToTrash("Trash", []uint32{trash.Messages[0].Uid}); err != nil {
+		t.Fatalf("MoveToTrash returned error: %v", err)
+	}
+	if got := len(servers.memoryMailbox(t, "Trash").Messages); got != 0 {
+		t.Errorf("Expected the trash to be empty, got %d emails", got)
+	}
+}
+
+func TestIMAPProviderExpungeKeepsOtherDeleted(t *testing.T) {
+	useTempCache(t)
+	servers := startTestServers(t)
+	for i := 1; i <= 3; i++ {
+		servers.addMessage(t, "INBOX", fmt.Sprintf("From: alice@example.com\r\nSubject: Message %d\r\n\r\nBody %d\r\n", i, i))
+	}
+	// Another client marked the oldest message deleted without expunging it
+	inbox := servers.memoryMailbox(t, InboxName)
+	inbox.Messages[0].Flags = append(inbox.Messages[0].Flags, imap.DeletedFlag)
+
+	provider, err := NewGenericIMAPProvider(servers.config, testCredentials())
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Disconnect()
+	newest := inbox.Messages[len(inbox.Messages)-1]
+	count := len(inbox.Messages)
+
+	// The test server has no UIDPLUS, so EXPUNGE would remove both
+	if err := provider.Expunge(InboxName, []uint32{newest.Uid}); err == nil {
+		t.Error("Expected Expunge to refuse while another message is marked deleted")
+	}
+	if err := provider.MoveToTrash(InboxName, []uint32{newest.Uid}); err == nil {
+		t.Error("Expected MoveToTrash without a trash to refuse while another message is marked deleted")
+	}
+	if got := len(servers.memoryMailbox(t, InboxName).Messages); got != count {
+		t.Errorf("Expected all %d messages to stay, got %d", count, got)
+	}
+	if sameFlags(newest.Flags, []string{imap.DeletedFlag}) {
+		t.Error("The selected message was marked deleted although it was not expunged")
+	}
+
+	// Once the other message is gone, deleting works
+	inbox.Messages[0].Flags = nil
+	if err := provider.Expunge(InboxName, []uint32{newest.Uid}); err != nil {
+		t.Fatalf("Expunge returned error: %v", err)
+	}
+	if got := len(servers.memoryMailbox(t, InboxName).Messages); got != count-1 {
+		t.Errorf("Expected %d messages left, got %d", count-1, got)
+	}
+}
@@ -2,230 +2,59 @@ package email
 
 import (
 	"fmt"
-	"net/smtp"
+	"strings"
 
 	"github.com/emersion/go-imap"
-	imapClient "github.com/emersion/go-imap/client"
 	"github.com/jacobbanks/tmail/auth"
 )
 
-// GmailProvider implements the MailProvider interface for Gmail
+// GmailProvider implements the MailProvider interface for Gmail.
+// The IMAP and SMTP plumbing is shared with GenericIMAPProvider; this type
+// only pins the Gmail defaults and is the home for Gmail-specific behavior.
 type GmailProvider struct {
-	client    *imapClient.Client
-	smtpAuth  smtp.Auth
-	config    Config
-	userInfo  auth.Credentials
-	connected bool
+	*GenericIMAPProvider
 }
 
-// Connect establishes a connection to Gmail's IMAP server using the provider's credentials.
-// If already connected, it returns nil without reconnecting.
-func (p *GmailProvider) Connect() error {
-	if p.connected && p.client != nil {
-		return nil // Already connected
-	}
-
-	// Validate credentials
-	if p.userInfo.Email == "" || p.userInfo.AppPassword == "" {
-		return fmt.Errorf("missing email credentials - please set up your account first")
-	}
-
-	// Initialize SMTP auth if not already done
-	if p.smtpAuth == nil {
-		p.smtpAuth = smtp.PlainAuth("", p.userInfo.Email, p.userInfo.AppPassword, p.config.SMTPHost)
-	}
-
-	// Connect with timeout
-	client, err := imapClient.DialTLS(p.config.GetIMAPAddress(), nil)
-	if err != nil {
-		return fmt.Errorf("failed to connect to IMAP server: %v", err)
-	}
-
-	err = client.Login(p.userInfo.Email, p.userInfo.AppPassword)
-	if err != nil {
-		client.Logout() // Clean up before returning error
-		return fmt.Errorf("failed to login: %v", err)
-	}
-
-	p.client = client
-	p.connected = true
-	return nil
-}
-
-// Disconnect closes the IMAP connection to the Gmail server.
-// If already disconnected, returns nil without any action.
-func (p *GmailProvider) Disconnect() error {
-	if !p.connected || p.client == nil {
-		return nil // Already disconnected
-	}
-
-	err := p.client.Logout()
-	if err != nil {
-		return fmt.Errorf("error during logout: %v", err)
-	}
-
-	p.client = nil
-	p.connected = false
-	return nil
-}
-
-// IsConnected checks if the provider is currently connected
-func (p *GmailProvider) isConnected() bool {
-	return p.connected && p.client != nil
-}
-
-// GetEmails retrieves and parses emails
-func (p *GmailProvider) GetEmails(limit int) ([]*IncomingMessage, error) {
-	if !p.isConnected() {
-		if err := p.Connect(); err != nil {
-			return nil, err
-		}
+// NewGmailProvider creates a new Gmail provider without connecting
+func NewGmailProvider(config Config, userInfo auth.Credentials) (*GmailProvider, error) {
+	if userInfo.Email == "" || !userInfo.HasSecret() || userInfo.Name == "" {
+		return nil, fmt.Errorf("cannot create gmail provider without values")
 	}
 
-	// Fetch raw messages first
-	messages, err := p.fetchMessages(limit)
+	generic, err := NewGenericIMAPProvider(config, userInfo)
 	if err != nil {
 		return nil, err
 	}
 
-	// Parse messages into Email objects
-	var emails []*IncomingMessage
-	for i := len(messages) - 1; i >= 0; i-- {
-		msg := messages[i]
-		email := &IncomingMessage{}
-		if err := email.Parse(msg); err != nil {
-			// Skip emails that fail to parse
-			continue
-		}
-		emails = append(emails, email)
-	}
-
-	return emails, nil
-}
-
-// fetchMessages gets raw IMAP messages
-func (p *GmailProvider) fetchMessages(limit int) ([]*imap.Message, error) {
-	// Select inbox
-	mailbox, err := p.client.Select("INBOX", false)
-	if err != nil {
-		return nil, fmt.Errorf("failed to select inbox: %v", err)
-	}
-	seqSet := new(imap.SeqSet)
-
-	if limit <= 0 {
-		limit = 10 // Default to 10 emails
-	}
-
-	from := uint32(1)
-	if mailbox.Messages > uint32(limit) {
-		from = mailbox.Messages - uint32(limit) + 1
-	}
-
-	seqSet.AddRange(from, mailbox.Messages)
-
-	section := &imap.BodySectionName{}
-
-	items := []imap.FetchItem{
-		imap.FetchEnvelope,
-		imap.FetchBodyStructure,
-		imap.FetchFlags,
-		section.FetchItem(),
-	}
-
-	messages := make(chan *imap.Message, limit)
-
-	// Start the fetch operation in a goroutine
-	done := make(chan error, 1)
-	go func() {
-		done <- p.client.Fetch(seqSet, items, messages)
-	}()
-
-	var emails []*imap.Message
-	for msg := range messages {
-		emails = append(emails, msg)
-	}
-
-	if err := <-done; err != nil {
-		return nil, fmt.Errorf("fetch failed: %v", err)
-	}
-
-	p.Disconnect()
-	return emails, nil
+	// Gmail already knows which conversation every message belongs to
+	generic.threadIDs = true
+	return &GmailProvider{GenericIMAPProvider: generic}, nil
 }
 
-// SendEmail sends an email message
-func (p *GmailProvider) SendEmail(message *OutgoingMessage) error {
-	// Validate email message
-	if err := validateEmailMessage(message); err != nil {
-		return err
-	}
-
-	// Add attachments
-	for _, path := range message.AttachmentPaths {
-		if _, err := message.PrepAttachment(path); err != nil {
-			return err
-		}
-	}
+// fetchGmailThreadID asks Gmail for the conversation of each message
+const fetchGmailThreadID imap.FetchItem = "X-GM-THRID"
 
-	// Ensure SMTP auth is initialized
-	if p.smtpAuth == nil {
-		p.smtpAuth = smtp.PlainAuth("", p.userInfo.Email, p.userInfo.AppPassword, p.config.SMTPHost)
+// gmailThreadID returns the X-GM-THRID fetched with a message, or "" when
+// the server sent none
+func gmailThreadID(msg *imap.Message) string {
+	value, ok := msg.Items[fetchGmailThreadID]
+	if !ok || value == nil {
+		return ""
 	}
-
-	defer p.Disconnect()
-	// Use cached SMTP auth
-	return message.SendMessage(p.config.GetSMTPAddress(), p.smtpAuth)
+	return strings.TrimSpace(fmt.Sprint(value))
 }
 
-// QuickSend provides a simple way to send a text email
-func (p *GmailProvider) QuickSend(to, subject, body string) error {
-	message, err := NewOutgoingMessage()
-	if err != nil {
-		return err
-	}
-
-	message.AddRecipient(to)
-	message.Subject = subject
-	message.SetTextBody(body)
-
-	p.Disconnect()
-	return p.SendEmail(message)
-}
-
-// GetUserInfo returns the user information
-func (p *GmailProvider) GetUserInfo() (auth.Credentials, error) {
-	return p.userInfo, nil
+// Archive removes messages from the inbox (or the label being read) while
+// keeping them in All Mail. Gmail has no archive folder: moving a message
+// to All Mail is how it drops a label.
+func (g *GmailProvider) Archive(mailbox string, uids []uint32) error {
+	return g.moveToSpecialUse(mailbox, uids, imap.AllAttr)
 }
 
-// NewGmailProvider creates a new Gmail provider and connects immediately
-func NewGmailProvider(config Config, userInfo auth.Credentials) (*GmailProvider, error) {
-	if userInfo.Email == "" || userInfo.AppPassword == "" || userInfo.Name == "" {
-		return nil, fmt.Errorf("cannot create gmail provider without values")
-
-	}
-	provider := &GmailProvider{
-		config:    config,
-		userInfo:  userInfo,
-		connected: false,
-	}
-
-	// Initialize SMTP auth to reuse
-	if userInfo.Email != "" && userInfo.AppPassword != "" {
-		provider.smtpAuth = smtp.PlainAuth("", userInfo.Email, userInfo.AppPassword, config.SMTPHost)
-	}
-
-	return provider, nil
-}
-
-// validateEmailMessage verifies that an email message is valid
-func validateEmailMessage(message *OutgoingMessage) error {
-	if message == nil {
-		return fmt.Errorf("email message is nil")
-	}
-
-	if len(message.To) == 0 && len(message.Cc) == 0 && len(message.Bcc) == 0 {
-		return fmt.Errorf("email must have at least one recipient")
-	}
-
-	return nil
+// Search hands the query to Gmail's own search engine with X-GM-RAW, so it
+// matches what the Gmail web interface would find
+func (g *GmailProvider) Search(mailbox string, query *SearchQuery, n int) ([]*IncomingMessage, error) {
+	return g.search(mailbox, n, func() ([]uint32, error) {
+		return g.gmailRawSearch(query.gmailQuery())
+	})
 }
@@ -0,0 +1,789 @@
+package email
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/mattn/go-runewidth"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// defaultHTMLWidth is the width HTML is wrapped to when there is no screen
+// to measure, e.g. for the plain text kept in Body
+const defaultHTMLWidth = 78
+
+// RenderHTML converts an HTML email body into text for the terminal,
+// wrapped to width columns. Headings, lists, tables and quotes are laid
+// out as text and links become footnotes like [1]. With styled set the
+// text carries tview color tags; otherwise it is plain.
+func RenderHTML(src string, width int, styled bool) string {
+	doc, err := html.Parse(strings.NewReader(src))
+	if err != nil {
+		return ""
+	}
+	if width <= 0 {
+		width = defaultHTMLWidth
+	}
+
+	r := &htmlRenderer{width: width, styled: styled, linkNumbers: map[string]int{}}
+	r.children(doc)
+	r.flush()
+	return r.finish()
+}
+
+// textStyle is how a piece of text looks in the terminal
+type textStyle struct {
+	color                           string // tview color name, "" for the default
+	bold, italic, underline, strike bool
+}
+
+// tag returns the tview tag that switches to the style
+func (s textStyle) tag() string {
+	color := s.color
+	if color == "" {
+		color = "-"
+	}
+	attrs := ""
+	if s.bold {
+		attrs += "b"
+	}
+	if s.italic {
+		attrs += "i"
+	}
+	if s.underline {
+		attrs += "u"
+	}
+	if s.strike {
+		attrs += "s"
+	}
+	if attrs == "" {
+		attrs = "-"
+	}
+	return "[" + color + "::" + attrs + "]"
+}
+
+// htmlSpan is a run of text in one style. A span of "\n" is a line break.
+type htmlSpan struct {
+	text  string
+	style textStyle
+}
+
+// htmlWord is what wrapping never splits: text between spaces, possibly in
+// several styles
+type htmlWord struct {
+	pieces  []htmlSpan
+	width   int
+	newline bool // A forced line break rather than a word
+}
+
+// htmlIndent is what the lines of a list item or quote start with
+type htmlIndent struct {
+	first, rest string // Prefix of the first line and of the lines after it
+	color       string
+	used        bool // Whether the first line has been written
+}
+
+// htmlRenderer lays out an HTML document as lines of text. Inline content
+// is collected into spans until a block element ends the paragraph, which
+// is then wrapped below the current indents.
+type htmlRenderer struct {
+	width   int
+	styled  bool
+	lines   []string
+	blank   bool // A blank line is due before the next line
+	indents []*htmlIndent
+	spans   []htmlSpan // The paragraph being collected
+	style   textStyle  // Style of inline text at this point of the document
+	pre     int        // How many <pre> elements the text is in
+	lists   int        // How many lists the text is in
+
+	links       []string
+	linkNumbers map[string]int
+}
+
+// children renders the children of n
+func (r *htmlRenderer) children(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		r.node(c)
+	}
+}
+
+// node renders n and its children
+func (r *htmlRenderer) node(n *html.Node) {
+	if n.Type == html.TextNode {
+		r.text(n.Data)
+		return
+	}
+	if n.Type != html.ElementNode || hiddenElement(n) {
+		return
+	}
+
+	saved := r.style
+	defer func() { r.style = saved }()
+
+	switch n.DataAtom {
+	case atom.Head, atom.Script, atom.Style, atom.Title, atom.Template, atom.Noscript, atom.Svg, atom.Object:
+		// Not content
+	case atom.Br:
+		r.spans = append(r.spans, htmlSpan{text: "\n"})
+	case atom.Hr:
+		r.breakParagraph()
+		rule := strings.Repeat("─", r.available())
+		if r.styled {
+			rule = "[gray]" + rule + "[-]"
+		}
+		r.emit(rule)
+		r.blank = true
+	case atom.P, atom.Dl, atom.Figure:
+		r.breakParagraph()
+		r.children(n)
+		r.breakParagraph()
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		r.breakParagraph()
+		r.style.bold = true
+		if n.DataAtom == atom.H1 || n.DataAtom == atom.H2 {
+			r.style.color = "yellow"
+		}
+		r.children(n)
+		r.breakParagraph()
+	case atom.Blockquote:
+		r.breakParagraph()
+		r.indented(&htmlIndent{first: "│ ", rest: "│ ", color: "gray"}, n)
+		r.breakParagraph()
+	case atom.Dd:
+		r.breakLine()
+		r.indented(&htmlIndent{first: "    ", rest: "    "}, n)
+	case atom.Pre:
+		r.breakParagraph()
+		r.pre++
+		r.children(n)
+		r.flushPre()
+		r.pre--
+		r.blank = true
+	case atom.Ul, atom.Ol:
+		r.list(n)
+	case atom.Table:
+		if layoutTable(n) {
+			// Most HTML email is laid out with tables whose cells are
+			// better read one after the other
+			r.breakLine()
+			r.children(n)
+			r.breakLine()
+		} else {
+			r.breakParagraph()
+			r.table(n)
+			r.breakParagraph()
+		}
+	case atom.Div, atom.Section, atom.Article, atom.Header, atom.Footer, atom.Main, atom.Nav, atom.Aside,
+		atom.Center, atom.Form, atom.Address, atom.Figcaption, atom.Dt, atom.Li, atom.Tr, atom.Td, atom.Th,
+		atom.Caption, atom.Details, atom.Summary, atom.Fieldset:
+		r.breakLine()
+		r.children(n)
+		r.breakLine()
+	case atom.A:
+		r.link(n)
+	case atom.Img:
+		if alt := strings.TrimSpace(attribute(n, "alt")); alt != "" {
+			r.text(alt)
+		}
+	case atom.B, atom.Strong:
+		r.style.bold = true
+		r.children(n)
+	case atom.I, atom.Em, atom.Cite, atom.Var:
+		r.style.italic = true
+		r.children(n)
+	case atom.U, atom.Ins:
+		r.style.underline = true
+		r.children(n)
+	case atom.S, atom.Strike, atom.Del:
+		r.style.strike = true
+		r.children(n)
+	case atom.Code, atom.Kbd, atom.Samp, atom.Tt:
+		r.style.color = "teal"
+		r.children(n)
+	default:
+		r.children(n)
+	}
+}
+
+// text adds a text node to the paragraph, with its white space collapsed
+// unless it is preformatted
+func (r *htmlRenderer) text(data string) {
+	if r.pre == 0 {
+		data = collapseSpace(data)
+	} else {
+		data = strings.ReplaceAll(data, "\t", "    ")
+	}
+	if data != "" {
+		r.spans = append(r.spans, htmlSpan{text: data, style: r.style})
+	}
+}
+
+// link renders a link's text followed by the number of its footnote
+func (r *htmlRenderer) link(n *html.Node) {
+	href := strings.TrimSpace(attribute(n, "href"))
+	r.style.color = "blue"
+	r.style.underline = true
+	start := len(r.spans)
+	r.children(n)
+
+	if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+		return
+	}
+	// A link that shows its own address needs no footnote
+	var text strings.Builder
+	for _, span := range r.spans[start:] {
+		text.WriteString(span.text)
+	}
+	if shown := strings.TrimSpace(text.String()); shown == href || "mailto:"+shown == href {
+		return
+	}
+
+	number, ok := r.linkNumbers[href]
+	if !ok {
+		r.links = append(r.links, href)
+		number = len(r.links)
+		r.linkNumbers[href] = number
+	}
+	r.spans = append(r.spans, htmlSpan{text: fmt.Sprintf("[%d]", number), style: textStyle{color: "gray"}})
+}
+
+// list renders a list, numbering the items of ordered ones
+func (r *htmlRenderer) list(n *html.Node) {
+	// Top level lists stand apart from the text around them
+	if r.lists == 0 {
+		r.breakParagraph()
+	} else {
+		r.breakLine()
+	}
+	r.lists++
+
+	bullets := []string{"• ", "◦ ", "▪ "}
+	number := 1
+	if start, err := strconv.Atoi(attribute(n, "start")); err == nil {
+		number = start
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.DataAtom != atom.Li || hiddenElement(c) {
+			r.node(c)
+			continue
+		}
+		marker := bullets[(r.lists-1)%len(bullets)]
+		if n.DataAtom == atom.Ol {
+			marker = fmt.Sprintf("%d. ", number)
+			number++
+		}
+		r.breakLine()
+		r.indented(&htmlIndent{first: marker, rest: strings.Repeat(" ", runewidth.StringWidth(marker))}, c)
+	}
+
+	r.lists--
+	if r.lists == 0 {
+		r.breakParagraph()
+	} else {
+		r.breakLine()
+	}
+}
+
+// indented renders the children of n below an extra indent
+func (r *htmlRenderer) indented(indent *htmlIndent, n *html.Node) {
+	r.indents = append(r.indents, indent)
+	r.children(n)
+	r.flush()
+	r.indents = r.indents[:len(r.indents)-1]
+}
+
+// table renders a data table as aligned columns, wrapping cells that do
+// not fit
+func (r *htmlRenderer) table(n *html.Node) {
+	var rows [][]string
+	var header []bool
+	var walk func(*html.Node, bool)
+	walk = func(n *html.Node, inHead bool) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode || hiddenElement(c) {
+				continue
+			}
+			switch c.DataAtom {
+			case atom.Thead:
+				walk(c, true)
+			case atom.Tbody, atom.Tfoot:
+				walk(c, false)
+			case atom.Tr:
+				var cells []string
+				isHeader := inHead
+				for cell := c.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.Type == html.ElementNode && (cell.DataAtom == atom.Td || cell.DataAtom == atom.Th) {
+						cells = append(cells, strings.TrimSpace(collapseSpace(nodeText(cell))))
+						isHeader = isHeader || cell.DataAtom == atom.Th
+					}
+				}
+				if len(cells) > 0 {
+					rows = append(rows, cells)
+					header = append(header, isHeader)
+				}
+			}
+		}
+	}
+	walk(n, false)
+	if len(rows) == 0 {
+		return
+	}
+
+	// Give every column the width of its widest cell, then narrow the
+	// widest columns until the table fits
+	var widths []int
+	for _, row := range rows {
+		for i, cell := range row {
+			if i == len(widths) {
+				widths = append(widths, 1)
+			}
+			widths[i] = max(widths[i], runewidth.StringWidth(cell))
+		}
+	}
+	const separator = " │ "
+	available := r.available() - (len(widths)-1)*len(separator)
+	for total := sum(widths); total > available; total-- {
+		widest := 0
+		for i, width := range widths {
+			if width > widths[widest] {
+				widest = i
+			}
+		}
+		if widths[widest] <= 4 {
+			break
+		}
+		widths[widest]--
+	}
+
+	sep := separator
+	if r.styled {
+		sep = "[gray]" + separator + "[-]"
+	}
+	for i, row := range rows {
+		wrapped := make([][]string, len(widths))
+		height := 1
+		for col := range widths {
+			cell := ""
+			if col < len(row) {
+				cell = row[col]
+			}
+			wrapped[col] = wrapPlain(cell, widths[col])
+			height = max(height, len(wrapped[col]))
+		}
+		for line := 0; line < height; line++ {
+			var b strings.Builder
+			for col, width := range widths {
+				if col > 0 {
+					b.WriteString(sep)
+				}
+				text := ""
+				if line < len(wrapped[col]) {
+					text = wrapped[col][line]
+				}
+				padding := strings.Repeat(" ", width-runewidth.StringWidth(text))
+				if r.styled {
+					text = escapeTags(text)
+					if header[i] && text != "" {
+						text = "[::b]" + text + "[::-]"
+					}
+				}
+				b.WriteString(text + padding)
+			}
+			r.emit(strings.TrimRight(b.String(), " "))
+		}
+		if header[i] && (i+1 == len(rows) || !header[i+1]) {
+			parts := make([]string, len(widths))
+			for col, width := range widths {
+				parts[col] = strings.Repeat("─", width)
+			}
+			rule := strings.Join(parts, "─┼─")
+			if r.styled {
+				rule = "[gray]" + rule + "[-]"
+			}
+			r.emit(rule)
+		}
+	}
+}
+
+// breakLine ends the paragraph being collected
+func (r *htmlRenderer) breakLine() {
+	r.flush()
+}
+
+// breakParagraph ends the paragraph being collected and leaves a blank
+// line before whatever comes next
+func (r *htmlRenderer) breakParagraph() {
+	r.flush()
+	if len(r.lines) > 0 {
+		r.blank = true
+	}
+}
+
+// flush wraps the paragraph being collected into lines
+func (r *htmlRenderer) flush() {
+	spans := r.spans
+	r.spans = nil
+	if r.pre > 0 {
+		r.spans = spans
+		return
+	}
+
+	var words []htmlWord
+	current := htmlWord{}
+	endWord := func() {
+		if len(current.pieces) > 0 {
+			words = append(words, current)
+		}
+		current = htmlWord{}
+	}
+	for _, span := range spans {
+		if span.text == "\n" {
+			endWord()
+			words = append(words, htmlWord{newline: true})
+			continue
+		}
+		for text := span.text; text != ""; {
+			if text[0] == ' ' {
+				endWord()
+				text = strings.TrimLeft(text, " ")
+				continue
+			}
+			end := strings.IndexByte(text, ' ')
+			if end < 0 {
+				end = len(text)
+			}
+			current.pieces = append(current.pieces, htmlSpan{text: text[:end], style: span.style})
+			current.width += runewidth.StringWidth(text[:end])
+			text = text[end:]
+		}
+	}
+	endWord()
+
+	// Line breaks at the edges of a paragraph add nothing
+	for len(words) > 0 && words[0].newline {
+		words = words[1:]
+	}
+	for len(words) > 0 && words[len(words)-1].newline {
+		words = words[:len(words)-1]
+	}
+	if len(words) == 0 {
+		return
+	}
+
+	available := r.available()
+	var line []htmlWord
+	width := 0
+	for _, word := range words {
+		if word.newline {
+			r.emit(r.join(line))
+			line, width = nil, 0
+			continue
+		}
+		// Words longer than a line, such as long addresses, stay whole
+		if len(line) > 0 && width+1+word.width > available {
+			r.emit(r.join(line))
+			line, width = nil, 0
+		}
+		if len(line) > 0 {
+			width++
+		}
+		line = append(line, word)
+		width += word.width
+	}
+	if len(line) > 0 {
+		r.emit(r.join(line))
+	}
+}
+
+// flushPre writes the preformatted text collected as it is
+func (r *htmlRenderer) flushPre() {
+	lines := [][]htmlSpan{nil}
+	for _, span := range r.spans {
+		for i, text := range strings.Split(span.text, "\n") {
+			if i > 0 {
+				lines = append(lines, nil)
+			}
+			if text != "" {
+				lines[len(lines)-1] = append(lines[len(lines)-1], htmlSpan{text: text, style: span.style})
+			}
+		}
+	}
+	r.spans = nil
+
+	// A newline right after <pre> is not part of the content
+	if len(lines) > 1 && len(lines[0]) == 0 {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	for _, line := range lines {
+		r.emit(r.join([]htmlWord{{pieces: line}}))
+	}
+}
+
+// join writes a line of words separated by spaces, switching styles as
+// the words need
+func (r *htmlRenderer) join(line []htmlWord) string {
+	var b strings.Builder
+	current := textStyle{}
+	write := func(text string, style textStyle) {
+		if !r.styled {
+			b.WriteString(text)
+			return
+		}
+		if style != current {
+			b.WriteString(style.tag())
+			current = style
+		}
+		b.WriteString(escapeTags(text))
+	}
+	for i, word := range line {
+		if i > 0 {
+			// Spaces inside a link or bold text are part of it
+			space := textStyle{}
+			previous := line[i-1].pieces
+			if previous[len(previous)-1].style == word.pieces[0].style {
+				space = word.pieces[0].style
+			}
+			write(" ", space)
+		}
+		for _, piece := range word.pieces {
+			write(piece.text, piece.style)
+		}
+	}
+	if current != (textStyle{}) {
+		b.WriteString("[-::-]")
+	}
+	return b.String()
+}
+
+// emit writes a line below the current indents
+func (r *htmlRenderer) emit(line string) {
+	if r.blank && len(r.lines) > 0 {
+		r.lines = append(r.lines, strings.TrimRight(r.prefix(true), " "))
+	}
+	r.blank = false
+	r.lines = append(r.lines, r.prefix(false)+line)
+	for _, indent := range r.indents {
+		indent.used = true
+	}
+}
+
+// prefix returns what the next line starts with. Blank lines only continue
+// the indents that already have lines, so a quote does not open with one.
+func (r *htmlRenderer) prefix(blank bool) string {
+	var b strings.Builder
+	for _, indent := range r.indents {
+		text := indent.first
+		if blank && !indent.used {
+			break
+		}
+		if indent.used {
+			text = indent.rest
+		}
+		if r.styled && indent.color != "" {
+			text = "[" + indent.color + "]" + text + "[-]"
+		}
+		b.WriteString(text)
+	}
+	return b.String()
+}
+
+// available returns how many columns are left beside the indents
+func (r *htmlRenderer) available() int {
+	available := r.width
+	for _, indent := range r.indents {
+		available -= runewidth.StringWidth(indent.rest)
+	}
+	return max(available, 20)
+}
+
+// finish joins the lines and lists the links they refer to
+func (r *htmlRenderer) finish() string {
+	for len(r.lines) > 0 && strings.TrimSpace(r.lines[len(r.lines)-1]) == "" {
+		r.lines = r.lines[:len(r.lines)-1]
+	}
+	if len(r.links) > 0 {
+		r.lines = append(r.lines, "")
+		for i, link := range r.links {
+			number := fmt.Sprintf("[%d]", i+1)
+			if r.styled {
+				number = "[gray]" + escapeTags(number) + "[-]"
+				link = escapeTags(link)
+			}
+			r.lines = append(r.lines, number+" "+link)
+		}
+	}
+	return strings.Join(r.lines, "\n")
+}
+
+// layoutTable reports whether a table arranges the page rather than
+// holding data: it nests block content or has a single column
+func layoutTable(table *html.Node) bool {
+	if strings.EqualFold(attribute(table, "role"), "presentation") {
+		return true
+	}
+	columns := 0
+	layout := false
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil && !layout; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			switch c.DataAtom {
+			case atom.Table, atom.P, atom.Div, atom.Ul, atom.Ol, atom.Blockquote, atom.Pre,
+				atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+				layout = true
+				return
+			case atom.Tr:
+				cells := 0
+				for cell := c.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.Type == html.ElementNode && (cell.DataAtom == atom.Td || cell.DataAtom == atom.Th) {
+						cells++
+					}
+				}
+				columns = max(columns, cells)
+			}
+			walk(c)
+		}
+	}
+	walk(table)
+	return layout || columns < 2
+}
+
+// hiddenElement reports whether an element is not meant to be seen, like
+// the preview text newsletters hide at their top
+func hiddenElement(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	for _, attr := range n.Attr {
+		switch attr.Key {
+		case "hidden":
+			return true
+		case "style":
+			style := strings.ToLower(strings.ReplaceAll(attr.Val, " ", ""))
+			if strings.Contains(style, "display:none") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// attribute returns the value of an attribute of n, or ""
+func attribute(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// nodeText returns the text inside n, with images standing in by their
+// alternative text
+func nodeText(n *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			b.WriteString(n.Data)
+		case n.Type == html.ElementNode && hiddenElement(n):
+		case n.Type == html.ElementNode && n.DataAtom == atom.Img:
+			b.WriteString(attribute(n, "alt"))
+		case n.Type == html.ElementNode && n.DataAtom == atom.Br:
+			b.WriteString(" ")
+		default:
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				walk(c)
+			}
+		}
+	}
+	walk(n)
+	return b.String()
+}
+
+// collapseSpace turns every run of white space into a single space and
+// drops the invisible characters email templates pad their text with
+func collapseSpace(text string) string {
+	var b strings.Builder
+	space := false
+	for _, c := range text {
+		switch {
+		case unicode.IsSpace(c):
+			space = true
+		case c == '\u200b' || c == '\u200c' || c == '\u200d' || c == '\u2060' || c == '\ufeff' || c == '\u034f' || c == '\u00ad':
+		default:
+			if space {
+				b.WriteByte(' ')
+				space = false
+			}
+			b.WriteRune(c)
+		}
+	}
+	if space {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
+// wrapPlain wraps unstyled text to width columns, breaking words that are
+// longer than a line
+func wrapPlain(text string, width int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		for runewidth.StringWidth(word) > width {
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			head := runewidth.Truncate(word, width, "")
+			if head == "" {
+				break
+			}
+			lines = append(lines, head)
+			word = word[len(head):]
+		}
+		switch {
+		case word == "":
+		case line == "":
+			line = word
+		case runewidth.StringWidth(line)+1+runewidth.StringWidth(word) <= width:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" || len(lines) == 0 {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// tagPattern matches text tview would take for a color tag or region
+var tagPattern = regexp.MustCompile(`(\[[a-zA-Z0-9_,;: \-\."#]+\[*)\]`)
+
+// escapeTags keeps tview from reading brackets in text as tags
+func escapeTags(text string) string {
+	return tagPattern.ReplaceAllString(text, "$1[]")
+}
+
+// sum returns the sum of numbers
+func sum(numbers []int) int {
+	total := 0
+	for _, n := range numbers {
+		total += n
+	}
+	return total
+}
@@ -0,0 +1,78 @@
+package email
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderHTML(t *testing.T) {
+	src := `<html><head><style>p { color: red }</style></head><body>
+<div style="display: none">Preview text</div>
+<h1>Weekly  News</h1>
+<p>Read <a href="https://example.com/post">our post</a> on
+<a href="https://example.com">https://example.com</a>.</p>
+<ul><li>One</li><li>Two<ul><li>Nested</li></ul></li></ul>
+<ol start="3"><li>Three</li></ol>
+<blockquote><p>Quoted</p><p>Again</p></blockquote>
+<table><tr><th>Name</th><th>Size</th></tr><tr><td>report.pdf</td><td>12 KB</td></tr></table>
+<pre>
+  indented
+</pre>
+<p>Line one<br>Line two</p>
+</body></html>`
+
+	expected := strings.Join([]string{
+		"Weekly News",
+		"",
+		"Read our post[1] on https://example.com.",
+		"",
+		"• One",
+		"• Two",
+		"  ◦ Nested",
+		"",
+		"3. Three",
+		"",
+		"│ Quoted",
+		"│",
+		"│ Again",
+		"",
+		"Name       │ Size",
+		"───────────┼──────",
+		"report.pdf │ 12 KB",
+		"",
+		"  indented",
+		"",
+		"Line one",
+		"Line two",
+		"",
+		"[1] https://example.com/post",
+	}, "\n")
+	if got := RenderHTML(src, 40, false); got != expected {
+		t.Errorf("Expected:\n%s\n\ngot:\n%s", expected, got)
+	}
+}
+
+func TestRenderHTMLWraps(t *testing.T) {
+	got := RenderHTML("<blockquote>one two three four five six seven eight nine ten eleven twelve</blockquote>", 24, false)
+	for _, line := range strings.Split(got, "\n") {
+		if !strings.HasPrefix(line, "│ ") || len([]rune(line)) > 24 {
+			t.Errorf("Expected quoted lines of at most 24 columns, got %q", line)
+		}
+	}
+}
+
+func TestRenderHTMLStyled(t *testing.T) {
+	got := RenderHTML(`<p><b>Bold</b> [red] <a href="https://example.com">link</a></p>`, 40, true)
+	expected := "[-::b]Bold[-::-] [red[] [blue::u]link[gray::-][1[][-::-]\n\n[gray][1[][-] https://example.com"
+	if got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestRenderHTMLLayoutTables(t *testing.T) {
+	// Tables that only lay out the page are read cell by cell
+	got := RenderHTML(`<table><tr><td><p>Header</p></td></tr><tr><td>Body</td></tr></table>`, 40, false)
+	if got != "Header\n\nBody" {
+		t.Errorf("Expected the cells one after the other, got %q", got)
+	}
+}
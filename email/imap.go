@@ -0,0 +1,696 @@
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime/quotedprintable"
+	"net/smtp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/emersion/go-imap"
+	imapClient "github.com/emersion/go-imap/client"
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+	"github.com/emersion/go-message/textproto"
+	"github.com/emersion/go-sasl"
+	"github.com/jacobbanks/tmail/auth"
+)
+
+// GenericIMAPProvider implements the MailProvider interface for any standard
+// IMAP/SMTP server. Everything it needs to know about the server comes from
+// its Config, so it works for Fastmail, Dovecot/Postfix and friends.
+type GenericIMAPProvider struct {
+	mu            sync.Mutex // Serializes use of client
+	client        *imapClient.Client
+	authenticator auth.Authenticator
+	config        Config
+	userInfo      auth.Credentials
+	connected     bool
+	cache         *MessageCache
+	condstore     bool // Whether the server reports MODSEQs (RFC 7162)
+	qresync       bool // Whether QRESYNC is enabled, so expunges come as VANISHED
+	threadIDs     bool // Whether to fetch Gmail's X-GM-THRID with each message
+}
+
+// referencesSection is the References header, fetched with list entries
+// since the envelope lacks it
+var referencesSection = &imap.BodySectionName{
+	BodyPartName: imap.BodyPartName{
+		Specifier: imap.HeaderSpecifier,
+		Fields:    []string{"References"},
+	},
+	Peek: true,
+}
+
+// NewGenericIMAPProvider creates a provider for the server described by config.
+// Like NewGmailProvider it does not connect until Connect is called.
+func NewGenericIMAPProvider(config Config, userInfo auth.Credentials) (*GenericIMAPProvider, error) {
+	authenticator, err := auth.NewAuthenticator(userInfo)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create imap provider without credentials: %v", err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid server settings: %v", err)
+	}
+
+	// Only accounts from the credential store have a name to cache under
+	cache, err := NewMessageCache(userInfo.Account)
+	if err != nil {
+		cache = newMemoryCache()
+	}
+
+	return &GenericIMAPProvider{
+		authenticator: authenticator,
+		config:        config,
+		userInfo:      userInfo,
+		connected:     false,
+		cache:         cache,
+	}, nil
+}
+
+// Connect dials the IMAP server using the configured TLS mode and logs in.
+// If already connected, it returns nil without reconnecting.
+func (p *GenericIMAPProvider) Connect() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.connect()
+}
+
+// connect is Connect for callers already holding the lock
+func (p *GenericIMAPProvider) connect() error {
+	if p.connected && p.client != nil {
+		return nil // Already connected
+	}
+
+	// Validate credentials
+	if p.authenticator == nil {
+		return fmt.Errorf("missing email credentials - please set up your account first")
+	}
+
+	client, err := p.dialIMAP()
+	if err != nil {
+		return fmt.Errorf("failed to connect to IMAP server: %v", err)
+	}
+
+	if err := p.loginIMAP(client); err != nil {
+		client.Logout() // Clean up before returning error
+		return fmt.Errorf("failed to login: %v", err)
+	}
+
+	p.client = client
+	p.connected = true
+	p.enableExtensions()
+	return nil
+}
+
+// dialIMAP opens the IMAP connection, upgrading it with STARTTLS when asked to
+func (p *GenericIMAPProvider) dialIMAP() (*imapClient.Client, error) {
+	addr := p.config.GetIMAPAddress()
+	tlsConfig := &tls.Config{ServerName: p.config.IMAPHost}
+
+	switch p.config.GetIMAPTLSMode() {
+	case TLSModeTLS:
+		return imapClient.DialTLS(addr, tlsConfig)
+	case TLSModeStartTLS:
+		client, err := imapClient.Dial(addr)
+		if err != nil {
+			return nil, err
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Logout()
+			return nil, fmt.Errorf("STARTTLS failed: %v", err)
+		}
+		return client, nil
+	case TLSModeNone:
+		return imapClient.Dial(addr)
+	default:
+		return nil, fmt.Errorf("unknown TLS mode %q", p.config.GetIMAPTLSMode())
+	}
+}
+
+// authMechanism returns the SASL name of the configured mechanism, or "" to
+// negotiate one with the server
+func (p *GenericIMAPProvider) authMechanism() string {
+	return strings.ToUpper(p.config.AuthMechanism)
+}
+
+// loginIMAP authenticates with the configured mechanism, or the first of the
+// authenticator's mechanisms the server supports. Like SMTP, it refuses to
+// send credentials in the clear to anything but this machine.
+func (p *GenericIMAPProvider) loginIMAP(client *imapClient.Client) error {
+	if !client.IsTLS() && !auth.IsLocalhost(p.config.IMAPHost) {
+		return fmt.Errorf("unencrypted connection to %s, set imap_tls to tls or starttls", p.config.IMAPHost)
+	}
+
+	mechanism := p.authMechanism()
+	if mechanism == "" {
+		mechanism = auth.NegotiateMechanism(p.authenticator, func(m string) bool {
+			if m == sasl.Login {
+				return true // Every server understands the LOGIN command
+			}
+			ok, _ := client.SupportAuth(m)
+			return ok
+		})
+		if mechanism == "" {
+			return fmt.Errorf("server supports none of %s", strings.Join(p.authenticator.Mechanisms(), ", "))
+		}
+	}
+
+	port, _ := strconv.Atoi(p.config.IMAPPort)
+	saslClient, err := p.authenticator.SASLClient(mechanism, p.config.IMAPHost, port)
+	if err != nil {
+		return err
+	}
+
+	if mechanism == sasl.Login {
+		return loginCommand(client, saslClient)
+	}
+	return client.Authenticate(saslClient)
+}
+
+// loginCommand logs in with the IMAP LOGIN command, which carries the same
+// username and password as the SASL LOGIN mechanism and is more widely supported
+func loginCommand(client *imapClient.Client, saslClient sasl.Client) error {
+	_, username, err := saslClient.Start()
+	if err != nil {
+		return err
+	}
+	password, err := saslClient.Next([]byte("Password:"))
+	if err != nil {
+		return err
+	}
+	return client.Login(string(username), string(password))
+}
+
+// getSMTPAuth returns the SMTP authentication for the configured mechanism.
+// The SASL client is built when the server asks for it, so secrets such as
+// OAuth2 tokens are fresh for every message.
+func (p *GenericIMAPProvider) getSMTPAuth() smtp.Auth {
+	port, _ := strconv.Atoi(p.config.SMTPPort)
+	return auth.NewSMTPAuth(p.authenticator, p.authMechanism(), p.config.SMTPHost, port)
+}
+
+// Disconnect closes the IMAP connection and saves what the search index
+// learned from the bodies opened since the last sync.
+// If already disconnected, returns nil without any action.
+func (p *GenericIMAPProvider) Disconnect() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cache.flushIndex()
+	return p.disconnect()
+}
+
+// disconnect is Disconnect for callers already holding the lock
+func (p *GenericIMAPProvider) disconnect() error {
+	if !p.connected || p.client == nil {
+		return nil // Already disconnected
+	}
+
+	err := p.client.Logout()
+	if err != nil {
+		return fmt.Errorf("error during logout: %v", err)
+	}
+
+	p.client = nil
+	p.connected = false
+	return nil
+}
+
+// isConnected checks if the provider is currently connected. The
+// connection now stays open between operations, so a server that timed it
+// out also counts as disconnected.
+func (p *GenericIMAPProvider) isConnected() bool {
+	return p.connected && p.client != nil && p.client.State() != imap.LogoutState
+}
+
+// ensureConnected connects unless the provider already is. The caller must
+// hold the lock.
+func (p *GenericIMAPProvider) ensureConnected() error {
+	if p.isConnected() {
+		return nil
+	}
+	return p.connect()
+}
+
+// GetEmails retrieves and parses the most recent emails in the inbox
+func (p *GenericIMAPProvider) GetEmails(limit int) ([]*IncomingMessage, error) {
+	return p.GetMailboxEmails(InboxName, limit)
+}
+
+// GetMailboxEmails retrieves and parses the most recent emails in a mailbox
+func (p *GenericIMAPProvider) GetMailboxEmails(mailbox string, limit int) ([]*IncomingMessage, error) {
+	return p.GetEmailsPage(mailbox, 0, limit)
+}
+
+// GetEmailsPage retrieves up to n emails from a mailbox whose UID is lower
+// than beforeUID, newest first. A beforeUID of 0 starts at the newest
+// message; passing the UID of the oldest email of one page fetches the next.
+// Unlike sequence numbers, UIDs don't shift when new mail arrives, so pages
+// neither skip nor repeat messages.
+//
+// The first page brings the cached mailbox up to date with the server and
+// later pages are served from the cache when possible.
+func (p *GenericIMAPProvider) GetEmailsPage(mailbox string, beforeUID uint32, n int) ([]*IncomingMessage, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if n <= 0 {
+		n = 10 // Default to 10 emails
+	}
+
+	if beforeUID == 0 {
+		cached, _, err := p.syncMailbox(mailbox, n)
+		if err != nil {
+			return nil, err
+		}
+		return cached.newest(0, n), nil
+	}
+	return p.cachedPage(mailbox, beforeUID, n)
+}
+
+// CachedEmails returns the newest n emails of a mailbox from the cache
+// without contacting the server
+func (p *GenericIMAPProvider) CachedEmails(mailbox string, n int) ([]*IncomingMessage, error) {
+	cached, err := p.cache.load(mailbox)
+	if err != nil {
+		return nil, err
+	}
+	return cached.newest(0, n), nil
+}
+
+// SearchOffline searches the cached emails of a mailbox ("" for every
+// mailbox) without contacting the server, returning up to n of the newest
+// matches. Only emails that were synced are found, and only the bodies of
+// the ones that were opened.
+func (p *GenericIMAPProvider) SearchOffline(mailbox string, query *SearchQuery, n int) ([]*IncomingMessage, error) {
+	return p.cache.searchMessages(mailbox, query, n), nil
+}
+
+// selectMailbox opens a mailbox for reading and changing flags
+func (p *GenericIMAPProvider) selectMailbox(name string) (*imap.MailboxStatus, error) {
+	status, err := p.client.Select(name, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select %s: %v", name, err)
+	}
+	return status, nil
+}
+
+// pageUIDs finds the UIDs of up to limit messages below beforeUID (0 for no
+// bound) in the selected mailbox, oldest first
+func (p *GenericIMAPProvider) pageUIDs(status *imap.MailboxStatus, beforeUID uint32, limit int) ([]uint32, error) {
+	if status.Messages == 0 || beforeUID == 1 {
+		return nil, nil
+	}
+
+	criteria := imap.NewSearchCriteria()
+	criteria.Uid = new(imap.SeqSet)
+	if beforeUID == 0 {
+		criteria.Uid.AddRange(1, 0) // 1:*
+	} else {
+		criteria.Uid.AddRange(1, beforeUID-1)
+	}
+	uids, err := p.client.UidSearch(criteria)
+	if err != nil {
+		return nil, fmt.Errorf("search failed: %v", err)
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	if beforeUID != 0 {
+		// UID n:* always matches the newest message, even above n
+		for len(uids) > 0 && uids[len(uids)-1] >= beforeUID {
+			uids = uids[:len(uids)-1]
+		}
+	}
+	if len(uids) > limit {
+		uids = uids[len(uids)-limit:]
+	}
+	return uids, nil
+}
+
+// newUIDs finds the UIDs above afterUID in the selected mailbox, oldest first
+func (p *GenericIMAPProvider) newUIDs(afterUID uint32) ([]uint32, error) {
+	criteria := imap.NewSearchCriteria()
+	criteria.Uid = new(imap.SeqSet)
+	criteria.Uid.AddRange(afterUID+1, 0)
+	uids, err := p.client.UidSearch(criteria)
+	if err != nil {
+		return nil, fmt.Errorf("search failed: %v", err)
+	}
+
+	// UID n:* always matches the newest message, even below n
+	var fresh []uint32
+	for _, uid := range uids {
+		if uid > afterUID {
+			fresh = append(fresh, uid)
+		}
+	}
+	sort.Slice(fresh, func(i, j int) bool { return fresh[i] < fresh[j] })
+	return fresh, nil
+}
+
+// fetchSummaries fetches what the list shows of the given messages in the
+// selected mailbox, newest first. Bodies are fetched by FetchMessage when an
+// email is opened.
+//
+// On CONDSTORE servers it also returns the highest MODSEQ of the messages.
+func (p *GenericIMAPProvider) fetchSummaries(mailbox string, uidValidity uint32, uids []uint32) ([]*IncomingMessage, uint64, error) {
+	if len(uids) == 0 {
+		return nil, 0, nil
+	}
+
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(uids...)
+
+	items := []imap.FetchItem{
+		imap.FetchUid,
+		imap.FetchEnvelope,
+		imap.FetchBodyStructure,
+		imap.FetchFlags,
+		imap.FetchRFC822Size,
+		referencesSection.FetchItem(),
+	}
+	if p.condstore {
+		items = append(items, fetchModSeq)
+	}
+	if p.threadIDs {
+		items = append(items, fetchGmailThreadID)
+	}
+
+	messages := make(chan *imap.Message, len(uids))
+
+	// Start the fetch operation in a goroutine
+	done := make(chan error, 1)
+	go func() {
+		done <- p.client.UidFetch(seqSet, items, messages)
+	}()
+
+	var emails []*IncomingMessage
+	var highest uint64
+	for msg := range messages {
+		if modSeq := messageModSeq(msg); modSeq > highest {
+			highest = modSeq
+		}
+		references := takeReferences(msg)
+		email := &IncomingMessage{}
+		if err := email.Parse(msg); err != nil {
+			// Skip emails that fail to parse
+			continue
+		}
+		email.Mailbox = mailbox
+		email.UIDValidity = uidValidity
+		email.UID = msg.Uid
+		email.References = references
+		email.ThreadID = gmailThreadID(msg)
+		emails = append(emails, email)
+	}
+
+	if err := <-done; err != nil {
+		return nil, 0, fmt.Errorf("fetch failed: %v", err)
+	}
+
+	// Newest first
+	sort.Slice(emails, func(i, j int) bool { return emails[i].UID > emails[j].UID })
+	return emails, highest, nil
+}
+
+// takeReferences removes the References header from the fetched sections
+// of a list entry, so it still parses as an envelope, and returns its IDs
+func takeReferences(msg *imap.Message) []string {
+	var references []string
+	for section, literal := range msg.Body {
+		if !section.BodyPartName.Equal(&referencesSection.BodyPartName) {
+			continue
+		}
+		delete(msg.Body, section)
+		if literal == nil {
+			continue
+		}
+		header, err := textproto.ReadHeader(bufio.NewReader(literal))
+		if err != nil {
+			continue
+		}
+		mailHeader := mail.Header{Header: message.Header{Header: header}}
+		references, _ = mailHeader.MsgIDList("References")
+	}
+	return references
+}
+
+// cachedPage returns the page of emails below beforeUID, downloading only
+// the ones missing from the cache. The caller must hold the lock.
+func (p *GenericIMAPProvider) cachedPage(mailbox string, beforeUID uint32, n int) ([]*IncomingMessage, error) {
+	cached, err := p.cache.load(mailbox)
+	if err != nil {
+		return nil, err
+	}
+	if page := cached.newest(beforeUID, n); len(page) == n {
+		return page, nil
+	}
+
+	if err := p.ensureConnected(); err != nil {
+		return nil, err
+	}
+	status, err := p.selectMailbox(mailbox)
+	if err != nil {
+		return nil, err
+	}
+	if cached.UIDValidity != status.UidValidity {
+		return nil, fmt.Errorf("%s has changed on the server, please reload it", mailbox)
+	}
+
+	uids, err := p.pageUIDs(status, beforeUID, n)
+	if err != nil {
+		return nil, err
+	}
+	var missing []uint32
+	for _, uid := range uids {
+		if !cached.contains(uid) {
+			missing = append(missing, uid)
+		}
+	}
+
+	// These are current, so the MODSEQ mark of the mailbox still holds
+	emails, _, err := p.fetchSummaries(mailbox, status.UidValidity, missing)
+	if err != nil {
+		return nil, err
+	}
+	cached.merge(emails)
+	if err := p.cache.save(mailbox, cached); err != nil {
+		return nil, err
+	}
+	return cached.newest(beforeUID, n), nil
+}
+
+// FetchMessage downloads the full message behind an email from the list
+// and returns it parsed. BODY.PEEK is used so that reading the message does
+// not mark it as seen on its own. Cached messages are read from disk.
+func (p *GenericIMAPProvider) FetchMessage(email *IncomingMessage) (*IncomingMessage, error) {
+	if email.UID == 0 {
+		return nil, fmt.Errorf("email does not belong to a mailbox")
+	}
+
+	full := &IncomingMessage{
+		Mailbox:     email.Mailbox,
+		UIDValidity: email.UIDValidity,
+		UID:         email.UID,
+		Flags:       email.Flags,
+		ThreadID:    email.ThreadID,
+	}
+
+	if raw, err := p.cache.loadBody(email); err == nil {
+		if err := full.parseRaw(raw); err == nil {
+			p.cache.indexBody(full)
+			return full, nil
+		}
+	}
+
+	raw, err := p.fetchRaw(email)
+	if err != nil {
+		return nil, err
+	}
+	if err := full.parseRaw(raw); err != nil {
+		return nil, err
+	}
+
+	// Failing to cache only means downloading it again next time
+	p.cache.saveBody(email, raw)
+	p.cache.indexBody(full)
+	return full, nil
+}
+
+// FetchRawMessage returns the complete RFC 5322 source of an email, from
+// the cache when it was downloaded before
+func (p *GenericIMAPProvider) FetchRawMessage(email *IncomingMessage) ([]byte, error) {
+	if email.UID == 0 {
+		return nil, fmt.Errorf("email does not belong to a mailbox")
+	}
+	if raw, err := p.cache.loadBody(email); err == nil {
+		return raw, nil
+	}
+
+	raw, err := p.fetchRaw(email)
+	if err != nil {
+		return nil, err
+	}
+	p.cache.saveBody(email, raw)
+	return raw, nil
+}
+
+// FetchPart downloads the content of one part of an email, such as an
+// attachment, without the rest of the message, and decodes it
+func (p *GenericIMAPProvider) FetchPart(email *IncomingMessage, part *MIMEPart) ([]byte, error) {
+	var content bytes.Buffer
+	if err := p.WritePart(email, part, &content); err != nil {
+		return nil, err
+	}
+	return content.Bytes(), nil
+}
+
+// WritePart downloads one part of an email and writes it to w, decoding it
+// on the way, so large attachments can go straight to a file
+func (p *GenericIMAPProvider) WritePart(email *IncomingMessage, part *MIMEPart, w io.Writer) error {
+	if email.UID == 0 {
+		return fmt.Errorf("email does not belong to a mailbox")
+	}
+	section, err := imap.ParseBodySectionName(imap.FetchItem("BODY.PEEK[" + part.Section() + "]"))
+	if err != nil {
+		return fmt.Errorf("invalid part section %s: %v", part.Section(), err)
+	}
+	return p.readSection(email, section, func(body io.Reader) error {
+		if _, err := io.Copy(w, transferDecoder(body, part.Encoding)); err != nil {
+			return fmt.Errorf("failed to decode %s: %v", part.Filename, err)
+		}
+		return nil
+	})
+}
+
+// transferDecoder decodes content sent with a Content-Transfer-Encoding
+func transferDecoder(content io.Reader, encoding string) io.Reader {
+	switch strings.ToLower(encoding) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, content)
+	case "quoted-printable":
+		return quotedprintable.NewReader(content)
+	default:
+		return content
+	}
+}
+
+// fetchRaw downloads the complete RFC 5322 message behind an email
+func (p *GenericIMAPProvider) fetchRaw(email *IncomingMessage) ([]byte, error) {
+	return p.fetchSection(email, &imap.BodySectionName{Peek: true})
+}
+
+// fetchSection downloads one body section of an email
+func (p *GenericIMAPProvider) fetchSection(email *IncomingMessage, section *imap.BodySectionName) ([]byte, error) {
+	var raw []byte
+	err := p.readSection(email, section, func(body io.Reader) error {
+		var err error
+		if raw, err = io.ReadAll(body); err != nil {
+			return fmt.Errorf("failed to read message: %v", err)
+		}
+		return nil
+	})
+	return raw, err
+}
+
+// readSection downloads one body section of an email and hands it to read
+func (p *GenericIMAPProvider) readSection(email *IncomingMessage, section *imap.BodySectionName, read func(body io.Reader) error) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.ensureConnected(); err != nil {
+		return err
+	}
+
+	mailbox, err := p.selectMailbox(email.Mailbox)
+	if err != nil {
+		return err
+	}
+	if mailbox.UidValidity != email.UIDValidity {
+		return fmt.Errorf("%s has changed on the server, please reload it", email.Mailbox)
+	}
+
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(email.UID)
+	items := []imap.FetchItem{imap.FetchUid, section.FetchItem()}
+
+	messages := make(chan *imap.Message, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- p.client.UidFetch(seqSet, items, messages)
+	}()
+
+	var found bool
+	for msg := range messages {
+		if body := findBodyReader(msg); body != nil && !found {
+			err = read(body)
+			found = true
+		}
+	}
+	if err := <-done; err != nil {
+		return fmt.Errorf("fetch failed: %v", err)
+	}
+	if !found {
+		return fmt.Errorf("message no longer exists on the server")
+	}
+	return err
+}
+
+// SendEmail sends an email message
+func (p *GenericIMAPProvider) SendEmail(message *OutgoingMessage) error {
+	// Validate email message
+	if err := validateEmailMessage(message); err != nil {
+		return err
+	}
+
+	// Add attachments
+	for _, path := range message.AttachmentPaths {
+		if _, err := message.PrepAttachment(path); err != nil {
+			return err
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	defer p.disconnect()
+	return message.deliver(&p.config, p.getSMTPAuth())
+}
+
+// QuickSend provides a simple way to send a text email
+func (p *GenericIMAPProvider) QuickSend(to, subject, body string) error {
+	message, err := NewOutgoingMessage()
+	if err != nil {
+		return err
+	}
+
+	message.AddRecipient(to)
+	message.Subject = subject
+	message.SetTextBody(body)
+
+	p.Disconnect()
+	return p.SendEmail(message)
+}
+
+// GetUserInfo returns the user information
+func (p *GenericIMAPProvider) GetUserInfo() (auth.Credentials, error) {
+	return p.userInfo, nil
+}
+
+// validateEmailMessage verifies that an email message is valid
+func validateEmailMessage(message *OutgoingMessage) error {
+	if message == nil {
+		return fmt.Errorf("email message is nil")
+	}
+
+	if len(message.To) == 0 && len(message.Cc) == 0 && len(message.Bcc) == 0 {
+		return fmt.Errorf("email must have at least one recipient")
+	}
+
+	return nil
+}
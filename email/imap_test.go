@@ -0,0 +1,899 @@
+package email
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/backend/memory"
+	imapClient "github.com/emersion/go-imap/client"
+	imapServer "github.com/emersion/go-imap/server"
+	"github.com/emersion/go-sasl"
+	"github.com/emersion/go-smtp"
+	"github.com/jacobbanks/tmail/auth"
+	"golang.org/x/oauth2"
+)
+
+const (
+	testEmail       = "tester@example.com"
+	testPassword    = "test-app-password"
+	testAccessToken = "test-access-token"
+)
+
+// testIMAPBackend wraps the go-imap memory backend so it accepts our test credentials
+type testIMAPBackend struct {
+	mem *memory.Backend
+}
+
+func (b *testIMAPBackend) Login(conn *imap.ConnInfo, username, password string) (backend.User, error) {
+	if username != testEmail || password != testPassword {
+		return nil, errors.New("Bad username or password")
+	}
+	// The memory backend ships with a single hard-coded user
+	user, err := b.mem.Login(conn, "username", "password")
+	if err != nil {
+		return nil, err
+	}
+	return &testIMAPUser{User: user}, nil
+}
+
+// testIMAPUser hands out testIMAPMailboxes instead of plain memory mailboxes
+type testIMAPUser struct {
+	backend.User
+}
+
+func (u *testIMAPUser) GetMailbox(name string) (backend.Mailbox, error) {
+	mbox, err := u.User.GetMailbox(name)
+	if err != nil {
+		return nil, err
+	}
+	return &testIMAPMailbox{Mailbox: mbox.(*memory.Mailbox)}, nil
+}
+
+// testIMAPMailbox fills in the unseen count the memory backend leaves at zero
+type testIMAPMailbox struct {
+	*memory.Mailbox
+}
+
+func (m *testIMAPMailbox) Status(items []imap.StatusItem) (*imap.MailboxStatus, error) {
+	status, err := m.Mailbox.Status(items)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := status.Items[imap.StatusUnseen]; ok {
+		status.Unseen = 0
+		for _, msg := range m.Messages {
+			seen := false
+			for _, flag := range msg.Flags {
+				seen = seen || flag == imap.SeenFlag
+			}
+			if !seen {
+				status.Unseen++
+			}
+		}
+	}
+	return status, nil
+}
+
+// MoveMessages implements MOVE, which the server advertises but the memory
+// backend lacks
+func (m *testIMAPMailbox) MoveMessages(uid bool, seqSet *imap.SeqSet, dest string) error {
+	if err := m.CopyMessages(uid, seqSet, dest); err != nil {
+		return err
+	}
+	if err := m.UpdateMessagesFlags(uid, seqSet, imap.AddFlags, []string{imap.DeletedFlag}); err != nil {
+		return err
+	}
+	return m.Expunge()
+}
+
+// loginToken authenticates an IMAP connection that presented an OAuth2 bearer token
+func (b *testIMAPBackend) loginToken(conn imapServer.Conn, username, token string) error {
+	if username != testEmail || token != testAccessToken {
+		return errors.New("invalid token")
+	}
+	user, err := b.mem.Login(conn.Info(), "username", "password")
+	if err != nil {
+		return err
+	}
+	ctx := conn.Context()
+	ctx.State = imap.AuthenticatedState
+	ctx.User = &testIMAPUser{User: user}
+	return nil
+}
+
+// testXOAuth2Server is the server side of XOAUTH2, which go-sasl lacks
+type testXOAuth2Server struct {
+	authenticate func(username, token string) error
+}
+
+func (s *testXOAuth2Server) Next(response []byte) ([]byte, bool, error) {
+	var username, token string
+	for _, field := range strings.Split(string(response), "\x01") {
+		switch {
+		case strings.HasPrefix(field, "user="):
+			username = strings.TrimPrefix(field, "user=")
+		case strings.HasPrefix(field, "auth=Bearer "):
+			token = strings.TrimPrefix(field, "auth=Bearer ")
+		}
+	}
+	return nil, true, s.authenticate(username, token)
+}
+
+// newOAuthServer returns the server side of an OAuth2 SASL mechanism
+func newOAuthServer(mechanism string, authenticate func(username, token string) error) sasl.Server {
+	if mechanism == auth.XOAuth2 {
+		return &testXOAuth2Server{authenticate: authenticate}
+	}
+	return sasl.NewOAuthBearerServer(func(opts sasl.OAuthBearerOptions) *sasl.OAuthBearerError {
+		if err := authenticate(opts.Username, opts.Token); err != nil {
+			return &sasl.OAuthBearerError{Status: "invalid_token"}
+		}
+		return nil
+	})
+}
+
+// testSMTPBackend records every message delivered to it
+type testSMTPBackend struct {
+	mu       sync.Mutex
+	messages []testSMTPMessage
+}
+
+type testSMTPMessage struct {
+	From string
+	To   []string
+	Data []byte
+}
+
+func (b *testSMTPBackend) Login(_ *smtp.ConnectionState, username, password string) (smtp.Session, error) {
+	if username != testEmail || password != testPassword {
+		return nil, errors.New("invalid credentials")
+	}
+	return &testSMTPSession{backend: b}, nil
+}
+
+func (b *testSMTPBackend) AnonymousLogin(_ *smtp.ConnectionState) (smtp.Session, error) {
+	return nil, smtp.ErrAuthRequired
+}
+
+func (b *testSMTPBackend) received() []testSMTPMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]testSMTPMessage(nil), b.messages...)
+}
+
+type testSMTPSession struct {
+	backend *testSMTPBackend
+	msg     testSMTPMessage
+}
+
+func (s *testSMTPSession) Reset()        { s.msg = testSMTPMessage{} }
+func (s *testSMTPSession) Logout() error { return nil }
+
+func (s *testSMTPSession) Mail(from string, _ smtp.MailOptions) error {
+	s.msg.From = from
+	return nil
+}
+
+func (s *testSMTPSession) Rcpt(to string) error {
+	s.msg.To = append(s.msg.To, to)
+	return nil
+}
+
+func (s *testSMTPSession) Data(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.msg.Data = data
+	s.backend.mu.Lock()
+	s.backend.messages = append(s.backend.messages, s.msg)
+	s.backend.mu.Unlock()
+	return nil
+}
+
+// testServers is a local in-memory IMAP and SMTP server pair
+type testServers struct {
+	config Config
+	imap   *imapServer.Server
+	mem    *memory.Backend
+	smtp   *testSMTPBackend
+}
+
+// startTestServers starts plaintext IMAP and SMTP servers on loopback ports
+func startTestServers(t *testing.T) *testServers {
+	t.Helper()
+
+	mem := memory.New()
+	imapSrv := imapServer.New(&testIMAPBackend{mem: mem})
+	imapSrv.AllowInsecureAuth = true
+	imapBackend := imapSrv.Backend.(*testIMAPBackend)
+	for _, mechanism := range []string{auth.XOAuth2, sasl.OAuthBearer} {
+		mechanism := mechanism
+		imapSrv.EnableAuth(mechanism, func(conn imapServer.Conn) sasl.Server {
+			return newOAuthServer(mechanism, func(username, token string) error {
+				return imapBackend.loginToken(conn, username, token)
+			})
+		})
+	}
+	imapListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen for IMAP: %v", err)
+	}
+	go imapSrv.Serve(imapListener)
+
+	smtpBackend := &testSMTPBackend{}
+	smtpSrv := smtp.NewServer(smtpBackend)
+	smtpSrv.Domain = "localhost"
+	smtpSrv.AllowInsecureAuth = true
+	smtpSrv.EnableAuth(sasl.Login, func(conn *smtp.Conn) sasl.Server {
+		return sasl.NewLoginServer(func(username, password string) error {
+			session, err := smtpBackend.Login(nil, username, password)
+			if err != nil {
+				return err
+			}
+			conn.SetSession(session)
+			return nil
+		})
+	})
+	for _, mechanism := range []string{auth.XOAuth2, sasl.OAuthBearer} {
+		mechanism := mechanism
+		smtpSrv.EnableAuth(mechanism, func(conn *smtp.Conn) sasl.Server {
+			return newOAuthServer(mechanism, func(username, token string) error {
+				if username != testEmail || token != testAccessToken {
+					return errors.New("invalid token")
+				}
+				conn.SetSession(&testSMTPSession{backend: smtpBackend})
+				return nil
+			})
+		})
+	}
+	smtpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen for SMTP: %v", err)
+	}
+	go smtpSrv.Serve(smtpListener)
+
+	_, imapPort, _ := net.SplitHostPort(imapListener.Addr().String())
+	_, smtpPort, _ := net.SplitHostPort(smtpListener.Addr().String())
+
+	servers := &testServers{
+		config: Config{
+			IMAPHost: "127.0.0.1",
+			IMAPPort: imapPort,
+			IMAPTLS:  TLSModeNone,
+			SMTPHost: "127.0.0.1",
+			SMTPPort: smtpPort,
+			SMTPTLS:  TLSModeNone,
+		},
+		imap: imapSrv,
+		mem:  mem,
+		smtp: smtpBackend,
+	}
+	// go-smtp's Close races with a Serve that has not started yet, closing
+	// the listener stops it either way
+	t.Cleanup(func() {
+		imapSrv.Close()
+		smtpListener.Close()
+	})
+	return servers
+}
+
+// addMessage appends a raw RFC 822 message to a mailbox of the test IMAP server
+func (s *testServers) addMessage(t *testing.T, mailbox, raw string) {
+	t.Helper()
+	user, err := s.mem.Login(nil, "username", "password")
+	if err != nil {
+		t.Fatalf("Failed to log into memory backend: %v", err)
+	}
+	mbox, err := user.GetMailbox(mailbox)
+	if err != nil {
+		t.Fatalf("Failed to open mailbox %s: %v", mailbox, err)
+	}
+	if err := mbox.CreateMessage(nil, time.Now(), bytes.NewBufferString(raw)); err != nil {
+		t.Fatalf("Failed to add message: %v", err)
+	}
+}
+
+// memoryMailbox returns a mailbox of the test IMAP server to change behind
+// the client's back
+func (s *testServers) memoryMailbox(t *testing.T, name string) *memory.Mailbox {
+	t.Helper()
+	user, err := s.mem.Login(nil, "username", "password")
+	if err != nil {
+		t.Fatalf("Failed to log into memory backend: %v", err)
+	}
+	mbox, err := user.GetMailbox(name)
+	if err != nil {
+		t.Fatalf("Failed to open mailbox %s: %v", name, err)
+	}
+	return mbox.(*memory.Mailbox)
+}
+
+// createMailbox adds a mailbox to the test IMAP server
+func (s *testServers) createMailbox(t *testing.T, name string) {
+	t.Helper()
+	user, err := s.mem.Login(nil, "username", "password")
+	if err != nil {
+		t.Fatalf("Failed to log into memory backend: %v", err)
+	}
+	if err := user.CreateMailbox(name); err != nil {
+		t.Fatalf("Failed to create mailbox %s: %v", name, err)
+	}
+}
+
+func testCredentials() auth.Credentials {
+	return auth.Credentials{
+		Email:       testEmail,
+		AppPassword: testPassword,
+		Name:        "Tester",
+	}
+}
+
+func testOAuth2Credentials() auth.Credentials {
+	return auth.Credentials{
+		Email:      testEmail,
+		Name:       "Tester",
+		AuthMethod: auth.AuthMethodOAuth2,
+		OAuth2: &auth.OAuth2Credentials{
+			ClientID: "client",
+			Token: &oauth2.Token{
+				AccessToken: testAccessToken,
+				Expiry:      time.Now().Add(time.Hour),
+			},
+		},
+	}
+}
+
+// providerFactories lists every MailProvider that speaks IMAP/SMTP so the same
+// behavior tests run against each of them.
+var providerFactories = map[string]func(Config, auth.Credentials) (MailProvider, error){
+	"gmail": func(c Config, u auth.Credentials) (MailProvider, error) {
+		return NewGmailProvider(c, u)
+	},
+	"generic": func(c Config, u auth.Credentials) (MailProvider, error) {
+		return NewGenericIMAPProvider(c, u)
+	},
+}
+
+func TestIMAPProvidersAgainstLocalServer(t *testing.T) {
+	for name, factory := range providerFactories {
+		t.Run(name, func(t *testing.T) {
+			servers := startTestServers(t)
+			servers.addMessage(t, "INBOX", "From: Alice <alice@example.com>\r\n"+
+				"To: tester@example.com\r\n"+
+				"Subject: Hello from the test server\r\n"+
+				"Date: Thu, 12 Jun 2025 10:00:00 +0000\r\n"+
+				"Content-Type: text/plain\r\n"+
+				"\r\n"+
+				"Body text\r\n")
+
+			provider, err := factory(servers.config, testCredentials())
+			if err != nil {
+				t.Fatalf("Failed to create provider: %v", err)
+			}
+
+			if err := provider.Connect(); err != nil {
+				t.Fatalf("Connect returned error: %v", err)
+			}
+
+			emails, err := provider.GetEmails(10)
+			if err != nil {
+				t.Fatalf("GetEmails returned error: %v", err)
+			}
+			if len(emails) != 2 {
+				t.Fatalf("Expected 2 emails, got %d", len(emails))
+			}
+			// Newest message comes first
+			if emails[0].Subject != "Hello from the test server" {
+				t.Errorf("Expected newest subject first, got %q", emails[0].Subject)
+			}
+			if emails[0].BodyLoaded {
+				t.Errorf("Expected the list to be fetched without bodies")
+			}
+
+			full, err := provider.FetchMessage(emails[0])
+			if err != nil {
+				t.Fatalf("FetchMessage returned error: %v", err)
+			}
+			if !full.BodyLoaded || !strings.Contains(full.Body, "Body text") {
+				t.Errorf("Expected body to be parsed, got %q", full.Body)
+			}
+			if full.ID() != emails[0].ID() {
+				t.Errorf("Expected FetchMessage to keep the identity %s, got %s", emails[0].ID(), full.ID())
+			}
+
+			message := &OutgoingMessage{
+				From:    testEmail,
+				To:      []string{"bob@example.com"},
+				Cc:      []string{"carol@example.com"},
+				Subject: "Test send",
+				Text:    []byte("Sent through the local server"),
+			}
+			if err := provider.SendEmail(message); err != nil {
+				t.Fatalf("SendEmail returned error: %v", err)
+			}
+
+			received := servers.smtp.received()
+			if len(received) != 1 {
+				t.Fatalf("Expected 1 delivered message, got %d", len(received))
+			}
+			if received[0].From != testEmail {
+				t.Errorf("Expected sender %s, got %s", testEmail, received[0].From)
+			}
+			if len(received[0].To) != 2 {
+				t.Errorf("Expected 2 recipients, got %v", received[0].To)
+			}
+			if !bytes.Contains(received[0].Data, []byte("Subject: Test send")) {
+				t.Errorf("Delivered message is missing its subject")
+			}
+		})
+	}
+}
+
+func TestIMAPProviderAuthMechanisms(t *testing.T) {
+	for _, mechanism := range []string{"", AuthMechanismPlain, AuthMechanismLogin} {
+		t.Run("mechanism="+mechanism, func(t *testing.T) {
+			servers := startTestServers(t)
+			config := servers.config
+			config.AuthMechanism = mechanism
+
+			provider, err := NewGenericIMAPProvider(config, testCredentials())
+			if err != nil {
+				t.Fatalf("Failed to create provider: %v", err)
+			}
+			if err := provider.Connect(); err != nil {
+				t.Fatalf("Connect returned error: %v", err)
+			}
+			defer provider.Disconnect()
+
+			err = provider.SendEmail(&OutgoingMessage{
+				From: testEmail,
+				To:   []string{"bob@example.com"},
+				Text: []byte("hi"),
+			})
+			if err != nil {
+				t.Fatalf("SendEmail returned error: %v", err)
+			}
+		})
+	}
+}
+
+func TestIMAPProviderSecretCommands(t *testing.T) {
+	tests := map[string]auth.Credentials{
+		"password_command":    {Email: testEmail, Name: "Tester", PasswordCommand: "echo " + testPassword},
+		"oauth_token_command": {Email: testEmail, Name: "Tester", OAuthTokenCommand: "echo " + testAccessToken},
+	}
+
+	for name, creds := range tests {
+		t.Run(name, func(t *testing.T) {
+			servers := startTestServers(t)
+
+			provider, err := NewGenericIMAPProvider(servers.config, creds)
+			if err != nil {
+				t.Fatalf("Failed to create provider: %v", err)
+			}
+			if err := provider.Connect(); err != nil {
+				t.Fatalf("Connect returned error: %v", err)
+			}
+			defer provider.Disconnect()
+
+			err = provider.SendEmail(&OutgoingMessage{
+				From: testEmail,
+				To:   []string{"bob@example.com"},
+				Text: []byte("hi"),
+			})
+			if err != nil {
+				t.Fatalf("SendEmail returned error: %v", err)
+			}
+		})
+	}
+}
+
+func TestIMAPProviderOAuth2(t *testing.T) {
+	for _, mechanism := range []string{"", AuthMechanismXOAuth2, AuthMechanismOAuthBearer} {
+		t.Run("mechanism="+mechanism, func(t *testing.T) {
+			servers := startTestServers(t)
+			config := servers.config
+			config.AuthMechanism = mechanism
+
+			provider, err := NewGenericIMAPProvider(config, testOAuth2Credentials())
+			if err != nil {
+				t.Fatalf("Failed to create provider: %v", err)
+			}
+			if err := provider.Connect(); err != nil {
+				t.Fatalf("Connect returned error: %v", err)
+			}
+			defer provider.Disconnect()
+
+			err = provider.SendEmail(&OutgoingMessage{
+				From: testEmail,
+				To:   []string{"bob@example.com"},
+				Text: []byte("hi"),
+			})
+			if err != nil {
+				t.Fatalf("SendEmail returned error: %v", err)
+			}
+			if got := len(servers.smtp.received()); got != 1 {
+				t.Errorf("Expected 1 delivered message, got %d", got)
+			}
+		})
+	}
+
+	servers := startTestServers(t)
+	creds := testOAuth2Credentials()
+	creds.OAuth2.Token.AccessToken = "revoked"
+	provider, err := NewGenericIMAPProvider(servers.config, creds)
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	if err := provider.Connect(); err == nil {
+		t.Error("Connect should fail with an invalid token")
+	}
+}
+
+func TestIMAPProviderBadCredentials(t *testing.T) {
+	servers := startTestServers(t)
+	creds := testCredentials()
+	creds.AppPassword = "wrong"
+
+	provider, err := NewGenericIMAPProvider(servers.config, creds)
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	if err := provider.Connect(); err == nil {
+		t.Error("Connect should fail with the wrong password")
+	}
+}
+
+func TestIMAPProviderRefusesCleartextLogin(t *testing.T) {
+	servers := startTestServers(t)
+	client, err := imapClient.Dial(net.JoinHostPort(servers.config.IMAPHost, servers.config.IMAPPort))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer client.Logout()
+
+	// The same unencrypted connection, as if it went to a remote server
+	config := servers.config
+	config.IMAPHost = "mail.example.com"
+	provider, err := NewGenericIMAPProvider(config, testCredentials())
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	if err := provider.loginIMAP(client); err == nil || !strings.Contains(err.Error(), "unencrypted") {
+		t.Errorf("Expected login without TLS to be refused, got %v", err)
+	}
+	if client.State() != imap.NotAuthenticatedState {
+		t.Error("Credentials were sent over the unencrypted connection")
+	}
+}
+
+func TestGenericIMAPProviderInvalidConfig(t *testing.T) {
+	if _, err := NewGenericIMAPProvider(Config{}, testCredentials()); err == nil {
+		t.Error("NewGenericIMAPProvider should reject empty server settings")
+	}
+
+	config := DefaultConfig
+	config.IMAPTLS = "sometimes"
+	if _, err := NewGenericIMAPProvider(config, testCredentials()); err == nil {
+		t.Error("NewGenericIMAPProvider should reject unknown TLS modes")
+	}
+}
+
+func TestNewMailProviderSelection(t *testing.T) {
+	p, err := NewMailProvider(AccountConfig{Provider: ProviderGmail}, testCredentials())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := p.(*GmailProvider); !ok {
+		t.Errorf("Expected *GmailProvider, got %T", p)
+	}
+
+	server := Config{IMAPHost: "imap.fastmail.com", IMAPPort: "993", SMTPHost: "smtp.fastmail.com", SMTPPort: "465"}
+	p, err = NewMailProvider(AccountConfig{Provider: ProviderIMAP, Server: &server}, testCredentials())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := p.(*GenericIMAPProvider); !ok {
+		t.Errorf("Expected *GenericIMAPProvider, got %T", p)
+	}
+
+	if _, err := NewMailProvider(AccountConfig{Provider: ProviderIMAP}, testCredentials()); err == nil {
+		t.Error("Expected error for imap provider without server settings")
+	}
+	if _, err := NewMailProvider(AccountConfig{Provider: "pigeon"}, testCredentials()); err == nil {
+		t.Error("Expected error for unknown provider")
+	}
+}
+
+func TestConfigTLSModeDefaults(t *testing.T) {
+	if mode := DefaultConfig.GetIMAPTLSMode(); mode != TLSModeTLS {
+		t.Errorf("Expected Gmail IMAP to use implicit TLS, got %s", mode)
+	}
+	if mode := DefaultConfig.GetSMTPTLSMode(); mode != TLSModeStartTLS {
+		t.Errorf("Expected Gmail SMTP to use STARTTLS, got %s", mode)
+	}
+	config := Config{SMTPPort: "465", SMTPTLS: ""}
+	if mode := config.GetSMTPTLSMode(); mode != TLSModeTLS {
+		t.Errorf("Expected port 465 to use implicit TLS, got %s", mode)
+	}
+}
+
+func TestIMAPProviderPaging(t *testing.T) {
+	servers := startTestServers(t)
+	for i := 1; i <= 12; i++ {
+		servers.addMessage(t, "INBOX", fmt.Sprintf("From: alice@example.com\r\nSubject: Message %d\r\n\r\nBody %d\r\n", i, i))
+	}
+
+	provider, err := NewGenericIMAPProvider(servers.config, testCredentials())
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Disconnect()
+
+	var all []*IncomingMessage
+	var before uint32
+	for page := 0; page < 5; page++ {
+		emails, err := provider.GetEmailsPage(InboxName, before, 5)
+		if err != nil {
+			t.Fatalf("GetEmailsPage returned error: %v", err)
+		}
+		if len(emails) == 0 {
+			break
+		}
+		all = append(all, emails...)
+		before = emails[len(emails)-1].UID
+	}
+
+	// The memory backend starts with one message of its own
+	if len(all) != 13 {
+		t.Fatalf("Expected 13 messages across pages, got %d", len(all))
+	}
+	if all[0].Subject != "Message 12" {
+		t.Errorf("Expected the newest message first, got %q", all[0].Subject)
+	}
+	seen := map[string]bool{}
+	for i, email := range all {
+		if i > 0 && email.UID >= all[i-1].UID {
+			t.Errorf("UIDs are not strictly descending at %d: %d after %d", i, email.UID, all[i-1].UID)
+		}
+		if email.Mailbox != InboxName || email.UIDValidity == 0 || email.ID() == "" {
+			t.Errorf("Message %d is missing its identity: %+v", i, email)
+		}
+		if seen[email.ID()] {
+			t.Errorf("Message %s appears twice", email.ID())
+		}
+		seen[email.ID()] = true
+	}
+}
+
+func TestIMAPProviderListShowsAttachments(t *testing.T) {
+	servers := startTestServers(t)
+	servers.addMessage(t, "INBOX", "From: alice@example.com\r\n"+
+		"Subject: Report\r\n"+
+		"Content-Type: multipart/mixed; boundary=sep\r\n"+
+		"\r\n"+
+		"--sep\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"\r\n"+
+		"See attached\r\n"+
+		"--sep\r\n"+
+		"Content-Type: application/pdf\r\n"+
+		"Content-Disposition: attachment; filename=report.pdf\r\n"+
+		"\r\n"+
+		"%PDF\r\n"+
+		"--sep--\r\n")
+
+	provider, err := NewGenericIMAPProvider(servers.config, testCredentials())
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Disconnect()
+
+	emails, err := provider.GetEmailsPage(InboxName, 0, 1)
+	if err != nil {
+		t.Fatalf("GetEmailsPage returned error: %v", err)
+	}
+	if len(emails) != 1 {
+		t.Fatalf("Expected 1 email, got %d", len(emails))
+	}
+	if emails[0].Size == 0 {
+		t.Errorf("Expected the message size to be fetched")
+	}
+	if len(emails[0].Attachments) != 1 || emails[0].Attachments[0] != "report.pdf" {
+		t.Errorf("Expected report.pdf from the body structure, got %v", emails[0].Attachments)
+	}
+
+	full, err := provider.FetchMessage(emails[0])
+	if err != nil {
+		t.Fatalf("FetchMessage returned error: %v", err)
+	}
+	if !strings.Contains(full.Body, "See attached") {
+		t.Errorf("Expected the text part, got %q", full.Body)
+	}
+}
+
+func TestIMAPProviderCacheSync(t *testing.T) {
+	useTempCache(t)
+	servers := startTestServers(t)
+	for i := 1; i <= 3; i++ {
+		servers.addMessage(t, "INBOX", fmt.Sprintf("From: alice@example.com\r\nSubject: Message %d\r\n\r\nBody %d\r\n", i, i))
+	}
+
+	creds := testCredentials()
+	creds.Account = "work"
+	provider, err := NewGenericIMAPProvider(servers.config, creds)
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Disconnect()
+
+	if cached, _ := provider.CachedEmails(InboxName, 10); len(cached) != 0 {
+		t.Fatalf("Expected an empty cache, got %d emails", len(cached))
+	}
+
+	emails, err := provider.GetEmailsPage(InboxName, 0, 10)
+	if err != nil {
+		t.Fatalf("GetEmailsPage returned error: %v", err)
+	}
+	if len(emails) != 4 {
+		t.Fatalf("Expected 4 emails, got %d", len(emails))
+	}
+	cached, err := provider.CachedEmails(InboxName, 10)
+	if err != nil || len(cached) != 4 || cached[0].Subject != "Message 3" {
+		t.Fatalf("Expected the cache to hold the mailbox, got %d emails, %v", len(cached), err)
+	}
+
+	full, err := provider.FetchMessage(emails[0])
+	if err != nil || !strings.Contains(full.Body, "Body 3") {
+		t.Fatalf("FetchMessage returned %v", err)
+	}
+
+	// Change the mailbox on the server: read one, expunge one, deliver one
+	mbox := servers.memoryMailbox(t, InboxName)
+	mbox.Messages[1].Flags = append(mbox.Messages[1].Flags, imap.SeenFlag)
+	expunged := mbox.Messages[2].Uid
+	mbox.Messages = append(mbox.Messages[:2], mbox.Messages[3:]...)
+	servers.addMessage(t, "INBOX", "From: bob@example.com\r\nSubject: Message 4\r\n\r\nBody 4\r\n")
+
+	emails, err = provider.GetEmailsPage(InboxName, 0, 10)
+	if err != nil {
+		t.Fatalf("GetEmailsPage returned error: %v", err)
+	}
+	if len(emails) != 4 || emails[0].Subject != "Message 4" {
+		t.Fatalf("Expected the new message on top of 4 emails, got %d", len(emails))
+	}
+	for _, email := range emails {
+		if email.UID == expunged {
+			t.Errorf("Expunged message %d is still cached", expunged)
+		}
+		if email.UID == mbox.Messages[1].Uid {
+			seen := false
+			for _, flag := range email.Flags {
+				seen = seen || flag == imap.SeenFlag
+			}
+			if !seen {
+				t.Errorf("Expected the \\Seen flag to be synced, got %v", email.Flags)
+			}
+		}
+	}
+
+	// Opened messages are read from disk, even without a connection
+	servers.imap.Close()
+	provider.Disconnect()
+	again, err := provider.FetchMessage(full)
+	if err != nil || again.Body != full.Body {
+		t.Errorf("Expected the cached body, got %v", err)
+	}
+	if cached, _ := provider.CachedEmails(InboxName, 10); len(cached) != 4 {
+		t.Errorf("Expected 4 cached emails offline, got %d", len(cached))
+	}
+}
+
+func TestIMAPProviderFetchPart(t *testing.T) {
+	useTempCache(t)
+	servers := startTestServers(t)
+	servers.addMessage(t, "INBOX", "From: Alice <alice@example.com>\r\n"+
+		"To: me@example.com\r\n"+
+		"Cc: bob@example.com\r\n"+
+		"Reply-To: list@example.com\r\n"+
+		"Subject: Report\r\n"+
+		"Content-Type: multipart/mixed; boundary=frontier\r\n"+
+		"\r\n"+
+		"--frontier\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"\r\n"+
+		"See attached\r\n"+
+		"--frontier\r\n"+
+		"Content-Type: application/pdf\r\n"+
+		"Content-Disposition: attachment; filename=\"report.pdf\"\r\n"+
+		"Content-Transfer-Encoding: base64\r\n"+
+		"\r\n"+
+		"JVBERi0xLjQK\r\n"+
+		"--frontier--\r\n")
+
+	provider, err := NewGenericIMAPProvider(servers.config, testCredentials())
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Disconnect()
+
+	emails, err := provider.GetEmailsPage(InboxName, 0, 1)
+	if err != nil || len(emails) != 1 {
+		t.Fatalf("Expected the report, got %d emails and error %v", len(emails), err)
+	}
+	msg := emails[0]
+	if msg.Cc != "bob@example.com" || msg.ReplyTo != "list@example.com" {
+		t.Errorf("Expected Cc and Reply-To to be kept, got %q and %q", msg.Cc, msg.ReplyTo)
+	}
+	if len(msg.AttachmentParts()) != 1 {
+		t.Fatalf("Expected one attachment, got %+v", msg.AttachmentParts())
+	}
+	part := msg.AttachmentParts()[0]
+	if part.Filename != "report.pdf" || part.Section() != "2" {
+		t.Errorf("Expected report.pdf in section 2, got %q in %q", part.Filename, part.Section())
+	}
+
+	content, err := provider.FetchPart(msg, part)
+	if err != nil {
+		t.Fatalf("FetchPart returned error: %v", err)
+	}
+	if string(content) != "%PDF-1.4\n" {
+		t.Errorf("Expected the decoded attachment, got %q", content)
+	}
+
+	raw, err := provider.FetchRawMessage(msg)
+	if err != nil {
+		t.Fatalf("FetchRawMessage returned error: %v", err)
+	}
+	if !strings.HasPrefix(string(raw), "From: Alice <alice@example.com>\r\n") {
+		t.Errorf("Expected the whole email, got %q", raw)
+	}
+}
+
+func TestIMAPProviderMIMETree(t *testing.T) {
+	useTempCache(t)
+	servers := startTestServers(t)
+	servers.addMessage(t, "INBOX", nestedTestMessage)
+
+	provider, err := NewGenericIMAPProvider(servers.config, testCredentials())
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Disconnect()
+
+	emails, err := provider.GetEmailsPage(InboxName, 0, 1)
+	if err != nil || len(emails) != 1 {
+		t.Fatalf("Expected the forward, got %d emails and error %v", len(emails), err)
+	}
+	full, err := provider.FetchMessage(emails[0])
+	if err != nil {
+		t.Fatalf("FetchMessage returned error: %v", err)
+	}
+
+	// The tree from BODYSTRUCTURE matches the one parsed from the message
+	listed, parsed := describeParts(emails[0].Parts), describeParts(full.Parts)
+	if strings.Join(listed, "\n") != strings.Join(parsed, "\n") {
+		t.Errorf("Expected the same parts from the server and the message, got:\n%s\n\nand:\n%s", strings.Join(listed, "\n"), strings.Join(parsed, "\n"))
+	}
+
+	// The forwarded email can be downloaded on its own, ready to forward
+	// again or save
+	forwarded := full.AttachmentParts()[1]
+	content, err := provider.FetchPart(full, forwarded)
+	if err != nil {
+		t.Fatalf("FetchPart returned error: %v", err)
+	}
+	if !strings.HasPrefix(string(content), "From: Bob <bob@example.com>\r\n") || !strings.Contains(string(content), "iVBORw0KGgo=") {
+		t.Errorf("Expected the whole forwarded email, got %q", content)
+	}
+}
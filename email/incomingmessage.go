@@ -1,28 +1,72 @@
 package email
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 	"io"
 	"log"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/emersion/go-imap"
 	"github.com/emersion/go-message"
 	"github.com/emersion/go-message/mail"
+	"github.com/emersion/go-message/textproto"
 )
 
 // IncomingMessage represents an email message received from a mail server.
 // It contains the essential fields from the email such as sender, recipient,
 // subject, date, and the message body in plain text format.
 type IncomingMessage struct {
+	// Where the message lives on the server. Together these identify the
+	// message for as long as the mailbox's UIDVALIDITY doesn't change.
+	Mailbox     string
+	UIDValidity uint32
+	UID         uint32
+
 	From        string
 	To          string
+	Cc          string
+	ReplyTo     string // Where replies should go, when not to From
 	Subject     string
 	Date        time.Time
 	Body        string
+	HTMLBody    string   // The text/html part, kept for the reader to render
+	HTMLOnly    bool     // Body was rendered from HTMLBody for want of a plain text part
 	Attachments []string // Only attachment names, not content
+	Size        uint32   // Size of the whole message in bytes
+	Flags       []string // IMAP flags such as \Seen
+	BodyLoaded  bool     // False for list entries whose body has not been fetched yet
+
+	// The MIME structure of the message, from its BODYSTRUCTURE until the
+	// body is downloaded and parsed
+	Parts *MIMEPart
+
+	// Threading headers, as message IDs without their angle brackets
+	MessageID  string
+	InReplyTo  string
+	References []string
+	ThreadID   string // The server's conversation ID, e.g. Gmail's X-GM-THRID
+}
+
+// AttachmentParts returns the attachments of the email, for downloading
+// with FetchPart
+func (email *IncomingMessage) AttachmentParts() []*MIMEPart {
+	if email.Parts == nil {
+		return nil
+	}
+	return email.Parts.Attachments()
+}
+
+// ID returns a stable identity for the message, or "" when it did not come
+// from a mailbox
+func (email *IncomingMessage) ID() string {
+	if email.UID == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s/%d/%d", email.Mailbox, email.UIDValidity, email.UID)
 }
 
 // Parse converts an IMAP message into an IncomingMessage structure.
@@ -32,10 +76,17 @@ func (email *IncomingMessage) Parse(msg *imap.Message) error {
 		return fmt.Errorf("cannot parse a nil message")
 	}
 
+	email.Size = msg.Size
+	email.Flags = msg.Flags
+
 	if len(msg.Body) == 0 {
 		// If no body but we have envelope data, create a basic email
 		if msg.Envelope != nil {
 			createEmailFromEnvelope(email, msg.Envelope)
+			if msg.BodyStructure != nil {
+				email.Parts = mimeTreeFromStructure(msg.BodyStructure)
+				email.Attachments = attachmentNames(email.Parts)
+			}
 			return nil
 		}
 		return fmt.Errorf("message has no body parts")
@@ -46,31 +97,49 @@ func (email *IncomingMessage) Parse(msg *imap.Message) error {
 		return fmt.Errorf("no message body found")
 	}
 
-	// Create a message entity
-	entity, err := message.Read(reader)
+	bodyReader := bufio.NewReader(reader)
+	header, err := textproto.ReadHeader(bodyReader)
 	if err != nil {
 		return fmt.Errorf("failed to parse message: %v", err)
 	}
-
-	mr := mail.NewReader(entity)
-	err = createEmail(mr, email)
-	if err != nil {
+	if err := extractHeaders(mail.Header{Header: message.Header{Header: header}}, email); err != nil {
+		log.Printf("Error extracting email headers: %v", err)
 		return err
 	}
 
+	parts := &mimeReader{texts: mimeTexts{}}
+	email.Parts = parts.readMessage(header, bodyReader, nil, 0)
+	email.setBody(parts.texts)
+
+	email.BodyLoaded = true
 	return nil
 }
 
-func createEmail(reader *mail.Reader, email *IncomingMessage) error {
-	emailHeader := reader.Header
-	err := extractHeaders(emailHeader, email)
-	if err != nil {
-		log.Printf("Error extracting email headers: %v", err)
-		return err
-	}
+// summary returns a copy of the email without its body, as shown in lists
+func (email *IncomingMessage) summary() *IncomingMessage {
+	summary := *email
+	summary.Body = ""
+	summary.HTMLBody = ""
+	summary.BodyLoaded = false
+	return &summary
+}
 
-	err = extractBodyAndAttachments(reader, email)
-	return nil
+// parseRaw parses a complete RFC 5322 message, as fetched with BODY[]
+func (email *IncomingMessage) parseRaw(raw []byte) error {
+	return email.Parse(&imap.Message{
+		Size:  uint32(len(raw)),
+		Flags: email.Flags,
+		Body:  map[*imap.BodySectionName]imap.Literal{{}: bytes.NewBuffer(raw)},
+	})
+}
+
+// attachmentNames returns the file names of the attachments in a MIME tree
+func attachmentNames(parts *MIMEPart) []string {
+	var names []string
+	for _, part := range parts.Attachments() {
+		names = append(names, part.Filename)
+	}
+	return names
 }
 
 func findBodyReader(msg *imap.Message) io.Reader {
@@ -99,8 +168,14 @@ func findBodyReader(msg *imap.Message) io.Reader {
 
 // createEmailFromEnvelope creates a basic Email from just envelope data
 func createEmailFromEnvelope(email *IncomingMessage, envelope *imap.Envelope) error {
-	email.Subject = envelope.Subject
+	email.Subject = decodeHeaderText(envelope.Subject)
 	email.Date = envelope.Date
+	if ids := parseMsgIDs(envelope.MessageId); len(ids) > 0 {
+		email.MessageID = ids[0]
+	}
+	if ids := parseMsgIDs(envelope.InReplyTo); len(ids) > 0 {
+		email.InReplyTo = ids[0]
+	}
 
 	if len(envelope.From) > 0 {
 		email.From = formatImapAddress(envelope.From[0])
@@ -109,6 +184,8 @@ func createEmailFromEnvelope(email *IncomingMessage, envelope *imap.Envelope) er
 	if len(envelope.To) > 0 {
 		email.To = formatImapAddressList(envelope.To)
 	}
+	email.Cc = formatImapAddressList(envelope.Cc)
+	email.ReplyTo = formatImapAddressList(envelope.ReplyTo)
 
 	email.Body = "(Message body not available)"
 
@@ -116,6 +193,14 @@ func createEmailFromEnvelope(email *IncomingMessage, envelope *imap.Envelope) er
 }
 
 func extractHeaders(header mail.Header, email *IncomingMessage) error {
+	// Some mailers send headers as raw bytes in their own charset rather
+	// than as encoded words
+	for _, key := range []string{"From", "To", "Cc", "Reply-To", "Subject"} {
+		if value := header.Get(key); !utf8.ValidString(value) {
+			header.Set(key, decodeHeaderText(value))
+		}
+	}
+
 	from, err := header.AddressList("From")
 	if err != nil {
 		// Continue with empty From field
@@ -126,9 +211,16 @@ func extractHeaders(header mail.Header, email *IncomingMessage) error {
 		// Continue with empty To field
 	}
 
+	cc, _ := header.AddressList("Cc")
+	replyTo, _ := header.AddressList("Reply-To")
+
 	subject, err := header.Subject()
 	if err != nil {
-		subject = "(No subject)"
+		// A broken encoded word is better shown as it is than not at all
+		subject = strings.TrimSpace(header.Get("Subject"))
+		if subject == "" {
+			subject = "(No subject)"
+		}
 	}
 
 	date, err := header.Date()
@@ -138,65 +230,75 @@ func extractHeaders(header mail.Header, email *IncomingMessage) error {
 
 	email.From = formatAddressList(from)
 	email.To = formatAddressList(to)
+	email.Cc = formatAddressList(cc)
+	email.ReplyTo = formatAddressList(replyTo)
 	email.Subject = subject
 	email.Date = date
 
+	// Broken threading headers only cost the message its place in a thread
+	email.MessageID, _ = header.MessageID()
+	if inReplyTo, _ := header.MsgIDList("In-Reply-To"); len(inReplyTo) > 0 {
+		email.InReplyTo = inReplyTo[0]
+	}
+	email.References, _ = header.MsgIDList("References")
+
 	return nil
 }
 
-func extractBodyAndAttachments(reader *mail.Reader, email *IncomingMessage) error {
-	var plainText string
-	var attachmentNames []string
-
-	// Process each part of the message
-	partCount := 0
-	maxParts := 20 // Reasonable limit to avoid excessive processing
-
-	for partCount < maxParts {
-		partCount++
-
-		part, err := reader.NextPart()
-		if err != nil {
-			break
+// parseMsgIDs returns the message IDs in a header value such as
+// "<a@example.com> <b@example.com>", without their angle brackets
+func parseMsgIDs(value string) []string {
+	var ids []string
+	for {
+		start := strings.IndexByte(value, '<')
+		if start < 0 {
+			return ids
 		}
-
-		switch header := part.Header.(type) {
-		case *mail.InlineHeader:
-			// This is message content (either plain text or HTML)
-			contentType := "text/plain"
-
-			if ct, _, err := header.ContentType(); err == nil {
-				contentType = ct
-			} else {
-				continue
-			}
-
-			// Prioritize plain text for better memory efficiency
-			if strings.HasPrefix(contentType, "text/plain") {
-				plainText = readContent(part.Body)
-				if plainText != "" {
-					break
-				}
-			}
-		case *mail.AttachmentHeader:
-			// Just store attachment names, not the content
-			filename, err := header.Filename()
-			if err != nil {
-				filename = "unknown-attachment"
-			}
-			attachmentNames = append(attachmentNames, filename)
+		end := strings.IndexByte(value[start:], '>')
+		if end < 0 {
+			return ids
+		}
+		if id := strings.TrimSpace(value[start+1 : start+end]); id != "" {
+			ids = append(ids, id)
 		}
+		value = value[start+end+1:]
 	}
+}
+
+// setBody picks the text the email shows from its parts: the first plain
+// text part, or failing that its HTML rendered as text
+func (email *IncomingMessage) setBody(texts mimeTexts) {
+	var plainText, htmlText string
+	email.Parts.Walk(func(part *MIMEPart) bool {
+		if part.IsAttachment() {
+			return false
+		}
+		text, ok := texts[part]
+		switch {
+		case !ok:
+		case part.ContentType == "text/plain" && strings.TrimSpace(plainText) == "":
+			plainText = text
+		case part.ContentType == "text/html" && htmlText == "":
+			htmlText = text
+		}
+		return true
+	})
 
-	// Store just what we need
 	email.Body = plainText
-	email.Attachments = attachmentNames
+	email.HTMLBody = htmlText
+	email.HTMLOnly = false
+	email.Attachments = attachmentNames(email.Parts)
+
+	// Newsletters and notifications often come as HTML only
+	if strings.TrimSpace(plainText) == "" && strings.TrimSpace(htmlText) != "" {
+		email.Body = RenderHTML(htmlText, 0, false)
+		email.HTMLOnly = true
+	}
 
 	// If we still have no content
 	if email.Body == "" {
 		email.Body = "(No content found)"
 	}
-	return nil
 }
 
 func readContent(reader io.Reader) string {
@@ -225,8 +327,8 @@ func formatImapAddress(addr *imap.Address) string {
 		return ""
 	}
 
-	if addr.PersonalName != "" {
-		return fmt.Sprintf("%s <%s@%s>", addr.PersonalName, addr.MailboxName, addr.HostName)
+	if name := decodeHeaderText(addr.PersonalName); name != "" {
+		return fmt.Sprintf("%s <%s@%s>", name, addr.MailboxName, addr.HostName)
 	}
 	return fmt.Sprintf("%s@%s", addr.MailboxName, addr.HostName)
 }
@@ -256,7 +358,8 @@ func formatAddressList(addresses []*mail.Address) string {
 		if i > 0 {
 			result.WriteString(", ")
 		}
-		result.WriteString(addr.String())
+		// Unlike addr.String, shows names as they read, not as encoded words
+		result.WriteString(formatRecipient(addr))
 	}
 	return result.String()
 }
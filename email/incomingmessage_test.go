@@ -1,6 +1,7 @@
 package email
 
 import (
+	"strings"
 	"testing"
 	"time"
 
@@ -101,3 +102,55 @@ func TestCreateEmailFromEnvelope(t *testing.T) {
 		t.Errorf("Expected Body to be %q, got %q", expectedBody, email.Body)
 	}
 }
+
+func TestParseThreadingHeaders(t *testing.T) {
+	raw := "From: Bob <bob@example.com>\r\n" +
+		"Subject: Re: Lunch\r\n" +
+		"Message-ID: <c@example.com>\r\n" +
+		"In-Reply-To: <b@example.com>\r\n" +
+		"References: <a@example.com>\r\n <b@example.com>\r\n" +
+		"\r\n" +
+		"Sure\r\n"
+
+	email := &IncomingMessage{}
+	if err := email.parseRaw([]byte(raw)); err != nil {
+		t.Fatalf("parseRaw returned error: %v", err)
+	}
+	if email.MessageID != "c@example.com" || email.InReplyTo != "b@example.com" {
+		t.Errorf("Expected Message-ID c@example.com in reply to b@example.com, got %q and %q", email.MessageID, email.InReplyTo)
+	}
+	if len(email.References) != 2 || email.References[0] != "a@example.com" {
+		t.Errorf("Expected two references, got %v", email.References)
+	}
+}
+
+func TestParseHTMLOnly(t *testing.T) {
+	raw := "From: news@example.com\r\n" +
+		"Subject: Weekly\r\n" +
+		"Content-Type: multipart/alternative; boundary=frontier\r\n" +
+		"\r\n" +
+		"--frontier\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"   \r\n" +
+		"--frontier\r\n" +
+		"Content-Type: text/html\r\n" +
+		"\r\n" +
+		"<p>Hello <a href=\"https://example.com\">world</a></p>\r\n" +
+		"--frontier--\r\n"
+
+	email := &IncomingMessage{}
+	if err := email.parseRaw([]byte(raw)); err != nil {
+		t.Fatalf("parseRaw returned error: %v", err)
+	}
+	// An empty plain part is no use, so the HTML stands in for it
+	if !email.HTMLOnly || !strings.Contains(email.HTMLBody, "<a href") {
+		t.Errorf("Expected an HTML only email, got %+v", email)
+	}
+	if email.Body != "Hello world[1]\n\n[1] https://example.com" {
+		t.Errorf("Expected the HTML as plain text, got %q", email.Body)
+	}
+	if summary := email.summary(); summary.HTMLBody != "" {
+		t.Errorf("Expected the summary without the HTML, got %q", summary.HTMLBody)
+	}
+}
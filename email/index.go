@@ -0,0 +1,416 @@
+package email
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// indexField is the part of a message a word was found in
+type indexField uint32
+
+const (
+	fieldFrom indexField = iota
+	fieldTo
+	fieldSubject
+	fieldBody
+	numFields
+)
+
+// indexedDoc is what the index knows of a message besides its words
+type indexedDoc struct {
+	Mailbox     string
+	UIDValidity uint32
+	UID         uint32
+	From        string
+	To          string
+	Subject     string
+	Date        time.Time
+	Flags       []string
+	Attachments []string
+	MessageID   string
+	BodyIndexed bool // Whether the words of the body are in the index
+	Deleted     bool // Left the mailbox; dropped on the next compaction
+}
+
+// SearchIndex is an inverted index over the cached messages of an account,
+// so they can be searched without the server. Every word of a message's
+// headers, and of its body once downloaded, maps to the positions it was
+// found at, which makes phrase queries possible.
+//
+// Messages are added as the cache learns of them and dropped when they leave
+// their mailbox. Dropped messages are only marked deleted, and squeezed out
+// when the index is saved with too many of them.
+type SearchIndex struct {
+	path string // Where the index is saved, "" to keep it in memory
+
+	Docs  []*indexedDoc
+	Terms map[string]map[uint32][]uint32 // Word to doc*numFields+field to positions
+
+	byID   map[string]uint32 // Doc of each message ID
+	sorted []string          // Terms in order, for prefix queries; nil when stale
+	dirty  bool
+}
+
+// newSearchIndex returns an empty index saved at path
+func newSearchIndex(path string) *SearchIndex {
+	return &SearchIndex{path: path, Terms: map[string]map[uint32][]uint32{}, byID: map[string]uint32{}}
+}
+
+// loadSearchIndex reads the index saved at path. A missing or corrupt index
+// comes back empty and is rebuilt as mail is synced.
+func loadSearchIndex(path string) *SearchIndex {
+	idx := newSearchIndex(path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return idx
+	}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(idx); err != nil {
+		return newSearchIndex(path)
+	}
+	if idx.Terms == nil {
+		idx.Terms = map[string]map[uint32][]uint32{}
+	}
+	for i, doc := range idx.Docs {
+		if !doc.Deleted {
+			idx.byID[docID(doc.Mailbox, doc.UIDValidity, doc.UID)] = uint32(i)
+		}
+	}
+	return idx
+}
+
+// save writes the index if it changed, compacting it first when more than a
+// quarter of its messages are gone
+func (idx *SearchIndex) save() error {
+	if !idx.dirty || idx.path == "" {
+		return nil
+	}
+	deleted := 0
+	for _, doc := range idx.Docs {
+		if doc.Deleted {
+			deleted++
+		}
+	}
+	if deleted > len(idx.Docs)/4 {
+		idx.compact()
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(idx); err != nil {
+		return fmt.Errorf("failed to encode search index: %v", err)
+	}
+	if err := writeFileAtomic(idx.path, buf.Bytes()); err != nil {
+		return err
+	}
+	idx.dirty = false
+	return nil
+}
+
+// compact drops deleted messages and their words
+func (idx *SearchIndex) compact() {
+	renumber := make(map[uint32]uint32, len(idx.Docs))
+	docs := make([]*indexedDoc, 0, len(idx.Docs))
+	for i, doc := range idx.Docs {
+		if !doc.Deleted {
+			renumber[uint32(i)] = uint32(len(docs))
+			docs = append(docs, doc)
+		}
+	}
+
+	terms := make(map[string]map[uint32][]uint32, len(idx.Terms))
+	for term, postings := range idx.Terms {
+		kept := map[uint32][]uint32{}
+		for key, positions := range postings {
+			if doc, ok := renumber[key/uint32(numFields)]; ok {
+				kept[doc*uint32(numFields)+key%uint32(numFields)] = positions
+			}
+		}
+		if len(kept) > 0 {
+			terms[term] = kept
+		}
+	}
+
+	idx.Docs, idx.Terms, idx.sorted = docs, terms, nil
+	idx.byID = make(map[string]uint32, len(docs))
+	for i, doc := range docs {
+		idx.byID[docID(doc.Mailbox, doc.UIDValidity, doc.UID)] = uint32(i)
+	}
+}
+
+// docID is the ID of a message, as IncomingMessage.ID
+func docID(mailbox string, uidValidity, uid uint32) string {
+	return fmt.Sprintf("%s/%d/%d", mailbox, uidValidity, uid)
+}
+
+// add indexes the headers of a message, and its body if loaded. Messages
+// already indexed only have their flags updated, and their body indexed if
+// it was missing.
+func (idx *SearchIndex) add(email *IncomingMessage) {
+	if email.UID == 0 {
+		return
+	}
+	if i, ok := idx.byID[email.ID()]; ok {
+		doc := idx.Docs[i]
+		if !sameFlags(doc.Flags, email.Flags) {
+			doc.Flags = email.Flags
+			idx.dirty = true
+		}
+		if email.BodyLoaded && !doc.BodyIndexed {
+			idx.addField(i, fieldBody, email.Body)
+			doc.BodyIndexed = true
+			idx.dirty = true
+		}
+		return
+	}
+
+	i := uint32(len(idx.Docs))
+	idx.Docs = append(idx.Docs, &indexedDoc{
+		Mailbox:     email.Mailbox,
+		UIDValidity: email.UIDValidity,
+		UID:         email.UID,
+		From:        email.From,
+		To:          email.To,
+		Subject:     email.Subject,
+		Date:        email.Date,
+		Flags:       email.Flags,
+		Attachments: email.Attachments,
+		MessageID:   email.MessageID,
+		BodyIndexed: email.BodyLoaded,
+	})
+	idx.byID[email.ID()] = i
+	idx.addField(i, fieldFrom, email.From)
+	idx.addField(i, fieldTo, email.To)
+	idx.addField(i, fieldSubject, email.Subject)
+	if email.BodyLoaded {
+		idx.addField(i, fieldBody, email.Body)
+	}
+	idx.dirty = true
+}
+
+// addField indexes the words of one field of a doc
+func (idx *SearchIndex) addField(doc uint32, field indexField, text string) {
+	key := doc*uint32(numFields) + uint32(field)
+	for pos, term := range tokenize(text) {
+		postings := idx.Terms[term]
+		if postings == nil {
+			postings = map[uint32][]uint32{}
+			idx.Terms[term] = postings
+			idx.sorted = nil
+		}
+		postings[key] = append(postings[key], uint32(pos))
+	}
+}
+
+// remove drops messages that left a mailbox
+func (idx *SearchIndex) remove(mailbox string, uidValidity uint32, uids []uint32) {
+	for _, uid := range uids {
+		id := docID(mailbox, uidValidity, uid)
+		if i, ok := idx.byID[id]; ok {
+			idx.Docs[i].Deleted = true
+			delete(idx.byID, id)
+			idx.dirty = true
+		}
+	}
+}
+
+// keepValidity drops the messages of a mailbox indexed under another
+// UIDVALIDITY, whose UIDs mean nothing anymore
+func (idx *SearchIndex) keepValidity(mailbox string, uidValidity uint32) {
+	for _, doc := range idx.Docs {
+		if !doc.Deleted && doc.Mailbox == mailbox && doc.UIDValidity != uidValidity {
+			doc.Deleted = true
+			delete(idx.byID, docID(doc.Mailbox, doc.UIDValidity, doc.UID))
+			idx.dirty = true
+		}
+	}
+}
+
+// search returns up to n of the newest indexed messages of a mailbox ("" for
+// every mailbox) matching the query
+func (idx *SearchIndex) search(mailbox string, q *SearchQuery, n int) []*IncomingMessage {
+	var clauses []map[uint32]bool
+	scoped := []struct {
+		values []string
+		fields []indexField
+	}{
+		{q.From, []indexField{fieldFrom}},
+		{q.To, []indexField{fieldTo}},
+		{q.Subject, []indexField{fieldSubject}},
+		{q.Body, []indexField{fieldBody}},
+		{q.Text, []indexField{fieldFrom, fieldTo, fieldSubject, fieldBody}},
+	}
+	for _, scope := range scoped {
+		for _, value := range scope.values {
+			clauses = append(clauses, idx.match(value, scope.fields))
+		}
+	}
+
+	var found []*indexedDoc
+	for i, doc := range idx.Docs {
+		if doc.Deleted || (mailbox != "" && doc.Mailbox != mailbox) || !q.matchesDoc(doc) {
+			continue
+		}
+		matches := true
+		for _, clause := range clauses {
+			if !clause[uint32(i)] {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			found = append(found, doc)
+		}
+	}
+
+	// Newest first
+	sort.SliceStable(found, func(i, j int) bool { return found[i].Date.After(found[j].Date) })
+	if n > 0 && len(found) > n {
+		found = found[:n]
+	}
+
+	emails := make([]*IncomingMessage, len(found))
+	for i, doc := range found {
+		emails[i] = &IncomingMessage{
+			Mailbox:     doc.Mailbox,
+			UIDValidity: doc.UIDValidity,
+			UID:         doc.UID,
+			From:        doc.From,
+			To:          doc.To,
+			Subject:     doc.Subject,
+			Date:        doc.Date,
+			Flags:       doc.Flags,
+			Attachments: doc.Attachments,
+			MessageID:   doc.MessageID,
+		}
+	}
+	return emails
+}
+
+// match returns the docs with the words of value, in order and next to
+// each other, in one of the fields. A value ending in * also matches words
+// starting with its last word.
+func (idx *SearchIndex) match(value string, fields []indexField) map[uint32]bool {
+	prefix := strings.HasSuffix(value, "*")
+	words := tokenize(strings.TrimSuffix(value, "*"))
+	matched := map[uint32]bool{}
+	if len(words) == 0 {
+		return matched
+	}
+
+	// The postings of each word; the last word may stand for several
+	candidates := make([][]map[uint32][]uint32, len(words))
+	for i, word := range words {
+		if prefix && i == len(words)-1 {
+			for _, term := range idx.termsWithPrefix(word) {
+				candidates[i] = append(candidates[i], idx.Terms[term])
+			}
+		} else if postings, ok := idx.Terms[word]; ok {
+			candidates[i] = append(candidates[i], postings)
+		}
+		if len(candidates[i]) == 0 {
+			return matched
+		}
+	}
+
+	inFields := make(map[uint32]bool, len(fields))
+	for _, field := range fields {
+		inFields[uint32(field)] = true
+	}
+	for _, first := range candidates[0] {
+		for key, positions := range first {
+			doc := key / uint32(numFields)
+			if matched[doc] || !inFields[key%uint32(numFields)] {
+				continue
+			}
+			for _, pos := range positions {
+				if followedBy(candidates[1:], key, pos+1) {
+					matched[doc] = true
+					break
+				}
+			}
+		}
+	}
+	return matched
+}
+
+// followedBy reports whether the words of rest come in order from pos on in
+// the field key
+func followedBy(rest [][]map[uint32][]uint32, key uint32, pos uint32) bool {
+	if len(rest) == 0 {
+		return true
+	}
+	for _, postings := range rest[0] {
+		for _, p := range postings[key] {
+			if p == pos && followedBy(rest[1:], key, pos+1) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// termsWithPrefix returns the indexed words starting with prefix
+func (idx *SearchIndex) termsWithPrefix(prefix string) []string {
+	if idx.sorted == nil {
+		idx.sorted = make([]string, 0, len(idx.Terms))
+		for term := range idx.Terms {
+			idx.sorted = append(idx.sorted, term)
+		}
+		sort.Strings(idx.sorted)
+	}
+	start := sort.SearchStrings(idx.sorted, prefix)
+	end := start
+	for end < len(idx.sorted) && strings.HasPrefix(idx.sorted[end], prefix) {
+		end++
+	}
+	return idx.sorted[start:end]
+}
+
+// matchesDoc checks the parts of the query that are not words: dates,
+// flags, attachments and the Message-ID
+func (q *SearchQuery) matchesDoc(doc *indexedDoc) bool {
+	if q.MessageID != "" && doc.MessageID != q.MessageID {
+		return false
+	}
+	if !q.Since.IsZero() && doc.Date.Before(q.Since) {
+		return false
+	}
+	if !q.Before.IsZero() && !doc.Date.Before(q.Before) {
+		return false
+	}
+	if q.HasAttachment && len(doc.Attachments) == 0 {
+		return false
+	}
+	msg := &IncomingMessage{Flags: doc.Flags}
+	if (q.Unread && msg.Seen()) || (q.Read && !msg.Seen()) || (q.Starred && !msg.Flagged()) {
+		return false
+	}
+	return true
+}
+
+// foldAccents returns a transformer stripping accents, so that "café" and
+// "cafe" are the same word. Transformers keep state, so each use needs its own.
+func foldAccents() transform.Transformer {
+	return transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+}
+
+// tokenize splits text into lowercase words without accents. Anything but
+// letters and digits separates words, so alice@example.com is three words.
+func tokenize(text string) []string {
+	folded, _, err := transform.String(foldAccents(), text)
+	if err != nil {
+		folded = text
+	}
+	return strings.FieldsFunc(strings.ToLower(folded), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
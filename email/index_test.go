@@ -0,0 +1,176 @@
+package email
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+// testIndex returns an index holding a few messages of INBOX and Archive
+func testIndex(path string) *SearchIndex {
+	idx := newSearchIndex(path)
+	day := time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local)
+	idx.add(&IncomingMessage{Mailbox: InboxName, UIDValidity: 1, UID: 1, Date: day,
+		From: "Alice Smith <alice@example.com>", To: "me@example.com", Subject: "Quarterly budget review",
+		Body: "Please find the numbers attached.", BodyLoaded: true, Attachments: []string{"budget.xlsx"}})
+	idx.add(&IncomingMessage{Mailbox: InboxName, UIDValidity: 1, UID: 2, Date: day.AddDate(0, 0, 1),
+		From: "Bob <bob@example.com>", To: "me@example.com", Subject: "Café on Friday?",
+		Flags: []string{imap.SeenFlag}, MessageID: "cafe@example.com"})
+	idx.add(&IncomingMessage{Mailbox: "Archive", UIDValidity: 5, UID: 9, Date: day.AddDate(0, 0, 2),
+		From: "alice@example.com", To: "me@example.com", Subject: "Review of the budget",
+		Body: "Budget review notes", BodyLoaded: true})
+	return idx
+}
+
+func searchSubjects(t *testing.T, idx *SearchIndex, mailbox, query string) []string {
+	t.Helper()
+	q, err := ParseSearchQuery(query)
+	if err != nil {
+		t.Fatalf("ParseSearchQuery(%q) returned error: %v", query, err)
+	}
+	var subjects []string
+	for _, email := range idx.search(mailbox, q, 0) {
+		subjects = append(subjects, email.Subject)
+	}
+	return subjects
+}
+
+func TestTokenize(t *testing.T) {
+	words := tokenize("Café CRÈME, alice@Example.com!")
+	expected := []string{"cafe", "creme", "alice", "example", "com"}
+	if len(words) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, words)
+	}
+	for i := range expected {
+		if words[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, words)
+		}
+	}
+}
+
+func TestSearchIndexQueries(t *testing.T) {
+	idx := testIndex("")
+
+	tests := []struct {
+		query    string
+		mailbox  string
+		expected []string
+	}{
+		{"budget", "", []string{"Review of the budget", "Quarterly budget review"}},
+		{"budget", InboxName, []string{"Quarterly budget review"}},
+		{`"budget review"`, "", []string{"Review of the budget", "Quarterly budget review"}},
+		{`subject:"budget review"`, "", []string{"Quarterly budget review"}},
+		{"cafe", "", []string{"Café on Friday?"}},
+		{"budg*", InboxName, []string{"Quarterly budget review"}},
+		{`subject:"quarterly bud*"`, "", []string{"Quarterly budget review"}},
+		{"from:alice", "", []string{"Review of the budget", "Quarterly budget review"}},
+		{"from:bob budget", "", nil},
+		{"body:numbers", "", []string{"Quarterly budget review"}},
+		{"has:attachment", "", []string{"Quarterly budget review"}},
+		{"is:read", "", []string{"Café on Friday?"}},
+		{"msgid:<cafe@example.com>", "", []string{"Café on Friday?"}},
+		{"from:alice since:2024-03-02", "", []string{"Review of the budget"}},
+		{"from:alice before:2024-03-02", "", []string{"Quarterly budget review"}},
+		{"nothing", "", nil},
+	}
+	for _, test := range tests {
+		got := searchSubjects(t, idx, test.mailbox, test.query)
+		if len(got) != len(test.expected) {
+			t.Errorf("%q: expected %v, got %v", test.query, test.expected, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.expected[i] {
+				t.Errorf("%q: expected %v, got %v", test.query, test.expected, got)
+				break
+			}
+		}
+	}
+}
+
+func TestSearchIndexUpdates(t *testing.T) {
+	idx := testIndex("")
+
+	// Flags and late bodies update the existing entry
+	idx.add(&IncomingMessage{Mailbox: InboxName, UIDValidity: 1, UID: 2, Subject: "Café on Friday?",
+		Body: "Shall we meet at noon?", BodyLoaded: true})
+	if got := searchSubjects(t, idx, "", "noon is:unread"); len(got) != 1 {
+		t.Errorf("Expected the body and flags to be updated, got %v", got)
+	}
+
+	idx.remove(InboxName, 1, []uint32{1})
+	if got := searchSubjects(t, idx, "", "budget"); len(got) != 1 || got[0] != "Review of the budget" {
+		t.Errorf("Expected the removed message to be gone, got %v", got)
+	}
+
+	idx.keepValidity("Archive", 6)
+	if got := searchSubjects(t, idx, "", "budget"); len(got) != 0 {
+		t.Errorf("Expected the stale UIDVALIDITY to be dropped, got %v", got)
+	}
+}
+
+func TestSearchIndexSaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "search.gob")
+	idx := testIndex(path)
+	idx.remove(InboxName, 1, []uint32{1, 2}) // Enough to compact
+	if err := idx.save(); err != nil {
+		t.Fatalf("save returned error: %v", err)
+	}
+	if len(idx.Docs) != 1 {
+		t.Errorf("Expected the removed messages to be compacted away, got %d docs", len(idx.Docs))
+	}
+
+	loaded := loadSearchIndex(path)
+	if got := searchSubjects(t, loaded, "", "revi*"); len(got) != 1 || got[0] != "Review of the budget" {
+		t.Errorf("Expected the saved index to be searchable, got %v", got)
+	}
+	if got := searchSubjects(t, loaded, "", "cafe"); len(got) != 0 {
+		t.Errorf("Expected removed messages to stay gone, got %v", got)
+	}
+}
+
+func TestIMAPProviderSearchOffline(t *testing.T) {
+	useTempCache(t)
+	servers := startTestServers(t)
+	servers.addMessage(t, "INBOX", "From: alice@example.com\r\nSubject: Budget\r\n\r\nNumbers inside\r\n")
+	servers.addMessage(t, "INBOX", "From: bob@example.com\r\nSubject: Lunch\r\n\r\nPizza?\r\n")
+
+	creds := testCredentials()
+	creds.Account = "work"
+	provider, err := NewGenericIMAPProvider(servers.config, creds)
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Disconnect()
+
+	emails, err := provider.GetEmailsPage(InboxName, 0, 10)
+	if err != nil {
+		t.Fatalf("GetEmailsPage returned error: %v", err)
+	}
+
+	q, _ := ParseSearchQuery("pizza")
+	if found, _ := provider.SearchOffline(InboxName, q, 10); len(found) != 0 {
+		t.Errorf("Expected bodies not to be searchable before they are downloaded, got %d", len(found))
+	}
+	if _, err := provider.FetchMessage(emails[0]); err != nil {
+		t.Fatalf("FetchMessage returned error: %v", err)
+	}
+	found, _ := provider.SearchOffline(InboxName, q, 10)
+	if len(found) != 1 || found[0].Subject != "Lunch" {
+		t.Errorf("Expected to find Lunch by its body, got %d emails", len(found))
+	}
+
+	// A fresh provider reads the index from disk, without the server
+	servers.config.IMAPPort = "1"
+	offline, err := NewGenericIMAPProvider(servers.config, creds)
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	q, _ = ParseSearchQuery("from:alice")
+	found, _ = offline.SearchOffline("", q, 10)
+	if len(found) != 1 || found[0].Subject != "Budget" {
+		t.Errorf("Expected to find Budget offline, got %d emails", len(found))
+	}
+}
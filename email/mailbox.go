@@ -0,0 +1,175 @@
+package email
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-imap"
+)
+
+// InboxName is the name every IMAP server uses for the inbox
+const InboxName = "INBOX"
+
+// Mailbox describes a folder on the mail server
+type Mailbox struct {
+	Name       string   // Full name, e.g. "[Gmail]/Sent Mail"
+	Delimiter  string   // Hierarchy delimiter, e.g. "/"
+	Attributes []string // Raw attributes from the LIST response
+	SpecialUse string   // One of the special-use attributes (\Sent, \Trash, ...), empty for ordinary folders
+}
+
+// Selectable reports whether messages can be fetched from the mailbox
+func (m *Mailbox) Selectable() bool {
+	for _, attr := range m.Attributes {
+		if strings.EqualFold(attr, imap.NoSelectAttr) {
+			return false
+		}
+	}
+	return true
+}
+
+// DisplayName returns the last component of the mailbox name
+func (m *Mailbox) DisplayName() string {
+	if strings.EqualFold(m.Name, InboxName) {
+		return "Inbox"
+	}
+	if m.Delimiter != "" {
+		if i := strings.LastIndex(m.Name, m.Delimiter); i >= 0 {
+			return m.Name[i+len(m.Delimiter):]
+		}
+	}
+	return m.Name
+}
+
+// MailboxStatus holds the message counts of a mailbox
+type MailboxStatus struct {
+	Name        string
+	Messages    uint32
+	Unseen      uint32
+	UIDValidity uint32 // Changes when the UIDs of the mailbox's emails do
+}
+
+// specialUseAttrs are the RFC 6154 attributes marking well-known mailboxes
+var specialUseAttrs = []string{
+	imap.AllAttr,
+	imap.ArchiveAttr,
+	imap.DraftsAttr,
+	imap.FlaggedAttr,
+	imap.JunkAttr,
+	imap.SentAttr,
+	imap.TrashAttr,
+}
+
+// specialUseNames guesses the special use of mailboxes on servers that do not
+// support SPECIAL-USE from their usual names
+var specialUseNames = map[string]string{
+	"archive":       imap.ArchiveAttr,
+	"drafts":        imap.DraftsAttr,
+	"junk":          imap.JunkAttr,
+	"spam":          imap.JunkAttr,
+	"sent":          imap.SentAttr,
+	"sent items":    imap.SentAttr,
+	"sent mail":     imap.SentAttr,
+	"sent messages": imap.SentAttr,
+	"trash":         imap.TrashAttr,
+	"deleted items": imap.TrashAttr,
+}
+
+// newMailbox converts a LIST response into a Mailbox
+func newMailbox(info *imap.MailboxInfo) *Mailbox {
+	mailbox := &Mailbox{
+		Name:       info.Name,
+		Delimiter:  info.Delimiter,
+		Attributes: info.Attributes,
+	}
+
+	for _, attr := range info.Attributes {
+		for _, use := range specialUseAttrs {
+			if strings.EqualFold(attr, use) {
+				mailbox.SpecialUse = use
+			}
+		}
+	}
+	if mailbox.SpecialUse == "" {
+		mailbox.SpecialUse = specialUseNames[strings.ToLower(mailbox.DisplayName())]
+	}
+
+	return mailbox
+}
+
+// FindSpecialUse returns the first mailbox with the given special use, or nil
+func FindSpecialUse(mailboxes []*Mailbox, use string) *Mailbox {
+	for _, mailbox := range mailboxes {
+		if mailbox.SpecialUse == use {
+			return mailbox
+		}
+	}
+	return nil
+}
+
+// sortMailboxes orders mailboxes with the inbox first, then alphabetically
+func sortMailboxes(mailboxes []*Mailbox) {
+	sort.SliceStable(mailboxes, func(i, j int) bool {
+		iInbox := strings.EqualFold(mailboxes[i].Name, InboxName)
+		jInbox := strings.EqualFold(mailboxes[j].Name, InboxName)
+		if iInbox != jInbox {
+			return iInbox
+		}
+		return mailboxes[i].Name < mailboxes[j].Name
+	})
+}
+
+// ListMailboxes returns every mailbox on the server, inbox first
+func (p *GenericIMAPProvider) ListMailboxes() ([]*Mailbox, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.ensureConnected(); err != nil {
+		return nil, err
+	}
+	return p.listMailboxes()
+}
+
+// listMailboxes is ListMailboxes for callers holding the lock
+func (p *GenericIMAPProvider) listMailboxes() ([]*Mailbox, error) {
+	infos := make(chan *imap.MailboxInfo, 20)
+	done := make(chan error, 1)
+	go func() {
+		done <- p.client.List("", "*", infos)
+	}()
+
+	var mailboxes []*Mailbox
+	for info := range infos {
+		mailboxes = append(mailboxes, newMailbox(info))
+	}
+	if err := <-done; err != nil {
+		return nil, fmt.Errorf("failed to list mailboxes: %v", err)
+	}
+
+	sortMailboxes(mailboxes)
+	return mailboxes, nil
+}
+
+// MailboxStatus returns the message and unseen counts of a mailbox, and
+// its UIDVALIDITY
+func (p *GenericIMAPProvider) MailboxStatus(name string) (*MailboxStatus, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.ensureConnected(); err != nil {
+		return nil, err
+	}
+
+	status, err := p.client.Status(name, []imap.StatusItem{imap.StatusMessages, imap.StatusUnseen, imap.StatusUidValidity})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get status of %s: %v", name, err)
+	}
+
+	return &MailboxStatus{
+		Name:        name,
+		Messages:    status.Messages,
+		Unseen:      status.Unseen,
+		UIDValidity: status.UidValidity,
+	}, nil
+}
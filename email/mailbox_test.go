@@ -0,0 +1,93 @@
+package email
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap"
+)
+
+func TestNewMailboxSpecialUse(t *testing.T) {
+	tests := []struct {
+		info *imap.MailboxInfo
+		want string
+	}{
+		{&imap.MailboxInfo{Name: "[Gmail]/Sent Mail", Delimiter: "/", Attributes: []string{imap.HasNoChildrenAttr, imap.SentAttr}}, imap.SentAttr},
+		{&imap.MailboxInfo{Name: "[Gmail]/All Mail", Delimiter: "/", Attributes: []string{"\\all"}}, imap.AllAttr},
+		{&imap.MailboxInfo{Name: "INBOX.Trash", Delimiter: ".", Attributes: nil}, imap.TrashAttr},
+		{&imap.MailboxInfo{Name: "Deleted Items", Delimiter: "/"}, imap.TrashAttr},
+		{&imap.MailboxInfo{Name: "Projects", Delimiter: "/"}, ""},
+	}
+
+	for _, tt := range tests {
+		if got := newMailbox(tt.info).SpecialUse; got != tt.want {
+			t.Errorf("%s: expected special use %q, got %q", tt.info.Name, tt.want, got)
+		}
+	}
+}
+
+func TestMailboxNames(t *testing.T) {
+	mailbox := &Mailbox{Name: "[Gmail]/Sent Mail", Delimiter: "/"}
+	if got := mailbox.DisplayName(); got != "Sent Mail" {
+		t.Errorf("Expected Sent Mail, got %q", got)
+	}
+	if got := (&Mailbox{Name: "INBOX"}).DisplayName(); got != "Inbox" {
+		t.Errorf("Expected Inbox, got %q", got)
+	}
+
+	if !mailbox.Selectable() {
+		t.Error("Expected mailbox to be selectable")
+	}
+	if (&Mailbox{Name: "[Gmail]", Attributes: []string{imap.NoSelectAttr}}).Selectable() {
+		t.Error("Expected \\Noselect mailbox not to be selectable")
+	}
+
+	mailboxes := []*Mailbox{{Name: "Work"}, {Name: "Archive"}, {Name: "INBOX"}}
+	sortMailboxes(mailboxes)
+	if mailboxes[0].Name != "INBOX" || mailboxes[1].Name != "Archive" || mailboxes[2].Name != "Work" {
+		t.Errorf("Unexpected order %s, %s, %s", mailboxes[0].Name, mailboxes[1].Name, mailboxes[2].Name)
+	}
+}
+
+func TestIMAPProviderMailboxes(t *testing.T) {
+	servers := startTestServers(t)
+	servers.createMailbox(t, "Sent")
+	servers.createMailbox(t, "Archive")
+	servers.addMessage(t, "Archive", "From: alice@example.com\r\nSubject: Old news\r\n\r\nArchived body\r\n")
+
+	provider, err := NewGenericIMAPProvider(servers.config, testCredentials())
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Disconnect()
+
+	mailboxes, err := provider.ListMailboxes()
+	if err != nil {
+		t.Fatalf("ListMailboxes returned error: %v", err)
+	}
+	if len(mailboxes) != 3 || mailboxes[0].Name != InboxName {
+		t.Fatalf("Expected INBOX first of 3 mailboxes, got %d", len(mailboxes))
+	}
+	if sent := FindSpecialUse(mailboxes, imap.SentAttr); sent == nil || sent.Name != "Sent" {
+		t.Errorf("Expected to find the Sent mailbox, got %+v", sent)
+	}
+
+	status, err := provider.MailboxStatus("Archive")
+	if err != nil {
+		t.Fatalf("MailboxStatus returned error: %v", err)
+	}
+	if status.Messages != 1 || status.Unseen != 1 || status.UIDValidity == 0 {
+		t.Errorf("Expected 1 unseen message and the UIDVALIDITY, got %+v", status)
+	}
+
+	emails, err := provider.GetMailboxEmails("Archive", 10)
+	if err != nil {
+		t.Fatalf("GetMailboxEmails returned error: %v", err)
+	}
+	if len(emails) != 1 || emails[0].Subject != "Old news" {
+		t.Errorf("Expected the archived message, got %d emails", len(emails))
+	}
+
+	if _, err := provider.GetMailboxEmails("Missing", 10); err == nil {
+		t.Error("Expected error fetching from a missing mailbox")
+	}
+}
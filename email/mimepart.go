@@ -0,0 +1,289 @@
+package email
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/textproto"
+)
+
+// maxMIMEDepth bounds how deeply parts may nest, so a hostile message
+// cannot exhaust the stack. Real emails rarely nest more than a few levels.
+const maxMIMEDepth = 32
+
+// MIMEPart is a part of an email's MIME structure. A multipart part holds
+// its parts as children, and a message/rfc822 part holds the email it
+// carries, so every part can be found and downloaded on its own.
+type MIMEPart struct {
+	ContentType string            // Lowercased media type, e.g. text/plain
+	Params      map[string]string // Content-Type parameters such as charset or boundary
+	Disposition string            // inline, attachment, or "" when the part does not say
+	Filename    string
+	Charset     string
+	Encoding    string // Content-Transfer-Encoding, lowercased, e.g. base64
+	Size        uint32 // Encoded size in bytes
+	Path        []int  // IMAP section path, e.g. [2 1] for BODY[2.1]; empty for a multipart email
+	Children    []*MIMEPart
+}
+
+// Section returns the IMAP section name of the part, e.g. "2.1"
+func (part *MIMEPart) Section() string {
+	path := part.Path
+	if len(path) == 0 {
+		path = []int{1} // The body of a single part message
+	}
+	parts := make([]string, len(path))
+	for i, n := range path {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ".")
+}
+
+// IsMultipart reports whether the part is made of other parts
+func (part *MIMEPart) IsMultipart() bool {
+	return strings.HasPrefix(part.ContentType, "multipart/")
+}
+
+// IsAttachment reports whether the part is meant to be saved rather than
+// read in place. Like go-message, parts that do not say count as
+// attachments unless they are text.
+func (part *MIMEPart) IsAttachment() bool {
+	if part.IsMultipart() {
+		return false
+	}
+	switch part.Disposition {
+	case "attachment":
+		return true
+	case "inline":
+		return false
+	}
+	return !strings.HasPrefix(part.ContentType, "text/")
+}
+
+// Walk calls visit for the part and every part below it, depth first.
+// Returning false from visit skips the parts below that part.
+func (part *MIMEPart) Walk(visit func(*MIMEPart) bool) {
+	if part == nil || !visit(part) {
+		return
+	}
+	for _, child := range part.Children {
+		child.Walk(visit)
+	}
+}
+
+// Attachments returns the attachments among the part and the parts below
+// it. An attached email counts as one attachment, whatever it contains.
+func (part *MIMEPart) Attachments() []*MIMEPart {
+	var attachments []*MIMEPart
+	part.Walk(func(p *MIMEPart) bool {
+		if p.IsAttachment() {
+			attachments = append(attachments, p)
+			return false
+		}
+		return true
+	})
+	return attachments
+}
+
+// Find returns the first part of a content type that is not an attachment,
+// e.g. the HTML alternative of an email, or nil
+func (part *MIMEPart) Find(contentType string) *MIMEPart {
+	var found *MIMEPart
+	part.Walk(func(p *MIMEPart) bool {
+		if found == nil && p.ContentType == contentType && !p.IsAttachment() {
+			found = p
+		}
+		return found == nil && !p.IsAttachment()
+	})
+	return found
+}
+
+// childPath returns the path of the nth part below path
+func childPath(path []int, n int) []int {
+	return append(path[:len(path):len(path)], n)
+}
+
+// nameAttachment fills in a name for attachments that have none
+func (part *MIMEPart) nameAttachment() {
+	if part.Filename != "" || !part.IsAttachment() {
+		return
+	}
+	part.Filename = "unknown-attachment"
+	if part.ContentType == "message/rfc822" {
+		part.Filename = "message.eml"
+	}
+}
+
+// mimeTreeFromStructure builds the MIME tree of an email from the
+// BODYSTRUCTURE the server sent, before its body is downloaded
+func mimeTreeFromStructure(structure *imap.BodyStructure) *MIMEPart {
+	return mimePartFromStructure(structure, nil, 0)
+}
+
+// mimePartFromStructure builds the part of a message at path. Like the
+// message itself, a single part message inside an email is part path.1.
+func mimePartFromStructure(structure *imap.BodyStructure, path []int, depth int) *MIMEPart {
+	if len(structure.Parts) == 0 && !strings.EqualFold(structure.MIMEType, "multipart") {
+		path = childPath(path, 1)
+	}
+	return mimeStructurePart(structure, path, depth)
+}
+
+// mimeStructurePart converts one BODYSTRUCTURE part and those below it
+func mimeStructurePart(structure *imap.BodyStructure, path []int, depth int) *MIMEPart {
+	part := &MIMEPart{
+		ContentType: strings.ToLower(structure.MIMEType + "/" + structure.MIMESubType),
+		Params:      map[string]string{},
+		Disposition: strings.ToLower(structure.Disposition),
+		Encoding:    strings.ToLower(structure.Encoding),
+		Size:        structure.Size,
+		Path:        path,
+	}
+	for key, value := range structure.Params {
+		part.Params[strings.ToLower(key)] = value
+	}
+	part.Charset = part.Params["charset"]
+	if filename, err := structure.Filename(); err == nil {
+		part.Filename = filename
+	}
+	part.nameAttachment()
+
+	if depth >= maxMIMEDepth {
+		return part
+	}
+	for i, child := range structure.Parts {
+		part.Children = append(part.Children, mimeStructurePart(child, childPath(path, i+1), depth+1))
+	}
+	if part.ContentType == "message/rfc822" && structure.BodyStructure != nil {
+		part.Children = append(part.Children, mimePartFromStructure(structure.BodyStructure, path, depth+1))
+	}
+	return part
+}
+
+// mimeTexts holds the decoded content of the text parts of an email that
+// are read rather than saved
+type mimeTexts map[*MIMEPart]string
+
+// mimeReader reads the MIME tree of an email along with the content of its
+// parts
+type mimeReader struct {
+	texts mimeTexts // Decoded text parts to show
+
+	// attachment, when set, is handed the decoded content of every
+	// attachment in turn; what is below an attachment is not read
+	attachment func(part *MIMEPart, content io.Reader) error
+	err        error // The first error attachment returned
+}
+
+// readAttachments parses a complete RFC 5322 message the way Parse does and
+// hands read the decoded content of each of its attachments in turn,
+// without holding them in memory. It stops at the first error read returns.
+func readAttachments(raw io.Reader, read func(part *MIMEPart, content io.Reader) error) error {
+	body := bufio.NewReader(raw)
+	header, err := textproto.ReadHeader(body)
+	if err != nil {
+		return fmt.Errorf("failed to parse message: %v", err)
+	}
+	reader := &mimeReader{texts: mimeTexts{}, attachment: read}
+	reader.readMessage(header, body, nil, 0)
+	return reader.err
+}
+
+// readMessage reads the body of a message whose header has been read, and
+// returns its MIME tree
+func (r *mimeReader) readMessage(header textproto.Header, body io.Reader, path []int, depth int) *MIMEPart {
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if !strings.HasPrefix(strings.ToLower(mediaType), "multipart/") {
+		path = childPath(path, 1)
+	}
+	return r.readPart(header, body, path, depth)
+}
+
+// readPart reads a part from its header and still encoded body
+func (r *mimeReader) readPart(header textproto.Header, body io.Reader, path []int, depth int) *MIMEPart {
+	counter := &countingReader{r: body}
+
+	h := message.Header{Header: header}
+	part := &MIMEPart{
+		ContentType: "text/plain", // RFC 2045 section 5.2
+		Params:      map[string]string{},
+		Encoding:    strings.ToLower(strings.TrimSpace(h.Get("Content-Transfer-Encoding"))),
+		Path:        path,
+	}
+	if mediaType, params, err := h.ContentType(); err == nil && mediaType != "" {
+		part.ContentType = strings.ToLower(mediaType)
+		part.Params = params
+	}
+	part.Charset = part.Params["charset"]
+	if disposition, params, err := h.ContentDisposition(); err == nil {
+		part.Disposition = strings.ToLower(disposition)
+		part.Filename = params["filename"]
+	}
+	if part.Filename == "" {
+		part.Filename = part.Params["name"]
+	}
+	decoder := mime.WordDecoder{CharsetReader: message.CharsetReader}
+	if filename, err := decoder.DecodeHeader(part.Filename); err == nil {
+		part.Filename = filename
+	}
+	part.nameAttachment()
+	defer func() {
+		// The rest of the part only counts towards its size
+		io.Copy(io.Discard, counter)
+		part.Size = uint32(counter.n)
+	}()
+
+	switch {
+	case depth >= maxMIMEDepth:
+	case r.attachment != nil && part.IsAttachment():
+		if r.err == nil {
+			r.err = r.attachment(part, transferDecoder(counter, part.Encoding))
+		}
+	case part.IsMultipart():
+		reader := textproto.NewMultipartReader(counter, part.Params["boundary"])
+		for i := 1; ; i++ {
+			child, err := reader.NextPart()
+			if err != nil {
+				// The end, or a broken part that leaves the rest unreadable
+				break
+			}
+			part.Children = append(part.Children, r.readPart(child.Header, child, childPath(path, i), depth+1))
+		}
+	case part.ContentType == "message/rfc822":
+		// Attached emails are not encoded (RFC 2046 section 5.2.1)
+		reader := bufio.NewReader(counter)
+		if inner, err := textproto.ReadHeader(reader); err == nil {
+			part.Children = append(part.Children, r.readMessage(inner, reader, path, depth+1))
+		}
+	case strings.HasPrefix(part.ContentType, "text/") && !part.IsAttachment():
+		// Decodes the transfer encoding and, where it can, the charset
+		// Content in an unknown encoding or charset is shown as it is
+		entity, _ := message.New(h, counter)
+		text := readContent(entity.Body)
+		if part.Charset == "" && !utf8.ValidString(text) {
+			// Without a charset parameter go-message leaves the bytes be
+			text = decodeCharset(htmlMetaCharset(part.ContentType, text), []byte(text))
+		}
+		r.texts[part] = text
+	}
+	return part
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
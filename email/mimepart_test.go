@@ -0,0 +1,132 @@
+package email
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// nestedTestMessage is an email with text alternatives, an attachment and
+// a forwarded email that has an attachment of its own
+const nestedTestMessage = "From: Alice <alice@example.com>\r\n" +
+	"To: me@example.com\r\n" +
+	"Subject: Fwd: Photos\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=alt\r\n" +
+	"\r\n" +
+	"--alt\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"See below\r\n" +
+	"--alt\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<p>See below</p>\r\n" +
+	"--alt--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/pdf; name=\"report.pdf\"\r\n" +
+	"Content-Disposition: attachment; filename=\"report.pdf\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"JVBERi0xLjQK\r\n" +
+	"--outer\r\n" +
+	"Content-Type: message/rfc822\r\n" +
+	"\r\n" +
+	"From: Bob <bob@example.com>\r\n" +
+	"Subject: Photos\r\n" +
+	"Content-Type: multipart/mixed; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"Here they are\r\n" +
+	"--inner\r\n" +
+	"Content-Type: image/png\r\n" +
+	"Content-Disposition: attachment; filename=\"=?utf-8?q?caf=C3=A9.png?=\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"iVBORw0KGgo=\r\n" +
+	"--inner--\r\n" +
+	"\r\n" +
+	"--outer--\r\n"
+
+// describeParts lists the section, content type and file name of every part
+func describeParts(root *MIMEPart) []string {
+	var parts []string
+	root.Walk(func(part *MIMEPart) bool {
+		section := part.Section()
+		if len(part.Path) == 0 {
+			section = "-"
+		}
+		parts = append(parts, strings.TrimSpace(fmt.Sprintf("%s %s %s", section, part.ContentType, part.Filename)))
+		return true
+	})
+	return parts
+}
+
+func TestParseMIMETree(t *testing.T) {
+	email := &IncomingMessage{}
+	if err := email.parseRaw([]byte(nestedTestMessage)); err != nil {
+		t.Fatalf("parseRaw returned error: %v", err)
+	}
+
+	expected := []string{
+		"- multipart/mixed",
+		"1 multipart/alternative",
+		"1.1 text/plain",
+		"1.2 text/html",
+		"2 application/pdf report.pdf",
+		"3 message/rfc822 message.eml",
+		"3 multipart/mixed",
+		"3.1 text/plain",
+		"3.2 image/png café.png",
+	}
+	if got := describeParts(email.Parts); strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected parts:\n%s\n\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+
+	// The forwarded email is one attachment, its photo stays inside it
+	if strings.Join(email.Attachments, ",") != "report.pdf,message.eml" {
+		t.Errorf("Expected report.pdf and message.eml, got %v", email.Attachments)
+	}
+	if email.Body != "See below" || email.HTMLBody != "<p>See below</p>" || email.HTMLOnly {
+		t.Errorf("Expected the plain and HTML alternatives, got %q and %q", email.Body, email.HTMLBody)
+	}
+	if html := email.Parts.Find("text/html"); html == nil || html.Charset != "utf-8" {
+		t.Errorf("Expected the utf-8 HTML part, got %+v", html)
+	}
+
+	pdf := email.AttachmentParts()[0]
+	if pdf.Encoding != "base64" || pdf.Size != uint32(len("JVBERi0xLjQK")) || pdf.Disposition != "attachment" {
+		t.Errorf("Expected the encoded size of a base64 attachment, got %+v", pdf)
+	}
+}
+
+func TestParseManyParts(t *testing.T) {
+	var raw strings.Builder
+	raw.WriteString("Subject: Scans\r\nContent-Type: multipart/mixed; boundary=b\r\n\r\n")
+	for i := 1; i <= 30; i++ {
+		fmt.Fprintf(&raw, "--b\r\nContent-Type: image/jpeg\r\nContent-Disposition: attachment; filename=scan%d.jpg\r\n\r\nx\r\n", i)
+	}
+	raw.WriteString("--b--\r\n")
+
+	email := &IncomingMessage{}
+	if err := email.parseRaw([]byte(raw.String())); err != nil {
+		t.Fatalf("parseRaw returned error: %v", err)
+	}
+	if len(email.Attachments) != 30 || email.Attachments[29] != "scan30.jpg" {
+		t.Errorf("Expected all 30 attachments, got %d", len(email.Attachments))
+	}
+}
+
+func TestSinglePartSection(t *testing.T) {
+	email := &IncomingMessage{}
+	if err := email.parseRaw([]byte("Subject: Hi\r\n\r\nHello\r\n")); err != nil {
+		t.Fatalf("parseRaw returned error: %v", err)
+	}
+	if email.Parts.Section() != "1" || email.Parts.ContentType != "text/plain" || email.Body != "Hello\r\n" {
+		t.Errorf("Expected a single text part in section 1, got %+v", email.Parts)
+	}
+}
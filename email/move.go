@@ -0,0 +1,89 @@
+package email
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-imap"
+)
+
+// MoveMessages moves messages of a mailbox to the dest mailbox
+func (p *GenericIMAPProvider) MoveMessages(mailbox string, uids []uint32, dest string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(uids) == 0 || dest == mailbox {
+		return nil
+	}
+	if err := p.ensureConnected(); err != nil {
+		return err
+	}
+	if _, err := p.selectMailbox(mailbox); err != nil {
+		return err
+	}
+	if err := p.move(uids, dest); err != nil {
+		return err
+	}
+	return p.forget(mailbox, uids)
+}
+
+// Archive moves messages of a mailbox to the archive folder
+func (p *GenericIMAPProvider) Archive(mailbox string, uids []uint32) error {
+	return p.moveToSpecialUse(mailbox, uids, imap.ArchiveAttr)
+}
+
+// moveToSpecialUse moves messages of a mailbox to the folder with the given
+// special use, e.g. \Archive
+func (p *GenericIMAPProvider) moveToSpecialUse(mailbox string, uids []uint32, use string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(uids) == 0 {
+		return nil
+	}
+	if err := p.ensureConnected(); err != nil {
+		return err
+	}
+	mailboxes, err := p.listMailboxes()
+	if err != nil {
+		return err
+	}
+	dest := FindSpecialUse(mailboxes, use)
+	if dest == nil {
+		return fmt.Errorf("the server has no %s folder", strings.TrimPrefix(use, "\\"))
+	}
+	if dest.Name == mailbox {
+		return fmt.Errorf("already in %s", dest.DisplayName())
+	}
+
+	if _, err := p.selectMailbox(mailbox); err != nil {
+		return err
+	}
+	if err := p.move(uids, dest.Name); err != nil {
+		return err
+	}
+	return p.forget(mailbox, uids)
+}
+
+// move moves messages of the selected mailbox to dest, with MOVE when the
+// server has it and otherwise by copying them and expunging the originals
+func (p *GenericIMAPProvider) move(uids []uint32, dest string) error {
+	seqSet := uidSeqSet(uids)
+	if ok, _ := p.client.Support("MOVE"); ok {
+		if err := p.client.UidMove(seqSet, dest); err != nil {
+			return fmt.Errorf("failed to move to %s: %v", dest, err)
+		}
+		return nil
+	}
+
+	if err := p.checkExpunge(uids); err != nil {
+		return err
+	}
+	if err := p.client.UidCopy(seqSet, dest); err != nil {
+		return fmt.Errorf("failed to copy to %s: %v", dest, err)
+	}
+	if err := p.storeFlags(uids, []string{imap.DeletedFlag}, true); err != nil {
+		return err
+	}
+	return p.expunge(uids)
+}
@@ -0,0 +1,81 @@
+package email
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestIMAPProviderMoveMessages(t *testing.T) {
+	useTempCache(t)
+	servers := startTestServers(t)
+	servers.createMailbox(t, "Receipts")
+	servers.createMailbox(t, "Archive")
+	for i := 1; i <= 3; i++ {
+		servers.addMessage(t, "INBOX", fmt.Sprintf("From: alice@example.com\r\nSubject: Message %d\r\n\r\nBody %d\r\n", i, i))
+	}
+
+	provider, err := NewGenericIMAPProvider(servers.config, testCredentials())
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Disconnect()
+
+	emails, err := provider.GetEmailsPage(InboxName, 0, 10)
+	if err != nil {
+		t.Fatalf("GetEmailsPage returned error: %v", err)
+	}
+
+	if err := provider.MoveMessages(InboxName, []uint32{emails[0].UID, emails[1].UID}, "Receipts"); err != nil {
+		t.Fatalf("MoveMessages returned error: %v", err)
+	}
+	if got := len(servers.memoryMailbox(t, "Receipts").Messages); got != 2 {
+		t.Errorf("Expected 2 emails in Receipts, got %d", got)
+	}
+	if got := len(servers.memoryMailbox(t, InboxName).Messages); got != len(emails)-2 {
+		t.Errorf("Expected %d emails left in the inbox, got %d", len(emails)-2, got)
+	}
+	cached, _ := provider.CachedEmails(InboxName, 10)
+	if len(cached) != len(emails)-2 {
+		t.Errorf("Expected the cache to forget the moved emails, got %d emails", len(cached))
+	}
+
+	if err := provider.Archive(InboxName, []uint32{emails[2].UID}); err != nil {
+		t.Fatalf("Archive returned error: %v", err)
+	}
+	if got := len(servers.memoryMailbox(t, "Archive").Messages); got != 1 {
+		t.Errorf("Expected the email in Archive, got %d emails", got)
+	}
+	if err := provider.Archive("Archive", []uint32{1}); err == nil {
+		t.Error("Expected archiving from the archive to fail")
+	}
+}
+
+func TestGmailArchiveMovesToAllMail(t *testing.T) {
+	server, config := startScriptedIMAPServer(t, "IMAP4rev1 MOVE", func(command string) []string {
+		switch {
+		case strings.HasPrefix(command, "LIST"):
+			return []string{
+				`* LIST (\HasNoChildren) "/" "INBOX"`,
+				`* LIST (\HasNoChildren \All) "/" "[Gmail]/All Mail"`,
+			}
+		case strings.HasPrefix(command, "SELECT"):
+			return []string{"* 3 EXISTS", "* OK [UIDVALIDITY 7] UIDs valid"}
+		}
+		return nil
+	})
+
+	generic, err := NewGenericIMAPProvider(config, testCredentials())
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	gmail := &GmailProvider{GenericIMAPProvider: generic}
+	defer gmail.Disconnect()
+
+	if err := gmail.Archive(InboxName, []uint32{2, 3}); err != nil {
+		t.Fatalf("Archive returned error: %v", err)
+	}
+	if got := server.received(`UID MOVE 2:3 "[Gmail]/All Mail"`); len(got) != 1 {
+		t.Errorf("Expected the emails to be moved to All Mail, got %v", server.received("MOVE"))
+	}
+}
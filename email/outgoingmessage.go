@@ -94,6 +94,118 @@ func (msg *OutgoingMessage) SetTextBody(textContent string) {
 	msg.Text = []byte(textContent)
 }
 
+// SetReplyHeaders makes the message a reply to original by setting the
+// In-Reply-To and References headers (RFC 5322 section 3.6.4), so mail
+// clients file it in the same thread
+func (msg *OutgoingMessage) SetReplyHeaders(original *IncomingMessage) {
+	if original.MessageID == "" {
+		return // Nothing to refer to
+	}
+
+	references := original.References
+	if len(references) == 0 && original.InReplyTo != "" {
+		references = []string{original.InReplyTo}
+	}
+	references = append(references[:len(references):len(references)], original.MessageID)
+
+	ids := make([]string, len(references))
+	for i, id := range references {
+		ids[i] = "<" + id + ">"
+	}
+
+	if msg.Headers == nil {
+		msg.Headers = textproto.MIMEHeader{}
+	}
+	msg.Headers.Set("In-Reply-To", "<"+original.MessageID+">")
+	msg.Headers.Set("References", strings.Join(ids, " "))
+}
+
+// ReplyRecipients returns who a reply to original goes to: its Reply-To or
+// sender, and with all also its other recipients, leaving out self. A
+// reply to one's own email goes to that email's recipients instead.
+func ReplyRecipients(original *IncomingMessage, self string, all bool) (to, cc []string) {
+	seen := map[string]bool{}
+	if addr := addressOf(self); addr != "" {
+		seen[addr] = true
+	}
+	add := func(list []string, field string) []string {
+		for _, recipient := range SplitAddresses(field) {
+			addr := addressOf(recipient)
+			if addr == "" || seen[addr] {
+				continue
+			}
+			seen[addr] = true
+			list = append(list, recipient)
+		}
+		return list
+	}
+
+	sender := original.ReplyTo
+	if sender == "" {
+		sender = original.From
+	}
+	to = add(to, sender)
+	if all || len(to) == 0 {
+		to = add(to, original.To)
+	}
+	if all {
+		cc = add(cc, original.Cc)
+	}
+	return to, cc
+}
+
+// SplitAddresses splits a comma separated list of addresses, keeping
+// quoted names with commas such as "Smith, Bob" <bob@example.com> whole
+func SplitAddresses(list string) []string {
+	if strings.TrimSpace(list) == "" {
+		return nil
+	}
+	addresses, err := mail.ParseAddressList(list)
+	if err != nil {
+		// Leave addresses the parser rejects for sending to complain about
+		return sanitizeAddresses(strings.Split(list, ","))
+	}
+	recipients := make([]string, len(addresses))
+	for i, addr := range addresses {
+		recipients[i] = formatRecipient(addr)
+	}
+	return recipients
+}
+
+// formatRecipient formats an address for an address field, without the
+// MIME encoding net/mail applies to names
+func formatRecipient(addr *mail.Address) string {
+	if addr.Name == "" {
+		return addr.Address
+	}
+	name := addr.Name
+	if strings.ContainsAny(name, `,;:"<>@()[]\`) {
+		name = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name) + `"`
+	}
+	return name + " <" + addr.Address + ">"
+}
+
+// addressOf returns the lowercased address of a recipient such as
+// "Alice <alice@example.com>", or "" if it has none
+func addressOf(recipient string) string {
+	addr, err := mail.ParseAddress(recipient)
+	if err != nil {
+		return strings.ToLower(strings.Trim(strings.TrimSpace(recipient), "<>"))
+	}
+	return strings.ToLower(addr.Address)
+}
+
+// AttachContent attaches content held in memory, such as an attachment of
+// a forwarded email
+func (msg *OutgoingMessage) AttachContent(filename, contentType string, content []byte) {
+	msg.Attachments = append(msg.Attachments, &Attachment{
+		Filename:    filename,
+		ContentType: contentType,
+		Header:      textproto.MIMEHeader{},
+		Content:     content,
+	})
+}
+
 // AppendAttachmentPath appends the path for the attachment to the emsil.
 func (msg *OutgoingMessage) AppendAttachmentPath(path string) error {
 	// Check if file exists and is readable
@@ -108,29 +220,47 @@ func (msg *OutgoingMessage) AppendAttachmentPath(path string) error {
 // Send an email using the given host and SMTP auth (optional), returns any error thrown by smtp.SendMail
 // This function merges the To, Cc, and Bcc fields and calls the smtp.SendMail function using the Email.Bytes() output as the message
 func (msg *OutgoingMessage) SendMessage(addr string, a smtp.Auth) error {
+	sender, to, raw, err := msg.envelope()
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(addr, a, sender, to, raw)
+}
+
+// deliver sends the message through the SMTP server described by config
+func (msg *OutgoingMessage) deliver(config *Config, a smtp.Auth) error {
+	sender, to, raw, err := msg.envelope()
+	if err != nil {
+		return err
+	}
+	return sendMail(config, a, sender, to, raw)
+}
+
+// envelope returns the SMTP sender, the merged To, Cc and Bcc recipients and the encoded message
+func (msg *OutgoingMessage) envelope() (string, []string, []byte, error) {
 	// Merge the To, Cc, and Bcc fields
 	to := make([]string, 0, len(msg.To)+len(msg.Cc)+len(msg.Bcc))
 	to = append(append(append(to, msg.To...), msg.Cc...), msg.Bcc...)
 	for i := 0; i < len(to); i++ {
 		addr, err := mail.ParseAddress(to[i])
 		if err != nil {
-			return err
+			return "", nil, nil, err
 		}
 		to[i] = addr.Address
 	}
 	// Check to make sure there is at least one recipient and one "From" address
 	if msg.From == "" || len(to) == 0 {
-		return errors.New("Must specify at least one From address and one To address")
+		return "", nil, nil, errors.New("Must specify at least one From address and one To address")
 	}
 	sender, err := msg.parseSender()
 	if err != nil {
-		return err
+		return "", nil, nil, err
 	}
 	raw, err := msg.ConvertToBytes()
 	if err != nil {
-		return err
+		return "", nil, nil, err
 	}
-	return smtp.SendMail(addr, a, sender, to, raw)
+	return sender, to, raw, nil
 }
 
 // The function will return the created Attachment for reference, as well as nil for the error.
@@ -234,8 +364,17 @@ func (msg *OutgoingMessage) ConvertToBytes() ([]byte, error) {
 		if err != nil {
 			return nil, err
 		}
-		// Write the base64Wrapped content to the part
-		base64Encode(p, a.Content)
+		switch a.Header.Get("Content-Transfer-Encoding") {
+		case "7bit", "8bit":
+			// Attached emails go as they are (RFC 2046 section 5.2.1)
+			p.Write(a.Content)
+			if !bytes.HasSuffix(a.Content, []byte("\r\n")) {
+				io.WriteString(p, "\r\n")
+			}
+		default:
+			// Write the base64Wrapped content to the part
+			base64Encode(p, a.Content)
+		}
 	}
 	if hasAttachments {
 		if err := w.Close(); err != nil {
@@ -307,7 +446,18 @@ func (attach *Attachment) setDefaultHeaders() {
 		attach.Header.Set("Content-ID", fmt.Sprintf("<%s>", attach.Filename))
 	}
 	if len(attach.Header.Get("Content-Transfer-Encoding")) == 0 {
-		attach.Header.Set("Content-Transfer-Encoding", "base64")
+		encoding := "base64"
+		if strings.HasPrefix(contentType, "message/") {
+			// Emails must not be base64 encoded when attached
+			encoding = "7bit"
+			for _, b := range attach.Content {
+				if b >= 0x80 {
+					encoding = "8bit"
+					break
+				}
+			}
+		}
+		attach.Header.Set("Content-Transfer-Encoding", encoding)
 	}
 }
 
@@ -344,6 +494,10 @@ func headerToBytes(buff io.Writer, header textproto.MIMEHeader) {
 			switch {
 			case field == "Content-Type" || field == "Content-Disposition":
 				buff.Write([]byte(subval))
+			case field == "In-Reply-To" || field == "References":
+				// Message IDs are ASCII; one per line keeps long threads
+				// under the line length limit
+				buff.Write([]byte(strings.Join(strings.Fields(subval), "\r\n ")))
 			case field == "From" || field == "To" || field == "Cc" || field == "Bcc":
 				participants := strings.Split(subval, ",")
 				for i, v := range participants {
@@ -62,3 +62,97 @@ func TestNewOutgoingMessage(t *testing.T) {
 		t.Errorf("Expected Body to be empty, got %s", msg.Text)
 	}
 }
+
+func TestSetReplyHeaders(t *testing.T) {
+	msg := &OutgoingMessage{From: "me@example.com", To: []string{"alice@example.com"}, Subject: "Re: Lunch"}
+	msg.SetReplyHeaders(&IncomingMessage{
+		MessageID:  "c@example.com",
+		InReplyTo:  "b@example.com",
+		References: []string{"a@example.com", "b@example.com"},
+	})
+
+	if got := msg.Headers.Get("In-Reply-To"); got != "<c@example.com>" {
+		t.Errorf("Expected In-Reply-To <c@example.com>, got %q", got)
+	}
+	if got := msg.Headers.Get("References"); got != "<a@example.com> <b@example.com> <c@example.com>" {
+		t.Errorf("Expected the references to end with the original, got %q", got)
+	}
+
+	raw, err := msg.ConvertToBytes()
+	if err != nil {
+		t.Fatalf("ConvertToBytes returned error: %v", err)
+	}
+	if !strings.Contains(string(raw), "References: <a@example.com>\r\n <b@example.com>\r\n <c@example.com>\r\n") {
+		t.Errorf("Expected a folded References header, got %q", raw)
+	}
+	if !strings.Contains(string(raw), "In-Reply-To: <c@example.com>\r\n") {
+		t.Errorf("Expected an In-Reply-To header, got %q", raw)
+	}
+
+	// Without references of its own, the original's parent starts the list
+	msg = &OutgoingMessage{}
+	msg.SetReplyHeaders(&IncomingMessage{MessageID: "b@example.com", InReplyTo: "a@example.com"})
+	if got := msg.Headers.Get("References"); got != "<a@example.com> <b@example.com>" {
+		t.Errorf("Expected In-Reply-To to stand in for References, got %q", got)
+	}
+
+	// An original without a Message-ID leaves the headers alone
+	msg = &OutgoingMessage{}
+	msg.SetReplyHeaders(&IncomingMessage{})
+	if msg.Headers != nil {
+		t.Errorf("Expected no headers, got %v", msg.Headers)
+	}
+}
+
+func TestReplyRecipients(t *testing.T) {
+	original := &IncomingMessage{
+		From: "Alice <alice@example.com>",
+		To:   `"Smith, Bob" <bob@example.com>, Me <ME@example.com>`,
+		Cc:   "carol@example.com, alice@example.com",
+	}
+
+	to, cc := ReplyRecipients(original, "me@example.com", false)
+	if len(to) != 1 || to[0] != "Alice <alice@example.com>" || len(cc) != 0 {
+		t.Errorf("Expected a reply to Alice only, got %v and %v", to, cc)
+	}
+
+	// Reply-all leaves out ourselves and anyone already addressed
+	to, cc = ReplyRecipients(original, "me@example.com", true)
+	if len(to) != 2 || to[1] != `"Smith, Bob" <bob@example.com>` {
+		t.Errorf("Expected Alice and Bob, got %v", to)
+	}
+	if len(cc) != 1 || cc[0] != "carol@example.com" {
+		t.Errorf("Expected Carol on Cc, got %v", cc)
+	}
+
+	// Reply-To takes the place of the sender
+	original.ReplyTo = "list@example.com"
+	if to, _ := ReplyRecipients(original, "me@example.com", false); len(to) != 1 || to[0] != "list@example.com" {
+		t.Errorf("Expected a reply to the Reply-To address, got %v", to)
+	}
+
+	// A reply to our own email goes to whoever it was sent to
+	sent := &IncomingMessage{From: "Me <me@example.com>", To: "dave@example.com"}
+	if to, _ := ReplyRecipients(sent, "me@example.com", false); len(to) != 1 || to[0] != "dave@example.com" {
+		t.Errorf("Expected a reply to Dave, got %v", to)
+	}
+}
+
+func TestAttachForwardedMessage(t *testing.T) {
+	msg := &OutgoingMessage{From: "me@example.com", To: []string{"alice@example.com"}, Subject: "Fwd: Lunch"}
+	msg.SetTextBody("See below")
+	original := "From: bob@example.com\r\nSubject: Lunch\r\n\r\nNoon?\r\n"
+	msg.AttachContent("Lunch.eml", "message/rfc822", []byte(original))
+
+	raw, err := msg.ConvertToBytes()
+	if err != nil {
+		t.Fatalf("ConvertToBytes returned error: %v", err)
+	}
+	if !strings.Contains(string(raw), "Content-Type: message/rfc822") || !strings.Contains(string(raw), "Content-Transfer-Encoding: 7bit") {
+		t.Errorf("Expected a 7bit message/rfc822 part, got %q", raw)
+	}
+	// The attached email must be readable as it is, not base64 encoded
+	if !strings.Contains(string(raw), original) {
+		t.Errorf("Expected the original email unencoded, got %q", raw)
+	}
+}
@@ -1,6 +1,8 @@
 package email
 
 import (
+	"fmt"
+	"io"
 	"log"
 	"sync"
 
@@ -20,31 +22,95 @@ type MailProvider interface {
 	QuickSend(to, subject, body string) error
 	GetEmails(limit int) ([]*IncomingMessage, error)
 	GetUserInfo() (auth.Credentials, error)
+
+	// Mailboxes
+	ListMailboxes() ([]*Mailbox, error)
+	MailboxStatus(name string) (*MailboxStatus, error)
+	GetMailboxEmails(mailbox string, limit int) ([]*IncomingMessage, error)
+	GetEmailsPage(mailbox string, beforeUID uint32, n int) ([]*IncomingMessage, error)
+	FetchMessage(email *IncomingMessage) (*IncomingMessage, error)
+	FetchRawMessage(email *IncomingMessage) ([]byte, error)
+	FetchPart(email *IncomingMessage, part *MIMEPart) ([]byte, error)
+	WritePart(email *IncomingMessage, part *MIMEPart, w io.Writer) error
+	Search(mailbox string, query *SearchQuery, n int) ([]*IncomingMessage, error)
+	SearchOffline(mailbox string, query *SearchQuery, n int) ([]*IncomingMessage, error)
+	CachedEmails(mailbox string, n int) ([]*IncomingMessage, error)
+	SyncMailbox(mailbox string, n int) (*MailboxChanges, error)
+	WatchMailbox(mailbox string, stop <-chan struct{}, notify func()) error
+
+	// Message operations
+	SetFlags(mailbox string, uids []uint32, flags []string, set bool) error
+	Expunge(mailbox string, uids []uint32) error
+	MoveToTrash(mailbox string, uids []uint32) error
+	MoveMessages(mailbox string, uids []uint32, dest string) error
+	Archive(mailbox string, uids []uint32) error
 }
 
 var (
-	provider MailProvider
-	once     sync.Once
-	initErr  error
+	providers   = map[string]MailProvider{}
+	providersMu sync.Mutex
 )
 
-// CreateDefaultMailProvider creates a mail provider with default configuration
+// CreateDefaultMailProvider returns the mail provider of the active account
 func CreateDefaultMailProvider() (MailProvider, error) {
-	once.Do(func() {
-		userInfo, err := auth.LoadUser()
+	account, err := auth.ActiveAccount()
+	if err != nil {
+		log.Println("Cannot determine active account while creating mail provider")
+		return nil, err
+	}
+	return CreateMailProvider(account)
+}
+
+// CreateMailProvider returns the mail provider of the named account. Providers
+// are created once per account and reused, so switching back and forth
+// between accounts keeps their connections.
+func CreateMailProvider(account string) (MailProvider, error) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+
+	if provider, ok := providers[account]; ok {
+		return provider, nil
+	}
+
+	userInfo, err := auth.LoadAccount(account)
+	if err != nil {
+		log.Println("Cannot load user while creating mail provider")
+		return nil, err
+	}
+	userConfig, err := LoadUserConfig()
+	if err != nil {
+		log.Println("Cannot load user config, using defaults")
+	}
+
+	provider, err := NewMailProvider(userConfig.Account(account), userInfo)
+	if err != nil {
+		log.Println("Cannot get Default Mail Provider")
+		return nil, err
+	}
+
+	providers[account] = provider
+	return provider, nil
+}
+
+// NewMailProvider creates the provider selected by the account settings
+func NewMailProvider(account AccountConfig, userInfo auth.Credentials) (MailProvider, error) {
+	switch account.Provider {
+	case "", ProviderGmail:
+		gmail, err := NewGmailProvider(DefaultConfig, userInfo)
 		if err != nil {
-			log.Println("Cannot load user while creating mail provider")
-			initErr = err
-			return
+			return nil, err
+		}
+		return gmail, nil
+	case ProviderIMAP:
+		if account.Server == nil {
+			return nil, fmt.Errorf("provider %q needs server settings, see: tmail config set imap_host", ProviderIMAP)
 		}
-		provider, err = NewGmailProvider(DefaultConfig, userInfo)
+		generic, err := NewGenericIMAPProvider(*account.Server, userInfo)
 		if err != nil {
-			log.Println("Cannot get Default Mail Provider")
-			initErr = err
-			return
+			return nil, err
 		}
-		initErr = nil
-	})
-	// Create provider with default config
-	return provider, initErr
+		return generic, nil
+	default:
+		return nil, fmt.Errorf("unknown mail provider %q", account.Provider)
+	}
 }
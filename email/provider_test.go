@@ -1,7 +1,8 @@
 package email
 
 import (
-	"sync"
+	"io"
+	"strings"
 	"testing"
 
 	"github.com/jacobbanks/tmail/auth"
@@ -87,6 +88,107 @@ func (m *MockProvider) GetUserInfo() (auth.Credentials, error) {
 	return m.userInfo, nil
 }
 
+func (m *MockProvider) ListMailboxes() ([]*Mailbox, error) {
+	return []*Mailbox{{Name: InboxName, Delimiter: "/"}}, nil
+}
+
+func (m *MockProvider) MailboxStatus(name string) (*MailboxStatus, error) {
+	return &MailboxStatus{Name: name, Messages: uint32(len(m.storedEmails))}, nil
+}
+
+func (m *MockProvider) GetMailboxEmails(mailbox string, limit int) ([]*IncomingMessage, error) {
+	return m.GetEmails(limit)
+}
+
+func (m *MockProvider) GetEmailsPage(mailbox string, beforeUID uint32, n int) ([]*IncomingMessage, error) {
+	return m.GetEmails(n)
+}
+
+func (m *MockProvider) CachedEmails(mailbox string, n int) ([]*IncomingMessage, error) {
+	return nil, nil
+}
+
+func (m *MockProvider) SyncMailbox(mailbox string, n int) (*MailboxChanges, error) {
+	return &MailboxChanges{Mailbox: mailbox}, nil
+}
+
+func (m *MockProvider) WatchMailbox(mailbox string, stop <-chan struct{}, notify func()) error {
+	<-stop
+	return nil
+}
+
+func (m *MockProvider) SetFlags(mailbox string, uids []uint32, flags []string, set bool) error {
+	changed := uidSet(uids)
+	for _, email := range m.storedEmails {
+		if changed[email.UID] {
+			for _, flag := range flags {
+				email.SetFlag(flag, set)
+			}
+		}
+	}
+	return nil
+}
+
+func (m *MockProvider) Expunge(mailbox string, uids []uint32) error {
+	gone := uidSet(uids)
+	var kept []*IncomingMessage
+	for _, email := range m.storedEmails {
+		if !gone[email.UID] {
+			kept = append(kept, email)
+		}
+	}
+	m.storedEmails = kept
+	return nil
+}
+
+func (m *MockProvider) MoveToTrash(mailbox string, uids []uint32) error {
+	return m.Expunge(mailbox, uids)
+}
+
+func (m *MockProvider) MoveMessages(mailbox string, uids []uint32, dest string) error {
+	return m.Expunge(mailbox, uids)
+}
+
+func (m *MockProvider) Archive(mailbox string, uids []uint32) error {
+	return m.Expunge(mailbox, uids)
+}
+
+func (m *MockProvider) Search(mailbox string, query *SearchQuery, n int) ([]*IncomingMessage, error) {
+	var found []*IncomingMessage
+	for _, email := range m.storedEmails {
+		for _, text := range query.Text {
+			if strings.Contains(email.Subject, text) || strings.Contains(email.Body, text) {
+				found = append(found, email)
+				break
+			}
+		}
+	}
+	return found, nil
+}
+
+func (m *MockProvider) SearchOffline(mailbox string, query *SearchQuery, n int) ([]*IncomingMessage, error) {
+	return m.Search(mailbox, query, n)
+}
+
+func (m *MockProvider) FetchMessage(email *IncomingMessage) (*IncomingMessage, error) {
+	full := *email
+	full.BodyLoaded = true
+	return &full, nil
+}
+
+func (m *MockProvider) FetchRawMessage(email *IncomingMessage) ([]byte, error) {
+	return []byte("Subject: " + email.Subject + "\r\n\r\n" + email.Body), nil
+}
+
+func (m *MockProvider) FetchPart(email *IncomingMessage, part *MIMEPart) ([]byte, error) {
+	return []byte("content of " + part.Filename), nil
+}
+
+func (m *MockProvider) WritePart(email *IncomingMessage, part *MIMEPart, w io.Writer) error {
+	_, err := io.WriteString(w, "content of "+part.Filename)
+	return err
+}
+
 // Tests for the MailProvider interface
 func TestMailProviderInterface(t *testing.T) {
 	// Test that MockProvider implements MailProvider
@@ -173,10 +275,8 @@ func TestProviderEmailOperations(t *testing.T) {
 
 // Test for CreateDefaultMailProvider using dependency injection
 func TestCreateDefaultMailProvider(t *testing.T) {
-	// Reset the singleton provider for this test
-	provider = nil
-	initErr = nil
-	once = *new(sync.Once)
+	// Reset the provider cache for this test
+	providers = map[string]MailProvider{}
 
 	// Create a provider
 	p, err := CreateDefaultMailProvider()
@@ -210,3 +310,17 @@ func TestGmailProviderNoCredentials(t *testing.T) {
 		t.Error("NewGmailProvider should return error with empty credentials")
 	}
 }
+
+func TestUserConfigAccountFallback(t *testing.T) {
+	shared := &Config{IMAPHost: "imap.example.com", IMAPPort: "993", SMTPHost: "smtp.example.com", SMTPPort: "465"}
+	config := UserConfig{Provider: ProviderIMAP, Server: shared}
+	config.SetAccount("work", AccountConfig{Provider: ProviderGmail})
+
+	if account := config.Account("work"); account.Provider != ProviderGmail {
+		t.Errorf("Expected work account to use gmail, got %s", account.Provider)
+	}
+	account := config.Account("personal")
+	if account.Provider != ProviderIMAP || account.Server != shared {
+		t.Errorf("Expected personal account to fall back to top-level settings, got %+v", account)
+	}
+}
@@ -0,0 +1,279 @@
+package email
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/responses"
+)
+
+// searchDateLayouts are the date formats accepted by since: and before:
+var searchDateLayouts = []string{"2006-01-02", "2006/01/02"}
+
+// SearchQuery is a parsed search, written in a small Gmail-like language:
+//
+//	from:alice to:bob subject:"lunch plans" body:invoice since:2024-01-31
+//	before:2024-03-01 has:attachment is:unread msgid:id@example.com free text
+//
+// Values with spaces are quoted phrases, and a trailing * makes the last
+// word a prefix, e.g. subject:budg*. Terms are ANDed together.
+type SearchQuery struct {
+	From          []string
+	To            []string
+	Subject       []string
+	Body          []string
+	Text          []string // Free text, matched anywhere in the message
+	Since         time.Time
+	Before        time.Time
+	HasAttachment bool
+	Unread        bool
+	Read          bool
+	Starred       bool
+	MessageID     string // Message-ID header, without its angle brackets
+
+	terms []string // The terms as written, for servers with their own search language
+}
+
+// ParseSearchQuery parses a search query. Words that are not one of the
+// known terms are searched as free text.
+func ParseSearchQuery(query string) (*SearchQuery, error) {
+	terms, err := splitSearchTerms(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("empty search")
+	}
+
+	q := &SearchQuery{terms: terms}
+	for _, term := range terms {
+		key, value, ok := strings.Cut(term, ":")
+		if !ok || value == "" {
+			q.Text = append(q.Text, term)
+			continue
+		}
+
+		switch strings.ToLower(key) {
+		case "from":
+			q.From = append(q.From, value)
+		case "to":
+			q.To = append(q.To, value)
+		case "subject":
+			q.Subject = append(q.Subject, value)
+		case "body":
+			q.Body = append(q.Body, value)
+		case "since", "after":
+			if q.Since, err = parseSearchDate(value); err != nil {
+				return nil, err
+			}
+		case "before":
+			if q.Before, err = parseSearchDate(value); err != nil {
+				return nil, err
+			}
+		case "has":
+			if !strings.EqualFold(value, "attachment") {
+				return nil, fmt.Errorf("unknown search term %q, expected has:attachment", term)
+			}
+			q.HasAttachment = true
+		case "msgid":
+			q.MessageID = strings.Trim(value, "<>")
+		case "is":
+			switch strings.ToLower(value) {
+			case "unread":
+				q.Unread = true
+			case "read":
+				q.Read = true
+			case "starred", "flagged":
+				q.Starred = true
+			default:
+				return nil, fmt.Errorf("unknown search term %q, expected is:unread, is:read or is:starred", term)
+			}
+		default:
+			q.Text = append(q.Text, term)
+		}
+	}
+	return q, nil
+}
+
+// splitSearchTerms splits a query on spaces, keeping quoted values together
+// and dropping their quotes
+func splitSearchTerms(query string) ([]string, error) {
+	var terms []string
+	var term strings.Builder
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ' ' && !quoted:
+			if term.Len() > 0 {
+				terms = append(terms, term.String())
+				term.Reset()
+			}
+		default:
+			term.WriteRune(r)
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote in search")
+	}
+	if term.Len() > 0 {
+		terms = append(terms, term.String())
+	}
+	return terms, nil
+}
+
+// QuoteSearchTerm quotes a term holding spaces so it stays one term, e.g.
+// subject:lunch plans becomes subject:"lunch plans"
+func QuoteSearchTerm(term string) string {
+	if !strings.Contains(term, " ") || strings.Contains(term, `"`) {
+		return term
+	}
+	if key, value, ok := strings.Cut(term, ":"); ok && !strings.Contains(key, " ") {
+		return key + `:"` + value + `"`
+	}
+	return `"` + term + `"`
+}
+
+// parseSearchDate parses the date of a since: or before: term
+func parseSearchDate(value string) (time.Time, error) {
+	for _, layout := range searchDateLayouts {
+		if date, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", value)
+}
+
+// criteria converts the query into IMAP SEARCH criteria
+func (q *SearchQuery) criteria() *imap.SearchCriteria {
+	criteria := imap.NewSearchCriteria()
+	// IMAP matches substrings, so prefixes need no *
+	for _, from := range q.From {
+		criteria.Header.Add("From", strings.TrimSuffix(from, "*"))
+	}
+	for _, to := range q.To {
+		criteria.Header.Add("To", strings.TrimSuffix(to, "*"))
+	}
+	for _, subject := range q.Subject {
+		criteria.Header.Add("Subject", strings.TrimSuffix(subject, "*"))
+	}
+	for _, body := range q.Body {
+		criteria.Body = append(criteria.Body, strings.TrimSuffix(body, "*"))
+	}
+	for _, text := range q.Text {
+		criteria.Text = append(criteria.Text, strings.TrimSuffix(text, "*"))
+	}
+	criteria.Since = q.Since
+	criteria.Before = q.Before
+	if q.HasAttachment {
+		// IMAP cannot search for attachments; nearly every message with
+		// one is multipart/mixed
+		criteria.Header.Add("Content-Type", "multipart/mixed")
+	}
+	if q.MessageID != "" {
+		criteria.Header.Add("Message-Id", q.MessageID)
+	}
+	if q.Unread {
+		criteria.WithoutFlags = append(criteria.WithoutFlags, imap.SeenFlag)
+	}
+	if q.Read {
+		criteria.WithFlags = append(criteria.WithFlags, imap.SeenFlag)
+	}
+	if q.Starred {
+		criteria.WithFlags = append(criteria.WithFlags, imap.FlaggedFlag)
+	}
+	return criteria
+}
+
+// gmailQuery returns the query in Gmail's search language, which calls
+// since: after:, has no body: and matches word prefixes on its own
+func (q *SearchQuery) gmailQuery() string {
+	terms := make([]string, len(q.terms))
+	for i, term := range q.terms {
+		term = strings.TrimSuffix(term, "*")
+		if key, value, ok := strings.Cut(term, ":"); ok {
+			switch strings.ToLower(key) {
+			case "since":
+				term = "after:" + value
+			case "body":
+				term = value
+			case "msgid":
+				term = "rfc822msgid:" + strings.Trim(value, "<>")
+			}
+		}
+		terms[i] = QuoteSearchTerm(term)
+	}
+	return strings.Join(terms, " ")
+}
+
+// Search returns up to n of the newest emails of a mailbox matching the
+// query, newest first
+func (p *GenericIMAPProvider) Search(mailbox string, query *SearchQuery, n int) ([]*IncomingMessage, error) {
+	return p.search(mailbox, n, func() ([]uint32, error) {
+		return p.client.UidSearch(query.criteria())
+	})
+}
+
+// search runs a search in a mailbox with the given UID SEARCH and fetches
+// the newest n results
+func (p *GenericIMAPProvider) search(mailbox string, n int, uidSearch func() ([]uint32, error)) ([]*IncomingMessage, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if n <= 0 {
+		n = 10 // Default to 10 emails
+	}
+	if err := p.ensureConnected(); err != nil {
+		return nil, err
+	}
+	status, err := p.selectMailbox(mailbox)
+	if err != nil {
+		return nil, err
+	}
+
+	uids, err := uidSearch()
+	if err != nil {
+		return nil, fmt.Errorf("search failed: %v", err)
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	if len(uids) > n {
+		uids = uids[len(uids)-n:]
+	}
+
+	emails, _, err := p.fetchSummaries(mailbox, status.UidValidity, uids)
+	return emails, err
+}
+
+// uidSearchGmailRaw is a UID SEARCH with Gmail's X-GM-RAW criterion, which
+// takes a query in Gmail's own search language
+type uidSearchGmailRaw struct {
+	Query string
+}
+
+func (cmd *uidSearchGmailRaw) Command() *imap.Command {
+	return &imap.Command{
+		Name: "UID",
+		Arguments: []interface{}{
+			imap.RawString("SEARCH"),
+			imap.RawString("CHARSET"), imap.RawString("UTF-8"),
+			imap.RawString("X-GM-RAW"), cmd.Query,
+		},
+	}
+}
+
+// gmailRawSearch runs a UID SEARCH X-GM-RAW in the selected mailbox
+func (p *GenericIMAPProvider) gmailRawSearch(query string) ([]uint32, error) {
+	res := &responses.Search{}
+	status, err := p.client.Execute(&uidSearchGmailRaw{Query: query}, res)
+	if err == nil {
+		err = status.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return res.Ids, nil
+}
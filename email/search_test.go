@@ -0,0 +1,155 @@
+package email
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	q, err := ParseSearchQuery(`from:alice subject:"lunch plans" since:2024-01-31 before:2024/03/01 has:attachment is:unread budget http://example.com`)
+	if err != nil {
+		t.Fatalf("ParseSearchQuery returned error: %v", err)
+	}
+
+	if len(q.From) != 1 || q.From[0] != "alice" {
+		t.Errorf("Expected from alice, got %v", q.From)
+	}
+	if len(q.Subject) != 1 || q.Subject[0] != "lunch plans" {
+		t.Errorf("Expected the quoted subject, got %v", q.Subject)
+	}
+	if !q.Since.Equal(time.Date(2024, 1, 31, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("Expected since 2024-01-31, got %v", q.Since)
+	}
+	if !q.Before.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("Expected before 2024-03-01, got %v", q.Before)
+	}
+	if !q.HasAttachment || !q.Unread {
+		t.Errorf("Expected has:attachment and is:unread, got %+v", q)
+	}
+	if len(q.Text) != 2 || q.Text[0] != "budget" || q.Text[1] != "http://example.com" {
+		t.Errorf("Expected the rest as free text, got %v", q.Text)
+	}
+}
+
+func TestParseSearchQueryErrors(t *testing.T) {
+	for _, query := range []string{"", "   ", "since:yesterday", "has:pdf", "is:important", `subject:"open`} {
+		if _, err := ParseSearchQuery(query); err == nil {
+			t.Errorf("Expected %q to be rejected", query)
+		}
+	}
+}
+
+func TestSearchQueryCriteria(t *testing.T) {
+	q, _ := ParseSearchQuery("from:alice is:unread is:starred report")
+	criteria := q.criteria()
+
+	if criteria.Header.Get("From") != "alice" {
+		t.Errorf("Expected a From header criterion, got %v", criteria.Header)
+	}
+	if len(criteria.WithoutFlags) != 1 || criteria.WithoutFlags[0] != imap.SeenFlag {
+		t.Errorf("Expected unread to exclude \\Seen, got %v", criteria.WithoutFlags)
+	}
+	if len(criteria.WithFlags) != 1 || criteria.WithFlags[0] != imap.FlaggedFlag {
+		t.Errorf("Expected starred to require \\Flagged, got %v", criteria.WithFlags)
+	}
+	if len(criteria.Text) != 1 || criteria.Text[0] != "report" {
+		t.Errorf("Expected a TEXT criterion, got %v", criteria.Text)
+	}
+}
+
+func TestSearchQueryMessageID(t *testing.T) {
+	q, _ := ParseSearchQuery("msgid:<abc.123@mail.example.com>")
+	if q.MessageID != "abc.123@mail.example.com" {
+		t.Errorf("Expected the Message-ID without brackets, got %q", q.MessageID)
+	}
+	if got := q.criteria().Header.Get("Message-Id"); got != "abc.123@mail.example.com" {
+		t.Errorf("Expected a Message-Id header criterion, got %q", got)
+	}
+}
+
+func TestGmailQuery(t *testing.T) {
+	q, _ := ParseSearchQuery(`from:alice subject:"lunch plans" since:2024-01-31 "exact phrase" msgid:<a@b>`)
+	expected := `from:alice subject:"lunch plans" after:2024-01-31 "exact phrase" rfc822msgid:a@b`
+	if got := q.gmailQuery(); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestIMAPProviderSearch(t *testing.T) {
+	servers := startTestServers(t)
+	servers.addMessage(t, "INBOX", "From: alice@example.com\r\nSubject: Budget\r\n\r\nNumbers inside\r\n")
+	servers.addMessage(t, "INBOX", "From: bob@example.com\r\nSubject: Lunch\r\n\r\nPizza?\r\n")
+	servers.addMessage(t, "INBOX", "From: alice@example.com\r\nSubject: Lunch again\r\nMessage-Id: <tacos@example.com>\r\n\r\nTacos?\r\n")
+
+	provider, err := NewGenericIMAPProvider(servers.config, testCredentials())
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Disconnect()
+
+	q, _ := ParseSearchQuery("from:alice subject:lunch")
+	emails, err := provider.Search(InboxName, q, 10)
+	if err != nil {
+		t.Fatalf("Search returned error: %v", err)
+	}
+	if len(emails) != 1 || emails[0].Subject != "Lunch again" {
+		t.Fatalf("Expected only \"Lunch again\", got %d emails", len(emails))
+	}
+
+	q, _ = ParseSearchQuery("subject:lunch")
+	emails, err = provider.Search(InboxName, q, 1)
+	if err != nil {
+		t.Fatalf("Search returned error: %v", err)
+	}
+	if len(emails) != 1 || emails[0].Subject != "Lunch again" {
+		t.Errorf("Expected the newest match only, got %d emails", len(emails))
+	}
+
+	q, _ = ParseSearchQuery("msgid:<tacos@example.com>")
+	emails, err = provider.Search(InboxName, q, 10)
+	if err != nil {
+		t.Fatalf("Search returned error: %v", err)
+	}
+	if len(emails) != 1 || emails[0].MessageID != "tacos@example.com" {
+		t.Errorf("Expected the email with that Message-ID, got %d emails", len(emails))
+	}
+}
+
+func TestGmailSearchUsesRawQuery(t *testing.T) {
+	server, config := startScriptedIMAPServer(t, "IMAP4rev1 X-GM-EXT-1", func(command string) []string {
+		switch {
+		case strings.HasPrefix(command, "SELECT"):
+			return []string{"* 3 EXISTS", "* OK [UIDVALIDITY 7] UIDs valid"}
+		case strings.Contains(command, "X-GM-RAW"):
+			return []string{"* SEARCH 2 3"}
+		case strings.HasPrefix(command, "UID FETCH"):
+			return []string{scriptedSummary(2, 2, "", 11), scriptedSummary(3, 3, "", 12)}
+		}
+		return nil
+	})
+
+	generic, err := NewGenericIMAPProvider(config, testCredentials())
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	gmail := &GmailProvider{GenericIMAPProvider: generic}
+	defer gmail.Disconnect()
+
+	q, _ := ParseSearchQuery("from:alice since:2024-01-31")
+	emails, err := gmail.Search(InboxName, q, 10)
+	if err != nil {
+		t.Fatalf("Search returned error: %v", err)
+	}
+	if len(emails) != 2 || emails[0].UID != 3 {
+		t.Errorf("Expected UIDs 3 and 2, got %d emails", len(emails))
+	}
+
+	expected := fmt.Sprintf("UID SEARCH CHARSET UTF-8 X-GM-RAW %q", "from:alice after:2024-01-31")
+	if got := server.received("X-GM-RAW"); len(got) != 1 || got[0] != expected {
+		t.Errorf("Expected %q, got %v", expected, got)
+	}
+}
@@ -0,0 +1,363 @@
+package email
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/commands"
+	"github.com/emersion/go-imap/responses"
+)
+
+// fetchModSeq asks CONDSTORE servers for the MODSEQ of each message
+const fetchModSeq imap.FetchItem = "MODSEQ"
+
+// MailboxChanges describes how a mailbox changed on the server since it was
+// last synced
+type MailboxChanges struct {
+	Mailbox     string
+	UIDValidity uint32
+	New         []*IncomingMessage // Messages that arrived, newest first
+	Updated     []*IncomingMessage // Known messages whose flags changed
+	Expunged    []uint32           // UIDs of messages that left the mailbox
+	Reset       bool               // Known messages are void and the list must be reloaded
+}
+
+// Empty reports whether nothing changed
+func (c *MailboxChanges) Empty() bool {
+	return len(c.New) == 0 && len(c.Updated) == 0 && len(c.Expunged) == 0 && !c.Reset
+}
+
+// SyncMailbox brings the cached copy of a mailbox up to date and reports
+// what changed since the previous sync, so an open list can be updated in
+// place. n bounds how many new messages are downloaded before the mailbox
+// is reloaded from scratch instead.
+func (p *GenericIMAPProvider) SyncMailbox(mailbox string, n int) (*MailboxChanges, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if n <= 0 {
+		n = 10 // Default to 10 emails
+	}
+	_, changes, err := p.syncMailbox(mailbox, n)
+	return changes, err
+}
+
+// enableExtensions turns on CONDSTORE and QRESYNC when the server has them.
+// Servers without them are synced by comparing UIDs and flags instead.
+func (p *GenericIMAPProvider) enableExtensions() {
+	p.condstore, p.qresync = false, false
+
+	if ok, _ := p.client.Support("QRESYNC"); ok {
+		status, err := p.client.Execute(&commands.Enable{Caps: []string{"QRESYNC"}}, nil)
+		p.qresync = err == nil && status.Err() == nil
+	}
+	if ok, _ := p.client.Support("CONDSTORE"); ok || p.qresync {
+		// CONDSTORE needs no ENABLE; asking for MODSEQ turns it on
+		p.condstore = true
+	}
+}
+
+// syncMailbox is SyncMailbox for callers holding the lock. It also returns
+// the synced cache.
+//
+// Known messages are checked in the cheapest way the server allows: with
+// QRESYNC, one FETCH returns the changed flags and the expunged UIDs; with
+// CONDSTORE, the changed flags come from a FETCH and expunges from a UID
+// SEARCH; otherwise the flags of every known message are fetched and
+// compared. New messages are always found by searching above the highest
+// known UID.
+func (p *GenericIMAPProvider) syncMailbox(mailbox string, n int) (*cachedMailbox, *MailboxChanges, error) {
+	if err := p.ensureConnected(); err != nil {
+		return nil, nil, err
+	}
+	status, err := p.selectMailbox(mailbox)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cached, err := p.cache.load(mailbox)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changes := &MailboxChanges{Mailbox: mailbox, UIDValidity: status.UidValidity}
+	if cached.UIDValidity != status.UidValidity {
+		changes.Reset = len(cached.Messages) > 0
+		cached = &cachedMailbox{UIDValidity: status.UidValidity}
+	}
+	if status.Messages == 0 {
+		for _, email := range cached.Messages {
+			changes.Expunged = append(changes.Expunged, email.UID)
+		}
+		cached.Messages = nil
+	}
+
+	if len(cached.Messages) > 0 {
+		var known *knownChanges
+		if p.condstore && cached.ModSeq > 0 {
+			known, err = p.changedSince(cached)
+		} else {
+			known, err = p.diffFlags(cached)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		known.apply(cached, changes)
+		if known.modSeq > cached.ModSeq {
+			cached.ModSeq = known.modSeq
+		}
+	}
+	p.cache.removeMessages(mailbox, cached.UIDValidity, changes.Expunged)
+
+	var uids []uint32
+	if len(cached.Messages) == 0 {
+		uids, err = p.pageUIDs(status, 0, n)
+	} else {
+		uids, err = p.newUIDs(cached.highestUID())
+		if len(uids) > n {
+			// Too much has arrived to fill the gap; start over from the
+			// newest page so the cache stays contiguous
+			cached.Messages = nil
+			changes = &MailboxChanges{Mailbox: mailbox, UIDValidity: status.UidValidity, Reset: true}
+			uids = uids[len(uids)-n:]
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	emails, modSeq, err := p.fetchSummaries(mailbox, status.UidValidity, uids)
+	if err != nil {
+		return nil, nil, err
+	}
+	cached.merge(emails)
+	if !changes.Reset {
+		changes.New = emails
+	}
+	if modSeq > cached.ModSeq {
+		cached.ModSeq = modSeq
+	}
+
+	if err := p.cache.save(mailbox, cached); err != nil {
+		return nil, nil, err
+	}
+	return cached, changes, nil
+}
+
+// knownChanges are the changes to messages already in the cache
+type knownChanges struct {
+	flags    map[uint32][]string // New flags of the messages that changed
+	expunged map[uint32]bool
+	modSeq   uint64 // Highest MODSEQ seen, or 0
+}
+
+// apply updates the cache with the changes and records them in changes
+func (k *knownChanges) apply(cached *cachedMailbox, changes *MailboxChanges) {
+	var kept []*IncomingMessage
+	for _, email := range cached.Messages {
+		if k.expunged[email.UID] {
+			changes.Expunged = append(changes.Expunged, email.UID)
+			continue
+		}
+		if flags, ok := k.flags[email.UID]; ok && !sameFlags(flags, email.Flags) {
+			email.Flags = flags
+			changes.Updated = append(changes.Updated, email.summary())
+		}
+		kept = append(kept, email)
+	}
+	cached.Messages = kept
+}
+
+// diffFlags fetches the flags of every known message and compares them with
+// the cache. Messages the server no longer returns were expunged.
+func (p *GenericIMAPProvider) diffFlags(cached *cachedMailbox) (*knownChanges, error) {
+	seqSet := new(imap.SeqSet)
+	seqSet.AddRange(cached.lowestUID(), cached.highestUID())
+	items := []imap.FetchItem{imap.FetchUid, imap.FetchFlags}
+	if p.condstore {
+		// Seeds the MODSEQ mark so the next sync can be incremental
+		items = append(items, fetchModSeq)
+	}
+
+	messages := make(chan *imap.Message, 100)
+	done := make(chan error, 1)
+	go func() {
+		done <- p.client.UidFetch(seqSet, items, messages)
+	}()
+
+	known := &knownChanges{flags: map[uint32][]string{}, expunged: map[uint32]bool{}}
+	for msg := range messages {
+		known.flags[msg.Uid] = msg.Flags
+		if modSeq := messageModSeq(msg); modSeq > known.modSeq {
+			known.modSeq = modSeq
+		}
+	}
+	if err := <-done; err != nil {
+		return nil, fmt.Errorf("fetch failed: %v", err)
+	}
+
+	for _, email := range cached.Messages {
+		if _, ok := known.flags[email.UID]; !ok {
+			known.expunged[email.UID] = true
+		}
+	}
+	return known, nil
+}
+
+// changedSince asks a CONDSTORE server for the known messages whose flags
+// changed after the cache's MODSEQ mark
+func (p *GenericIMAPProvider) changedSince(cached *cachedMailbox) (*knownChanges, error) {
+	seqSet := new(imap.SeqSet)
+	seqSet.AddRange(cached.lowestUID(), cached.highestUID())
+
+	cmd := &uidFetchChangedSince{
+		SeqSet:       seqSet,
+		Items:        []imap.FetchItem{imap.FetchUid, imap.FetchFlags, fetchModSeq},
+		ChangedSince: cached.ModSeq,
+		Vanished:     p.qresync,
+	}
+	res := &changedSinceResponse{}
+	status, err := p.client.Execute(cmd, res)
+	if err == nil {
+		err = status.Err()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fetch failed: %v", err)
+	}
+
+	known := &knownChanges{flags: map[uint32][]string{}, expunged: map[uint32]bool{}}
+	for _, msg := range res.messages {
+		known.flags[msg.Uid] = msg.Flags
+		if modSeq := messageModSeq(msg); modSeq > known.modSeq {
+			known.modSeq = modSeq
+		}
+	}
+
+	if p.qresync {
+		for _, email := range cached.Messages {
+			if res.vanished.Contains(email.UID) {
+				known.expunged[email.UID] = true
+			}
+		}
+		return known, nil
+	}
+
+	// Without QRESYNC, expunged messages are the ones no longer found
+	criteria := imap.NewSearchCriteria()
+	criteria.Uid = seqSet
+	uids, err := p.client.UidSearch(criteria)
+	if err != nil {
+		return nil, fmt.Errorf("search failed: %v", err)
+	}
+	present := make(map[uint32]bool, len(uids))
+	for _, uid := range uids {
+		present[uid] = true
+	}
+	for _, email := range cached.Messages {
+		if !present[email.UID] {
+			known.expunged[email.UID] = true
+		}
+	}
+	return known, nil
+}
+
+// uidFetchChangedSince is a UID FETCH with the CHANGEDSINCE modifier of
+// RFC 7162, and with QRESYNC, the VANISHED modifier
+type uidFetchChangedSince struct {
+	SeqSet       *imap.SeqSet
+	Items        []imap.FetchItem
+	ChangedSince uint64
+	Vanished     bool
+}
+
+func (cmd *uidFetchChangedSince) Command() *imap.Command {
+	items := make([]interface{}, len(cmd.Items))
+	for i, item := range cmd.Items {
+		items[i] = imap.RawString(item)
+	}
+	modifiers := []interface{}{
+		imap.RawString("CHANGEDSINCE"),
+		imap.RawString(strconv.FormatUint(cmd.ChangedSince, 10)),
+	}
+	if cmd.Vanished {
+		modifiers = append(modifiers, imap.RawString("VANISHED"))
+	}
+
+	return &imap.Command{
+		Name:      "UID",
+		Arguments: []interface{}{imap.RawString("FETCH"), cmd.SeqSet, items, modifiers},
+	}
+}
+
+// changedSinceResponse collects the FETCH and VANISHED responses of a
+// uidFetchChangedSince
+type changedSinceResponse struct {
+	messages []*imap.Message
+	vanished imap.SeqSet
+}
+
+func (r *changedSinceResponse) Handle(resp imap.Resp) error {
+	name, fields, ok := imap.ParseNamedResp(resp)
+	if !ok {
+		return responses.ErrUnhandled
+	}
+
+	switch name {
+	case "FETCH":
+		if len(fields) < 2 {
+			return responses.ErrUnhandled
+		}
+		msgFields, _ := fields[1].([]interface{})
+		msg := &imap.Message{}
+		if err := msg.Parse(msgFields); err != nil || msg.Uid == 0 {
+			return responses.ErrUnhandled
+		}
+		r.messages = append(r.messages, msg)
+		return nil
+	case "VANISHED":
+		// * VANISHED (EARLIER) 41,43:116
+		if len(fields) == 0 {
+			return responses.ErrUnhandled
+		}
+		uids, ok := fields[len(fields)-1].(string)
+		if !ok {
+			return responses.ErrUnhandled
+		}
+		set, err := imap.ParseSeqSet(uids)
+		if err != nil {
+			return responses.ErrUnhandled
+		}
+		r.vanished.AddSet(set)
+		return nil
+	default:
+		return responses.ErrUnhandled
+	}
+}
+
+// messageModSeq returns the MODSEQ of a fetched message, or 0
+func messageModSeq(msg *imap.Message) uint64 {
+	value, ok := msg.Items[fetchModSeq].([]interface{})
+	if !ok || len(value) == 0 {
+		return 0
+	}
+	modSeq, _ := strconv.ParseUint(fmt.Sprint(value[0]), 10, 64)
+	return modSeq
+}
+
+// sameFlags reports whether two flag lists hold the same flags
+func sameFlags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[string]bool, len(a))
+	for _, flag := range a {
+		set[flag] = true
+	}
+	for _, flag := range b {
+		if !set[flag] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,263 @@
+package email
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/emersion/go-imap"
+)
+
+// scriptedIMAPServer answers IMAP commands with canned responses, for
+// extensions the go-imap memory backend lacks. respond gets each command
+// without its tag and returns the untagged responses to send before OK.
+type scriptedIMAPServer struct {
+	mu       sync.Mutex
+	commands []string
+	respond  func(command string) []string
+}
+
+// startScriptedIMAPServer listens on a loopback port and returns a Config for it
+func startScriptedIMAPServer(t *testing.T, capabilities string, respond func(command string) []string) (*scriptedIMAPServer, Config) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen for IMAP: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	server := &scriptedIMAPServer{respond: respond}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn, capabilities)
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	return server, Config{
+		IMAPHost:      "127.0.0.1",
+		IMAPPort:      port,
+		IMAPTLS:       TLSModeNone,
+		SMTPHost:      "127.0.0.1",
+		SMTPPort:      "25",
+		SMTPTLS:       TLSModeNone,
+		AuthMechanism: AuthMechanismLogin,
+	}
+}
+
+func (s *scriptedIMAPServer) serve(conn net.Conn, capabilities string) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	fmt.Fprintf(conn, "* OK [CAPABILITY %s] scripted server ready\r\n", capabilities)
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		tag, command, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+
+		s.mu.Lock()
+		s.commands = append(s.commands, command)
+		s.mu.Unlock()
+
+		name := strings.ToUpper(strings.SplitN(command, " ", 2)[0])
+		switch name {
+		case "CAPABILITY":
+			fmt.Fprintf(conn, "* CAPABILITY %s\r\n", capabilities)
+		case "LOGOUT":
+			fmt.Fprintf(conn, "* BYE\r\n%s OK LOGOUT completed\r\n", tag)
+			return
+		case "LOGIN":
+		case "IDLE":
+			fmt.Fprintf(conn, "+ idling\r\n")
+			for _, response := range s.respond(command) {
+				fmt.Fprintf(conn, "%s\r\n", response)
+			}
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if strings.EqualFold(strings.TrimSpace(line), "DONE") {
+					break
+				}
+			}
+		default:
+			for _, response := range s.respond(command) {
+				fmt.Fprintf(conn, "%s\r\n", response)
+			}
+		}
+		fmt.Fprintf(conn, "%s OK %s completed\r\n", tag, name)
+	}
+}
+
+// received returns the commands whose text contains substr
+func (s *scriptedIMAPServer) received(substr string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var matching []string
+	for _, command := range s.commands {
+		if strings.Contains(command, substr) {
+			matching = append(matching, command)
+		}
+	}
+	return matching
+}
+
+// scriptedSummary is the FETCH response of a list entry
+func scriptedSummary(seq, uid int, flags string, modSeq int) string {
+	return fmt.Sprintf(`* %d FETCH (UID %d FLAGS (%s) RFC822.SIZE 100 MODSEQ (%d) `+
+		`ENVELOPE (NIL "Message %d" (("Alice" NIL "alice" "example.com")) NIL NIL NIL NIL NIL NIL NIL) `+
+		`BODYSTRUCTURE ("TEXT" "PLAIN" ("CHARSET" "utf-8") NIL NIL "7BIT" 10 1))`, seq, uid, flags, modSeq, uid)
+}
+
+func TestSyncMailboxWithQResync(t *testing.T) {
+	synced := false
+	server, config := startScriptedIMAPServer(t, "IMAP4rev1 ENABLE CONDSTORE QRESYNC", func(command string) []string {
+		switch {
+		case strings.HasPrefix(command, "ENABLE"):
+			return []string{"* ENABLED QRESYNC"}
+		case strings.HasPrefix(command, "SELECT"):
+			return []string{"* 3 EXISTS", "* OK [UIDVALIDITY 7] UIDs valid", "* OK [HIGHESTMODSEQ 12] Highest"}
+		case strings.HasPrefix(command, "UID SEARCH"):
+			if synced {
+				return []string{"* SEARCH 4"}
+			}
+			return []string{"* SEARCH 1 2 3"}
+		case strings.Contains(command, "CHANGEDSINCE"):
+			synced = true
+			return []string{`* VANISHED (EARLIER) 2`, `* 2 FETCH (UID 3 FLAGS (\Seen) MODSEQ (15))`}
+		case strings.HasPrefix(command, "UID FETCH 4"):
+			return []string{scriptedSummary(3, 4, "", 16)}
+		case strings.HasPrefix(command, "UID FETCH"):
+			return []string{scriptedSummary(1, 1, "", 10), scriptedSummary(2, 2, "", 11), scriptedSummary(3, 3, "", 12)}
+		}
+		return nil
+	})
+
+	provider, err := NewGenericIMAPProvider(config, testCredentials())
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Disconnect()
+
+	emails, err := provider.GetEmailsPage(InboxName, 0, 10)
+	if err != nil {
+		t.Fatalf("GetEmailsPage returned error: %v", err)
+	}
+	if len(emails) != 3 || emails[0].Subject != "Message 3" {
+		t.Fatalf("Expected 3 emails, newest first, got %d", len(emails))
+	}
+
+	changes, err := provider.SyncMailbox(InboxName, 10)
+	if err != nil {
+		t.Fatalf("SyncMailbox returned error: %v", err)
+	}
+
+	if got := server.received("CHANGEDSINCE 12 VANISHED"); len(got) != 1 {
+		t.Errorf("Expected one UID FETCH CHANGEDSINCE 12 VANISHED, got %v", got)
+	}
+	if len(changes.Expunged) != 1 || changes.Expunged[0] != 2 {
+		t.Errorf("Expected UID 2 to vanish, got %v", changes.Expunged)
+	}
+	if len(changes.Updated) != 1 || changes.Updated[0].UID != 3 || changes.Updated[0].Flags[0] != imap.SeenFlag {
+		t.Errorf("Expected UID 3 to be marked seen, got %+v", changes.Updated)
+	}
+	if len(changes.New) != 1 || changes.New[0].UID != 4 {
+		t.Errorf("Expected UID 4 to be new, got %+v", changes.New)
+	}
+
+	cached, _ := provider.CachedEmails(InboxName, 10)
+	if got := cachedUIDs(cached); len(got) != 3 || got[0] != 4 || got[1] != 3 || got[2] != 1 {
+		t.Errorf("Expected the cache to hold 4, 3, 1, got %v", got)
+	}
+}
+
+func TestSyncMailboxFallsBackToFlagDiff(t *testing.T) {
+	servers := startTestServers(t)
+	for i := 1; i <= 3; i++ {
+		servers.addMessage(t, "INBOX", fmt.Sprintf("From: alice@example.com\r\nSubject: Message %d\r\n\r\nBody %d\r\n", i, i))
+	}
+
+	provider, err := NewGenericIMAPProvider(servers.config, testCredentials())
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Disconnect()
+
+	if _, err := provider.GetEmailsPage(InboxName, 0, 10); err != nil {
+		t.Fatalf("GetEmailsPage returned error: %v", err)
+	}
+
+	// Nothing changed yet
+	changes, err := provider.SyncMailbox(InboxName, 10)
+	if err != nil {
+		t.Fatalf("SyncMailbox returned error: %v", err)
+	}
+	if !changes.Empty() {
+		t.Errorf("Expected no changes, got %+v", changes)
+	}
+
+	mbox := servers.memoryMailbox(t, InboxName)
+	flagged := mbox.Messages[1].Uid
+	mbox.Messages[1].Flags = append(mbox.Messages[1].Flags, imap.FlaggedFlag)
+	expunged := mbox.Messages[2].Uid
+	mbox.Messages = append(mbox.Messages[:2], mbox.Messages[3:]...)
+	servers.addMessage(t, "INBOX", "From: bob@example.com\r\nSubject: Message 4\r\n\r\nBody 4\r\n")
+
+	changes, err = provider.SyncMailbox(InboxName, 10)
+	if err != nil {
+		t.Fatalf("SyncMailbox returned error: %v", err)
+	}
+	if len(changes.Expunged) != 1 || changes.Expunged[0] != expunged {
+		t.Errorf("Expected %d to be expunged, got %v", expunged, changes.Expunged)
+	}
+	if len(changes.Updated) != 1 || changes.Updated[0].UID != flagged {
+		t.Errorf("Expected %d to be updated, got %+v", flagged, changes.Updated)
+	}
+	if len(changes.New) != 1 || changes.New[0].Subject != "Message 4" {
+		t.Errorf("Expected Message 4 to be new, got %+v", changes.New)
+	}
+}
+
+func TestChangedSinceCommand(t *testing.T) {
+	seqSet := new(imap.SeqSet)
+	seqSet.AddRange(1, 9)
+	cmd := &uidFetchChangedSince{
+		SeqSet:       seqSet,
+		Items:        []imap.FetchItem{imap.FetchUid, imap.FetchFlags},
+		ChangedSince: 1 << 40,
+		Vanished:     true,
+	}
+
+	var b strings.Builder
+	w := imap.NewClientWriter(&b, nil)
+	command := cmd.Command()
+	command.Tag = "a1"
+	if err := command.WriteTo(w); err != nil {
+		t.Fatalf("WriteTo returned error: %v", err)
+	}
+	w.Flush()
+
+	expected := "a1 UID FETCH 1:9 (UID FLAGS) (CHANGEDSINCE 1099511627776 VANISHED)\r\n"
+	if b.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, b.String())
+	}
+}
+
+func TestSameFlags(t *testing.T) {
+	if !sameFlags([]string{imap.SeenFlag, imap.FlaggedFlag}, []string{imap.FlaggedFlag, imap.SeenFlag}) {
+		t.Errorf("Expected flag order not to matter")
+	}
+	if sameFlags([]string{imap.SeenFlag}, nil) {
+		t.Errorf("Expected different flags to differ")
+	}
+}
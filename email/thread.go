@@ -0,0 +1,291 @@
+package email
+
+import (
+	"sort"
+	"strings"
+)
+
+// Thread is a conversation: an email and the replies to it, as far as they
+// have been loaded
+type Thread struct {
+	Messages []*IncomingMessage // Oldest first
+}
+
+// Latest returns the newest email of the thread
+func (t *Thread) Latest() *IncomingMessage {
+	return t.Messages[len(t.Messages)-1]
+}
+
+// Subject returns the subject of the email that started the thread
+func (t *Thread) Subject() string {
+	return t.Messages[0].Subject
+}
+
+// Unread returns how many emails of the thread are unread
+func (t *Thread) Unread() int {
+	unread := 0
+	for _, msg := range t.Messages {
+		if !msg.Seen() {
+			unread++
+		}
+	}
+	return unread
+}
+
+// Flagged reports whether any email of the thread is starred
+func (t *Thread) Flagged() bool {
+	for _, msg := range t.Messages {
+		if msg.Flagged() {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAttachments reports whether any email of the thread has attachments
+func (t *Thread) HasAttachments() bool {
+	for _, msg := range t.Messages {
+		if len(msg.Attachments) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Participants returns the names of the thread's senders in the order they
+// joined the conversation
+func (t *Thread) Participants() []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, msg := range t.Messages {
+		name := SenderName(msg.From)
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// SenderName returns the display name of an address such as
+// "Alice <alice@example.com>", or the address itself when it has none
+func SenderName(from string) string {
+	if idx := strings.LastIndex(from, "<"); idx > 0 {
+		return strings.Trim(strings.TrimSpace(from[:idx]), `"`)
+	}
+	return strings.Trim(from, "<> ")
+}
+
+// threadContainer is a node of the JWZ threading tree. Containers without
+// a message stand for emails that are referenced but were not loaded.
+type threadContainer struct {
+	message  *IncomingMessage
+	order    int // Position of the message in the input
+	parent   *threadContainer
+	children []*threadContainer
+}
+
+// isAncestorOf reports whether c is other or one of its parents
+func (c *threadContainer) isAncestorOf(other *threadContainer) bool {
+	for ; other != nil; other = other.parent {
+		if other == c {
+			return true
+		}
+	}
+	return false
+}
+
+// setParent moves c under parent, or makes it a root when parent is nil
+func (c *threadContainer) setParent(parent *threadContainer) {
+	if c.parent != nil {
+		siblings := c.parent.children
+		for i, sibling := range siblings {
+			if sibling == c {
+				c.parent.children = append(siblings[:i:i], siblings[i+1:]...)
+				break
+			}
+		}
+	}
+	c.parent = parent
+	if parent != nil {
+		parent.children = append(parent.children, c)
+	}
+}
+
+// collect appends the messages of c and its descendants
+func (c *threadContainer) collect(messages []*threadContainer) []*threadContainer {
+	if c.message != nil {
+		messages = append(messages, c)
+	}
+	for _, child := range c.children {
+		messages = child.collect(messages)
+	}
+	return messages
+}
+
+// ThreadMessages groups emails into threads. Threads come in the order of
+// their newest email in emails, so a list sorted newest first stays that way.
+//
+// Emails carrying the server's ThreadID are grouped by it. The others are
+// threaded with Jamie Zawinski's algorithm (https://www.jwz.org/doc/threading.html)
+// over their Message-ID, In-Reply-To and References headers, and a reply
+// whose parent is missing joins the thread with the same subject.
+func ThreadMessages(emails []*IncomingMessage) []*Thread {
+	var groups [][]*threadContainer
+
+	byThreadID := map[string]int{}
+	containers := map[string]*threadContainer{}
+	var headerThreaded []*threadContainer
+	for i, msg := range emails {
+		if msg.ThreadID != "" {
+			c := &threadContainer{message: msg, order: i}
+			if group, ok := byThreadID[msg.ThreadID]; ok {
+				groups[group] = append(groups[group], c)
+			} else {
+				byThreadID[msg.ThreadID] = len(groups)
+				groups = append(groups, []*threadContainer{c})
+			}
+			continue
+		}
+		headerThreaded = append(headerThreaded, threadContainerFor(containers, msg, i))
+	}
+
+	for _, root := range threadRoots(headerThreaded) {
+		if messages := root.collect(nil); len(messages) > 0 {
+			groups = append(groups, messages)
+		}
+	}
+
+	threads := make([]*Thread, 0, len(groups))
+	newest := make(map[*Thread]int, len(groups))
+	for _, group := range groups {
+		sort.SliceStable(group, func(i, j int) bool {
+			if !group[i].message.Date.Equal(group[j].message.Date) {
+				return group[i].message.Date.Before(group[j].message.Date)
+			}
+			return group[i].order > group[j].order
+		})
+		thread := &Thread{Messages: make([]*IncomingMessage, len(group))}
+		newest[thread] = len(emails)
+		for i, c := range group {
+			thread.Messages[i] = c.message
+			if c.order < newest[thread] {
+				newest[thread] = c.order
+			}
+		}
+		threads = append(threads, thread)
+	}
+	sort.SliceStable(threads, func(i, j int) bool { return newest[threads[i]] < newest[threads[j]] })
+	return threads
+}
+
+// threadContainerFor files an email under its Message-ID and links it below
+// the emails it references
+func threadContainerFor(containers map[string]*threadContainer, msg *IncomingMessage, order int) *threadContainer {
+	id := msg.MessageID
+	c := containers[id]
+	if id == "" || (c != nil && c.message != nil) {
+		// Without a usable Message-ID nothing can reply to the email
+		c = &threadContainer{}
+	} else if c == nil {
+		c = &threadContainer{}
+		containers[id] = c
+	}
+	c.message, c.order = msg, order
+
+	references := msg.References
+	if msg.InReplyTo != "" && (len(references) == 0 || references[len(references)-1] != msg.InReplyTo) {
+		references = append(references[:len(references):len(references)], msg.InReplyTo)
+	}
+
+	// Link the references to each other, oldest first, unless an earlier
+	// email already placed them
+	var parent *threadContainer
+	for _, ref := range references {
+		r := containers[ref]
+		if r == nil {
+			r = &threadContainer{}
+			containers[ref] = r
+		}
+		if parent != nil && r.parent == nil && !r.isAncestorOf(parent) {
+			r.setParent(parent)
+		}
+		parent = r
+	}
+
+	// The email's own headers have the last word on its parent
+	if parent != nil && c.isAncestorOf(parent) {
+		parent = nil
+	}
+	c.setParent(parent)
+	return c
+}
+
+// threadRoots returns the roots of the threading tree that the given
+// containers belong to, after joining replies to threads by subject
+func threadRoots(messages []*threadContainer) []*threadContainer {
+	// Roots in the order their emails came, so results are stable
+	var roots []*threadContainer
+	isRoot := map[*threadContainer]bool{}
+	for _, c := range messages {
+		root := c
+		for root.parent != nil {
+			root = root.parent
+		}
+		if !isRoot[root] {
+			isRoot[root] = true
+			roots = append(roots, root)
+		}
+	}
+
+	// A reply whose parent never arrived joins the thread it names
+	bySubject := map[string]*threadContainer{}
+	for _, root := range roots {
+		if root.message != nil && !isReplySubject(root.message.Subject) {
+			subject := baseSubject(root.message.Subject)
+			if _, ok := bySubject[subject]; !ok && subject != "" {
+				bySubject[subject] = root
+			}
+		}
+	}
+	joined := roots[:0]
+	for _, root := range roots {
+		message := root.message
+		if message == nil {
+			if first := root.collect(nil); len(first) > 0 {
+				message = first[0].message
+			}
+		}
+		if message != nil && isReplySubject(message.Subject) {
+			if target, ok := bySubject[baseSubject(message.Subject)]; ok && target != root {
+				root.setParent(target)
+				continue
+			}
+		}
+		joined = append(joined, root)
+	}
+	return joined
+}
+
+// replyPrefixes are the subject prefixes of replies and forwards
+var replyPrefixes = []string{"re:", "fwd:", "fw:", "aw:", "sv:"}
+
+// isReplySubject reports whether a subject starts with Re: or a similar prefix
+func isReplySubject(subject string) bool {
+	return baseSubject(subject) != strings.ToLower(strings.TrimSpace(subject))
+}
+
+// baseSubject returns a subject without its Re: and Fwd: prefixes, lowercased
+func baseSubject(subject string) string {
+	subject = strings.ToLower(strings.TrimSpace(subject))
+	for {
+		trimmed := subject
+		for _, prefix := range replyPrefixes {
+			trimmed = strings.TrimSpace(strings.TrimPrefix(trimmed, prefix))
+		}
+		if trimmed == subject {
+			return subject
+		}
+		subject = trimmed
+	}
+}
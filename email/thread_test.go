@@ -0,0 +1,198 @@
+package email
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// threadTestEmail returns an email sent minutes after a fixed time
+func threadTestEmail(uid uint32, minutes int, from, subject, messageID, inReplyTo string, references ...string) *IncomingMessage {
+	return &IncomingMessage{
+		Mailbox:    InboxName,
+		UID:        uid,
+		From:       from,
+		Subject:    subject,
+		Date:       time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC).Add(time.Duration(minutes) * time.Minute),
+		MessageID:  messageID,
+		InReplyTo:  inReplyTo,
+		References: references,
+	}
+}
+
+// threadUIDs returns the UIDs of each thread's emails
+func threadUIDs(threads []*Thread) [][]uint32 {
+	uids := make([][]uint32, len(threads))
+	for i, thread := range threads {
+		for _, msg := range thread.Messages {
+			uids[i] = append(uids[i], msg.UID)
+		}
+	}
+	return uids
+}
+
+func sameThreads(got [][]uint32, expected [][]uint32) bool {
+	if len(got) != len(expected) {
+		return false
+	}
+	for i := range got {
+		if len(got[i]) != len(expected[i]) {
+			return false
+		}
+		for j := range got[i] {
+			if got[i][j] != expected[i][j] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestThreadMessages(t *testing.T) {
+	// Newest first, as the reader lists them
+	emails := []*IncomingMessage{
+		threadTestEmail(6, 50, "Carol <carol@example.com>", "Re: Lunch", "f@x", "b@x", "a@x", "b@x"),
+		threadTestEmail(5, 40, "Dave <dave@example.com>", "Report", "e@x", ""),
+		// Replies to an email that was never loaded still belong together
+		threadTestEmail(4, 30, "Alice <alice@example.com>", "Re: Old", "c@x", "d@x", "gone@x", "d@x"),
+		threadTestEmail(3, 20, "Bob <bob@example.com>", "Re: Old", "d@x", "gone@x"),
+		threadTestEmail(2, 10, "Bob <bob@example.com>", "Re: Lunch", "b@x", "a@x", "a@x"),
+		threadTestEmail(1, 0, "Alice <alice@example.com>", "Lunch", "a@x", ""),
+	}
+
+	threads := ThreadMessages(emails)
+	expected := [][]uint32{{1, 2, 6}, {5}, {3, 4}}
+	if got := threadUIDs(threads); !sameThreads(got, expected) {
+		t.Fatalf("Expected threads %v, got %v", expected, got)
+	}
+
+	lunch := threads[0]
+	if lunch.Subject() != "Lunch" || lunch.Latest().UID != 6 {
+		t.Errorf("Expected the Lunch thread to end with 6, got %q ending with %d", lunch.Subject(), lunch.Latest().UID)
+	}
+	participants := lunch.Participants()
+	if len(participants) != 3 || participants[0] != "Alice" || participants[2] != "Carol" {
+		t.Errorf("Expected Alice, Bob, Carol, got %v", participants)
+	}
+	if lunch.Unread() != 3 {
+		t.Errorf("Expected 3 unread emails, got %d", lunch.Unread())
+	}
+}
+
+func TestThreadMessagesBySubject(t *testing.T) {
+	emails := []*IncomingMessage{
+		// A reply without threading headers joins by subject
+		threadTestEmail(3, 20, "bob@example.com", "RE: Fwd: Plans", "", ""),
+		// Two emails with the same subject that are not replies stay apart
+		threadTestEmail(2, 10, "alice@example.com", "Plans", "b@x", ""),
+		threadTestEmail(1, 0, "alice@example.com", "Plans", "a@x", ""),
+	}
+
+	expected := [][]uint32{{2, 3}, {1}}
+	if got := threadUIDs(ThreadMessages(emails)); !sameThreads(got, expected) {
+		t.Errorf("Expected threads %v, got %v", expected, got)
+	}
+}
+
+func TestThreadMessagesByThreadID(t *testing.T) {
+	emails := []*IncomingMessage{
+		threadTestEmail(3, 20, "bob@example.com", "Other", "c@x", ""),
+		threadTestEmail(2, 10, "bob@example.com", "Re: Hello", "b@x", ""),
+		threadTestEmail(1, 0, "alice@example.com", "Hello", "a@x", ""),
+	}
+	// The server's conversations win over the headers
+	emails[0].ThreadID = "77"
+	emails[2].ThreadID = "77"
+	emails[1].ThreadID = "78"
+
+	expected := [][]uint32{{1, 3}, {2}}
+	if got := threadUIDs(ThreadMessages(emails)); !sameThreads(got, expected) {
+		t.Errorf("Expected threads %v, got %v", expected, got)
+	}
+}
+
+func TestThreadMessagesIgnoresLoops(t *testing.T) {
+	emails := []*IncomingMessage{
+		threadTestEmail(2, 10, "bob@example.com", "Loop", "b@x", "a@x"),
+		threadTestEmail(1, 0, "alice@example.com", "Loop", "a@x", "b@x"),
+	}
+
+	threads := ThreadMessages(emails)
+	if len(threads) != 1 || len(threads[0].Messages) != 2 {
+		t.Errorf("Expected one thread of both emails, got %v", threadUIDs(threads))
+	}
+}
+
+func TestSenderName(t *testing.T) {
+	tests := map[string]string{
+		"Alice <alice@example.com>":      "Alice",
+		`"Smith, Bob" <bob@example.com>`: "Smith, Bob",
+		"carol@example.com":              "carol@example.com",
+		"<dave@example.com>":             "dave@example.com",
+	}
+	for from, expected := range tests {
+		if got := SenderName(from); got != expected {
+			t.Errorf("SenderName(%q): expected %q, got %q", from, expected, got)
+		}
+	}
+}
+
+func TestParseMsgIDs(t *testing.T) {
+	ids := parseMsgIDs("<a@example.com> <b@example.com>\r\n <c@example.com>")
+	if len(ids) != 3 || ids[0] != "a@example.com" || ids[2] != "c@example.com" {
+		t.Errorf("Expected three IDs without brackets, got %v", ids)
+	}
+	if ids := parseMsgIDs(""); len(ids) != 0 {
+		t.Errorf("Expected no IDs, got %v", ids)
+	}
+}
+
+func TestFetchThreadingHeaders(t *testing.T) {
+	references := "References: <a@example.com>\r\n <b@example.com>\r\n\r\n"
+	server, config := startScriptedIMAPServer(t, "IMAP4rev1 X-GM-EXT-1", func(command string) []string {
+		switch {
+		case strings.HasPrefix(command, "SELECT"):
+			return []string{"* 1 EXISTS", "* OK [UIDVALIDITY 7] UIDs valid"}
+		case strings.HasPrefix(command, "UID SEARCH"):
+			return []string{"* SEARCH 1"}
+		case strings.HasPrefix(command, "UID FETCH"):
+			return []string{fmt.Sprintf(`* 1 FETCH (UID 1 FLAGS () RFC822.SIZE 100 X-GM-THRID 1781234567890123456 `+
+				`ENVELOPE (NIL "Re: Lunch" (("Bob" NIL "bob" "example.com")) NIL NIL NIL NIL NIL "<b@example.com>" "<c@example.com>") `+
+				`BODYSTRUCTURE ("TEXT" "PLAIN" ("CHARSET" "utf-8") NIL NIL "7BIT" 10 1) `+
+				`BODY[HEADER.FIELDS (REFERENCES)] {%d}`+"\r\n"+`%s)`, len(references), references)}
+		}
+		return nil
+	})
+
+	provider, err := NewGmailProvider(config, testCredentials())
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Disconnect()
+
+	emails, err := provider.GetEmailsPage(InboxName, 0, 10)
+	if err != nil {
+		t.Fatalf("GetEmailsPage returned error: %v", err)
+	}
+	if got := server.received("X-GM-THRID"); len(got) != 1 || !strings.Contains(got[0], "BODY.PEEK[HEADER.FIELDS (References)]") {
+		t.Errorf("Expected one fetch of X-GM-THRID and References, got %v", got)
+	}
+	if len(emails) != 1 {
+		t.Fatalf("Expected 1 email, got %d", len(emails))
+	}
+
+	msg := emails[0]
+	if msg.MessageID != "c@example.com" || msg.InReplyTo != "b@example.com" {
+		t.Errorf("Expected Message-ID c@example.com in reply to b@example.com, got %q and %q", msg.MessageID, msg.InReplyTo)
+	}
+	if len(msg.References) != 2 || msg.References[1] != "b@example.com" {
+		t.Errorf("Expected two references, got %v", msg.References)
+	}
+	if msg.ThreadID != "1781234567890123456" {
+		t.Errorf("Expected the Gmail thread ID, got %q", msg.ThreadID)
+	}
+	if msg.Subject != "Re: Lunch" || msg.BodyLoaded {
+		t.Errorf("Expected a list entry for Re: Lunch, got %+v", msg)
+	}
+}
@@ -0,0 +1,94 @@
+package email
+
+import (
+	"fmt"
+	"time"
+
+	imapClient "github.com/emersion/go-imap/client"
+)
+
+// idleRestartInterval is how often IDLE is restarted. RFC 2177 lets servers
+// drop clients that idle for 30 minutes, so it must be shorter than that.
+const idleRestartInterval = 25 * time.Minute
+
+// pollInterval is how often servers without IDLE are polled with NOOP
+var pollInterval = time.Minute
+
+// WatchMailbox calls notify whenever the mailbox changes on the server,
+// until stop is closed. It holds a connection of its own so the main one
+// stays free for fetching: the server pushes changes with IDLE, or is polled
+// with NOOP when it does not support IDLE. notify is only a signal; the
+// changes themselves are fetched with SyncMailbox.
+func (p *GenericIMAPProvider) WatchMailbox(mailbox string, stop <-chan struct{}, notify func()) error {
+	client, err := p.dialIMAP()
+	if err != nil {
+		return fmt.Errorf("failed to connect to IMAP server: %v", err)
+	}
+
+	// Updates are set before anything is sent so the client never sees the
+	// channel change, and drained until the client is gone so it never
+	// blocks
+	updates := make(chan imapClient.Update, 16)
+	client.Updates = updates
+	quit := make(chan struct{})
+	drain := func(notify func()) {
+		go func() {
+			for {
+				select {
+				case update := <-updates:
+					switch update.(type) {
+					case *imapClient.MailboxUpdate, *imapClient.ExpungeUpdate, *imapClient.MessageUpdate:
+						if notify != nil {
+							notify()
+						}
+					}
+				case <-quit:
+					return
+				}
+			}
+		}()
+	}
+	draining := false
+	defer func() {
+		if !draining {
+			drain(nil)
+		}
+		client.Logout()
+		<-client.LoggedOut()
+		close(quit)
+	}()
+
+	if err := p.loginIMAP(client); err != nil {
+		return fmt.Errorf("failed to login: %v", err)
+	}
+	if _, err := client.Select(mailbox, true); err != nil {
+		return fmt.Errorf("failed to select %s: %v", mailbox, err)
+	}
+	// What the SELECT reported is buffered by now and is not news
+	for len(updates) > 0 {
+		<-updates
+	}
+	drain(notify)
+	draining = true
+
+	idleStop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Idle(idleStop, &imapClient.IdleOptions{
+			LogoutTimeout: idleRestartInterval,
+			PollInterval:  pollInterval,
+		})
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			err = fmt.Errorf("server ended IDLE")
+		}
+		return fmt.Errorf("stopped watching %s: %v", mailbox, err)
+	case <-stop:
+		close(idleStop)
+		<-done
+		return nil
+	}
+}
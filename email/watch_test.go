@@ -0,0 +1,90 @@
+package email
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// watchUntilNotified runs WatchMailbox until it calls notify or times out
+func watchUntilNotified(t *testing.T, provider *GenericIMAPProvider) {
+	t.Helper()
+
+	stop := make(chan struct{})
+	notified := make(chan struct{}, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- provider.WatchMailbox(InboxName, stop, func() { notified <- struct{}{} })
+	}()
+
+	select {
+	case <-notified:
+	case err := <-done:
+		t.Fatalf("WatchMailbox returned early: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a notification")
+	}
+
+	close(stop)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("WatchMailbox returned error after stop: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WatchMailbox did not return after stop")
+	}
+}
+
+func TestWatchMailboxIdle(t *testing.T) {
+	server, config := startScriptedIMAPServer(t, "IMAP4rev1 IDLE", func(command string) []string {
+		switch {
+		case strings.HasPrefix(command, "EXAMINE"):
+			return []string{"* 3 EXISTS", "* OK [UIDVALIDITY 7] UIDs valid"}
+		case command == "IDLE":
+			return []string{"* 4 EXISTS"}
+		}
+		return nil
+	})
+
+	provider, err := NewGenericIMAPProvider(config, testCredentials())
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	watchUntilNotified(t, provider)
+
+	if got := server.received("IDLE"); len(got) == 0 {
+		t.Error("Expected the watcher to IDLE")
+	}
+	if got := server.received("NOOP"); len(got) != 0 {
+		t.Errorf("Expected no polling when IDLE is supported, got %v", got)
+	}
+}
+
+func TestWatchMailboxPollsWithoutIdle(t *testing.T) {
+	defer func(interval time.Duration) { pollInterval = interval }(pollInterval)
+	pollInterval = 10 * time.Millisecond
+
+	server, config := startScriptedIMAPServer(t, "IMAP4rev1", func(command string) []string {
+		switch {
+		case strings.HasPrefix(command, "EXAMINE"):
+			return []string{"* 3 EXISTS", "* OK [UIDVALIDITY 7] UIDs valid"}
+		case command == "NOOP":
+			return []string{"* 2 EXPUNGE"}
+		}
+		return nil
+	})
+
+	provider, err := NewGenericIMAPProvider(config, testCredentials())
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	watchUntilNotified(t, provider)
+
+	if got := server.received("NOOP"); len(got) == 0 {
+		t.Error("Expected the watcher to poll with NOOP")
+	}
+	if got := server.received("IDLE"); len(got) != 0 {
+		t.Errorf("Expected no IDLE on a server without it, got %v", got)
+	}
+}
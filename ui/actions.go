@@ -0,0 +1,346 @@
+package ui
+
+import (
+	"fmt"
+
+	"github.com/jacobbanks/tmail/email"
+	"github.com/rivo/tview"
+)
+
+// selectedThread returns the thread selected in the list, or nil
+func (r *EmailReader) selectedThread() (int, *email.Thread) {
+	index := r.emailList.GetCurrentItem()
+	if index < 0 || index >= len(r.threads) {
+		return -1, nil
+	}
+	return index, r.threads[index]
+}
+
+// toggleFlag toggles a flag on the selected thread. Reading or unreading
+// acts on every email of the thread, starring only on the newest.
+func (r *EmailReader) toggleFlag(flag string) {
+	_, thread := r.selectedThread()
+	if thread == nil {
+		return
+	}
+	switch flag {
+	case email.SeenFlag:
+		read := thread.Unread() > 0
+		for _, msg := range thread.Messages {
+			r.setFlag(msg, flag, read)
+		}
+	case email.FlaggedFlag:
+		if !thread.Flagged() {
+			r.setFlag(thread.Latest(), flag, true)
+			return
+		}
+		for _, msg := range thread.Messages {
+			r.setFlag(msg, flag, false)
+		}
+	default:
+		msg := thread.Latest()
+		r.setFlag(msg, flag, !msg.HasFlag(flag))
+	}
+}
+
+// setFlag sets or clears a flag of an email. The list changes right away;
+// if the server refuses, the change is undone.
+func (r *EmailReader) setFlag(msg *email.IncomingMessage, flag string, set bool) {
+	if msg.HasFlag(flag) == set {
+		return
+	}
+	r.changeFlag(msg, flag, set)
+	if r.provider == nil || msg.UID == 0 {
+		return
+	}
+
+	provider := r.provider
+	mailbox, uid := msg.Mailbox, msg.UID
+	go func() {
+		err := provider.SetFlags(mailbox, []uint32{uid}, []string{flag}, set)
+		if err == nil {
+			return
+		}
+		r.app.QueueUpdateDraw(func() {
+			r.changeFlag(msg, flag, !set)
+			r.statusBar.SetText(fmt.Sprintf("[red]Failed to update email: %v", err))
+		})
+	}()
+}
+
+// changeFlag changes a flag of the local copy of an email and restyles its
+// row. Reading or unreading it also moves the unread count.
+func (r *EmailReader) changeFlag(msg *email.IncomingMessage, flag string, set bool) {
+	if msg.HasFlag(flag) == set {
+		return
+	}
+	msg.SetFlag(flag, set)
+	if flag == email.SeenFlag && r.unread >= 0 {
+		if set {
+			r.unread--
+		} else {
+			r.unread++
+		}
+		r.updateHeader()
+	}
+	r.refreshEmailItem(msg)
+}
+
+// refreshEmailItem redraws the list row of an email's thread after the
+// email changed, and the thread view if it shows the email
+func (r *EmailReader) refreshEmailItem(msg *email.IncomingMessage) {
+	for i, thread := range r.threads {
+		if !containsEmail(thread, msg) {
+			continue
+		}
+		if i < r.emailList.GetItemCount() {
+			text, secondaryText := r.threadItemText(thread)
+			r.emailList.SetItemText(i, text, secondaryText)
+		}
+		if thread == r.shownThread {
+			r.renderThread(string(spinnerFrames[0]))
+		}
+		return
+	}
+}
+
+// containsEmail reports whether an email belongs to a thread
+func containsEmail(thread *email.Thread, msg *email.IncomingMessage) bool {
+	for _, m := range thread.Messages {
+		if m == msg {
+			return true
+		}
+	}
+	return false
+}
+
+// toggleMark marks the selected thread for the next move, archive or
+// delete, or unmarks it, and moves on to the next one
+func (r *EmailReader) toggleMark() {
+	index, thread := r.selectedThread()
+	if thread == nil || thread.Latest().ID() == "" {
+		return
+	}
+	mark := !r.threadMarked(thread)
+	for _, msg := range thread.Messages {
+		if mark {
+			r.marked[msg.ID()] = true
+		} else {
+			delete(r.marked, msg.ID())
+		}
+	}
+	r.refreshEmailItem(thread.Latest())
+	if index < r.emailList.GetItemCount()-1 {
+		r.emailList.SetCurrentItem(index + 1)
+	}
+}
+
+// threadMarked reports whether every email of a thread is marked
+func (r *EmailReader) threadMarked(thread *email.Thread) bool {
+	for _, msg := range thread.Messages {
+		if !r.marked[msg.ID()] {
+			return false
+		}
+	}
+	return true
+}
+
+// targetEmails returns the marked emails, or the emails of the selected
+// thread when none are marked
+func (r *EmailReader) targetEmails() []*email.IncomingMessage {
+	var targets []*email.IncomingMessage
+	for _, msg := range r.emails {
+		if r.marked[msg.ID()] {
+			targets = append(targets, msg)
+		}
+	}
+	if len(targets) == 0 {
+		if _, thread := r.selectedThread(); thread != nil {
+			for _, msg := range thread.Messages {
+				if msg.UID != 0 {
+					targets = append(targets, msg)
+				}
+			}
+		}
+	}
+	return targets
+}
+
+// deleteEmail moves the target emails to the trash. In the trash itself,
+// they are deleted for good.
+func (r *EmailReader) deleteEmail() {
+	r.moveEmails("Deleting...", "delete", func(provider email.MailProvider, mailbox string, uids []uint32) error {
+		return provider.MoveToTrash(mailbox, uids)
+	})
+}
+
+// archiveEmail moves the target emails to the archive
+func (r *EmailReader) archiveEmail() {
+	r.moveEmails("Archiving...", "archive", func(provider email.MailProvider, mailbox string, uids []uint32) error {
+		return provider.Archive(mailbox, uids)
+	})
+}
+
+// moveEmailsTo moves the target emails to another mailbox
+func (r *EmailReader) moveEmailsTo(dest string) {
+	r.moveEmails("Moving to "+dest+"...", "move", func(provider email.MailProvider, mailbox string, uids []uint32) error {
+		return provider.MoveMessages(mailbox, uids, dest)
+	})
+}
+
+// moveEmails moves the target emails out of the open mailbox in the
+// background and drops them from the list once the server is done
+func (r *EmailReader) moveEmails(progress, action string, move func(provider email.MailProvider, mailbox string, uids []uint32) error) {
+	targets := r.targetEmails()
+	if len(targets) == 0 || r.provider == nil {
+		return
+	}
+	uids := make([]uint32, len(targets))
+	for i, msg := range targets {
+		uids[i] = msg.UID
+	}
+	r.statusBar.SetText("[gray]" + progress)
+
+	provider, mailbox := r.provider, r.mailbox
+	go func() {
+		err := move(provider, mailbox, uids)
+
+		r.app.QueueUpdateDraw(func() {
+			if provider != r.provider || mailbox != r.mailbox {
+				return // The user moved on to another folder or account
+			}
+			if err != nil {
+				r.statusBar.SetText(fmt.Sprintf("[red]Failed to %s: %v", action, err))
+				return
+			}
+			r.removeEmails(targets)
+			r.updateStatusBar()
+		})
+	}()
+}
+
+// removeEmails drops emails from the list, selecting the thread that took
+// the place of the first one they belonged to
+func (r *EmailReader) removeEmails(gone []*email.IncomingMessage) {
+	removed := make(map[*email.IncomingMessage]bool, len(gone))
+	for _, msg := range gone {
+		removed[msg] = true
+	}
+
+	index := -1
+	for i, thread := range r.threads {
+		for _, msg := range thread.Messages {
+			if removed[msg] && index < 0 {
+				index = i
+			}
+		}
+	}
+
+	emails := make([]*email.IncomingMessage, 0, len(r.emails))
+	for _, msg := range r.emails {
+		if !removed[msg] {
+			emails = append(emails, msg)
+			continue
+		}
+		delete(r.marked, msg.ID())
+		if !msg.Seen() && r.unread > 0 {
+			r.unread--
+			r.updateHeader()
+		}
+		if r.shownThread != nil && containsEmail(r.shownThread, msg) {
+			r.shownThread = nil
+			r.contentView.Clear()
+			r.focusPane("list")
+		}
+	}
+	if index < 0 {
+		return // A sync got there first
+	}
+
+	r.emails = emails
+	r.populateEmailList()
+	if len(emails) == 0 {
+		r.emailList.AddItem("No emails found.", "", 0, nil)
+	}
+	if index >= len(r.threads) {
+		index = len(r.threads) - 1
+	}
+	if index >= 0 {
+		r.emailList.SetCurrentItem(index)
+	}
+}
+
+// showFolderPicker lets the user pick the mailbox to move the target emails
+// to. The folders come from the sidebar, or are listed first if it is empty.
+func (r *EmailReader) showFolderPicker() {
+	if len(r.targetEmails()) == 0 || r.provider == nil {
+		return
+	}
+	if len(r.mailboxes) > 0 {
+		r.showFolderList(r.mailboxes)
+		return
+	}
+
+	r.statusBar.SetText("[gray]Loading folders...")
+	provider := r.provider
+	go func() {
+		mailboxes, err := provider.ListMailboxes()
+		r.app.QueueUpdateDraw(func() {
+			if provider != r.provider {
+				return
+			}
+			if err != nil {
+				r.statusBar.SetText(fmt.Sprintf("[red]Failed to list folders: %v", err))
+				return
+			}
+			r.updateStatusBar()
+			r.showFolderList(mailboxes)
+		})
+	}()
+}
+
+// showFolderList shows the folder picker for the given mailboxes
+func (r *EmailReader) showFolderList(mailboxes []*email.Mailbox) {
+	list := tview.NewList()
+	list.SetBorder(true)
+	list.SetTitle(" Move To ")
+	list.SetTitleAlign(tview.AlignCenter)
+	list.ShowSecondaryText(false)
+	for _, mailbox := range mailboxes {
+		if !mailbox.Selectable() || mailbox.Name == r.mailbox {
+			continue
+		}
+		dest := mailbox.Name
+		list.AddItem(dest, "", 0, func() {
+			r.pages.RemovePage("folders")
+			r.focusPane(r.currentView)
+			r.moveEmailsTo(dest)
+		})
+	}
+	if list.GetItemCount() == 0 {
+		r.statusBar.SetText("[red]No other folders to move to")
+		return
+	}
+	list.SetDoneFunc(func() {
+		r.pages.RemovePage("folders")
+		r.focusPane(r.currentView)
+	})
+
+	height := list.GetItemCount() + 2
+	if height > 20 {
+		height = 20
+	}
+
+	// Center the picker
+	flex := tview.NewFlex()
+	flex.AddItem(nil, 0, 1, false)
+	flex.AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(nil, 0, 1, false).
+		AddItem(list, height, 1, true).
+		AddItem(nil, 0, 1, false),
+		40, 1, true)
+	flex.AddItem(nil, 0, 1, false)
+
+	r.pages.AddPage("folders", flex, true, true)
+	r.app.SetFocus(list)
+}
@@ -0,0 +1,234 @@
+package ui
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/jacobbanks/tmail/email"
+	"github.com/rivo/tview"
+)
+
+// threadAttachment is an attachment of one of the emails of a thread
+type threadAttachment struct {
+	msg  *email.IncomingMessage
+	part *email.MIMEPart
+}
+
+// threadAttachments returns the attachments of every email of a thread,
+// oldest email first
+func threadAttachments(thread *email.Thread) []threadAttachment {
+	var attachments []threadAttachment
+	for _, msg := range thread.Messages {
+		for _, part := range msg.AttachmentParts() {
+			attachments = append(attachments, threadAttachment{msg: msg, part: part})
+		}
+	}
+	return attachments
+}
+
+// showAttachmentPicker lists the attachments of the shown thread to save
+// or open them
+func (r *EmailReader) showAttachmentPicker() {
+	thread := r.shownThread
+	if thread == nil || r.provider == nil {
+		return
+	}
+	// Emails cached before their MIME structure was kept have to be
+	// fetched again to find their attachments
+	var missing []*email.IncomingMessage
+	for _, msg := range thread.Messages {
+		if msg.Parts == nil && len(msg.Attachments) > 0 {
+			missing = append(missing, msg)
+		}
+	}
+	if len(missing) == 0 {
+		r.showAttachmentList(thread)
+		return
+	}
+
+	r.statusBar.SetText("[gray]Loading attachments...")
+	provider := r.provider
+	go func() {
+		var err error
+		parts := make([]*email.MIMEPart, len(missing))
+		for i, msg := range missing {
+			var full *email.IncomingMessage
+			if full, err = provider.FetchMessage(msg); err != nil {
+				break
+			}
+			parts[i] = full.Parts
+		}
+
+		r.app.QueueUpdateDraw(func() {
+			if err != nil {
+				r.statusBar.SetText(fmt.Sprintf("[red]Failed to load attachments: %v", err))
+				return
+			}
+			for i, msg := range missing {
+				msg.Parts = parts[i]
+			}
+			if r.shownThread == thread {
+				r.updateStatusBar()
+				r.showAttachmentList(thread)
+			}
+		})
+	}()
+}
+
+// showAttachmentList shows the picker for the attachments of a thread.
+// Enter saves the selected one to the download directory, o opens it.
+func (r *EmailReader) showAttachmentList(thread *email.Thread) {
+	attachments := threadAttachments(thread)
+	if len(attachments) == 0 {
+		r.statusBar.SetText("[gray]No attachments")
+		return
+	}
+
+	// Attachments of different emails are told apart by their sender
+	multiple := len(thread.Messages) > 1
+	list := tview.NewList()
+	list.SetBorder(true)
+	list.SetTitle(" Attachments (Enter: Save, o: Open) ")
+	list.SetTitleAlign(tview.AlignCenter)
+	list.ShowSecondaryText(multiple)
+	for _, attachment := range attachments {
+		text := fmt.Sprintf("%s [gray](%s, %s)[-]", tview.Escape(attachment.part.Filename), attachment.part.ContentType, approximateSize(attachment.part))
+		secondaryText := ""
+		if multiple {
+			secondaryText = fmt.Sprintf("From %s, %s", email.SenderName(attachment.msg.From), attachment.msg.Date.Format("2006-01-02 15:04"))
+		}
+		list.AddItem(text, secondaryText, 0, func() {
+			r.saveAttachment(attachment)
+		})
+	}
+	list.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Rune() == 'o' {
+			r.openAttachment(attachments[list.GetCurrentItem()])
+			return nil
+		}
+		return event
+	})
+	list.SetDoneFunc(func() {
+		r.pages.RemovePage("attachments")
+		r.focusPane("content")
+	})
+
+	height := list.GetItemCount() + 2
+	if multiple {
+		height += list.GetItemCount()
+	}
+	if height > 20 {
+		height = 20
+	}
+
+	// Center the picker
+	flex := tview.NewFlex()
+	flex.AddItem(nil, 0, 1, false)
+	flex.AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(nil, 0, 1, false).
+		AddItem(list, height, 1, true).
+		AddItem(nil, 0, 1, false),
+		70, 1, true)
+	flex.AddItem(nil, 0, 1, false)
+
+	r.pages.AddPage("attachments", flex, true, true)
+	r.app.SetFocus(list)
+}
+
+// approximateSize formats the size an attachment will have once decoded
+func approximateSize(part *email.MIMEPart) string {
+	size := part.Size
+	if part.Encoding == "base64" {
+		size = size / 4 * 3 // Line breaks make this a slight overestimate
+	}
+	switch {
+	case size < 1024:
+		return fmt.Sprintf("%d B", size)
+	case size < 1024*1024:
+		return fmt.Sprintf("%d KB", size/1024)
+	default:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1024*1024))
+	}
+}
+
+// saveAttachment downloads an attachment to the download directory in the
+// background
+func (r *EmailReader) saveAttachment(attachment threadAttachment) {
+	config, _ := email.LoadUserConfig()
+	dir, err := config.DownloadDirectory()
+	if err != nil {
+		r.statusBar.SetText(fmt.Sprintf("[red]%v", err))
+		return
+	}
+	r.statusBar.SetText(fmt.Sprintf("[gray]Saving %s...", tview.Escape(attachment.part.Filename)))
+
+	provider := r.provider
+	go func() {
+		path, err := email.SavePart(provider, attachment.msg, attachment.part, dir)
+		r.app.QueueUpdateDraw(func() {
+			if err != nil {
+				r.statusBar.SetText(fmt.Sprintf("[red]%s", tview.Escape(err.Error())))
+				return
+			}
+			r.statusBar.SetText(fmt.Sprintf("[green]Saved to %s", tview.Escape(path)))
+		})
+	}()
+}
+
+// openAttachment downloads an attachment to a temporary directory and
+// opens it with its mailcap viewer or the desktop's default application.
+// The file is left for the viewer, which may outlive the reader.
+func (r *EmailReader) openAttachment(attachment threadAttachment) {
+	r.statusBar.SetText(fmt.Sprintf("[gray]Opening %s...", tview.Escape(attachment.part.Filename)))
+
+	provider := r.provider
+	go func() {
+		dir, err := os.MkdirTemp("", "tmail-")
+		if err != nil {
+			r.app.QueueUpdateDraw(func() {
+				r.statusBar.SetText(fmt.Sprintf("[red]Failed to open %s: %v", tview.Escape(attachment.part.Filename), err))
+			})
+			return
+		}
+		path, err := email.SavePart(provider, attachment.msg, attachment.part, dir)
+		if err != nil {
+			os.RemoveAll(dir)
+			r.app.QueueUpdateDraw(func() {
+				r.statusBar.SetText(fmt.Sprintf("[red]%s", tview.Escape(err.Error())))
+			})
+			return
+		}
+
+		cmd, inTerminal := openCommand(attachment.part.ContentType, path)
+		if !inTerminal {
+			err := cmd.Start()
+			if err == nil {
+				go cmd.Wait()
+			}
+			r.app.QueueUpdateDraw(func() {
+				r.openedAttachment(attachment, err)
+			})
+			return
+		}
+
+		// Terminal viewers get the screen until they exit
+		r.app.QueueUpdateDraw(func() {
+			r.app.Suspend(func() {
+				cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+				err = cmd.Run()
+			})
+			r.openedAttachment(attachment, err)
+		})
+	}()
+}
+
+// openedAttachment reports how opening an attachment went
+func (r *EmailReader) openedAttachment(attachment threadAttachment, err error) {
+	name := tview.Escape(attachment.part.Filename)
+	if err != nil {
+		r.statusBar.SetText(fmt.Sprintf("[red]Failed to open %s: %v", name, err))
+		return
+	}
+	r.statusBar.SetText(fmt.Sprintf("[green]Opened %s", name))
+}
@@ -26,6 +26,30 @@ type EmailComposer struct {
 	debugMode   bool
 	sending     bool
 	provider    email.MailProvider
+	replyTo     *email.IncomingMessage // Email being replied to or forwarded, or nil
+	mode        ComposeMode
+	forwarded   []*email.Attachment // Attachments carried over from a forwarded email
+	forwarding  bool                // The forwarded attachments are still downloading
+}
+
+// ComposeMode says how the composer responds to an existing email
+type ComposeMode int
+
+const (
+	// ComposeReply replies to the sender of the email
+	ComposeReply ComposeMode = iota
+	// ComposeReplyAll replies to the sender and everyone else the email went to
+	ComposeReplyAll
+	// ComposeForward forwards the email inline, with its attachments
+	ComposeForward
+	// ComposeForwardAttachment forwards the email as a message/rfc822 attachment
+	ComposeForwardAttachment
+)
+
+// isForward reports whether the mode forwards the email rather than
+// replying to it
+func (m ComposeMode) isForward() bool {
+	return m == ComposeForward || m == ComposeForwardAttachment
 }
 
 // Form field indices for the email composer
@@ -44,6 +68,12 @@ const (
 
 // NewEmailComposer creates a new email composer TUI
 func NewEmailComposer(replyTo *email.IncomingMessage, provider email.MailProvider) *EmailComposer {
+	return NewResponseComposer(replyTo, ComposeReply, provider)
+}
+
+// NewResponseComposer creates an email composer that replies to or forwards
+// original as mode says. A nil original starts a new email.
+func NewResponseComposer(original *email.IncomingMessage, mode ComposeMode, provider email.MailProvider) *EmailComposer {
 	composer := &EmailComposer{
 		app:         tview.NewApplication(),
 		pages:       tview.NewPages(),
@@ -52,10 +82,13 @@ func NewEmailComposer(replyTo *email.IncomingMessage, provider email.MailProvide
 		attachments: []string{},
 		// eventually refactor to support multiple provider based on config
 		provider: provider,
+		replyTo:  original,
+		mode:     mode,
 	}
 
 	// Create form and layout
-	composer.createLayout(replyTo)
+	composer.createLayout(original)
+	composer.forwarding = original != nil && mode.isForward() && provider != nil
 
 	// Set up keyboard shortcuts
 	composer.app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
@@ -184,20 +217,65 @@ func (c *EmailComposer) createLayout(replyTo *email.IncomingMessage) {
 	c.layout.AddItem(centered, 0, 1, true)
 	c.layout.AddItem(c.statusBar, 1, 0, false)
 
+	// Start the body with the account signature, if any
+	signature := ""
+	if c.provider != nil {
+		if userInfo, err := c.provider.GetUserInfo(); err == nil && userInfo.Signature != "" {
+			signature = "\n\n-- \n" + userInfo.Signature
+		}
+	}
+	if signature != "" {
+		c.bodyArea.SetText(signature, false)
+	}
+
+	// Pre-fill form if forwarding
+	if replyTo != nil && c.mode.isForward() {
+		// Set Subject with Fwd: prefix if needed
+		subject := replyTo.Subject
+		lower := strings.ToLower(subject)
+		if !strings.HasPrefix(lower, "fwd:") && !strings.HasPrefix(lower, "fw:") {
+			subject = "Fwd: " + subject
+		}
+		c.form.GetFormItem(SubjectField).(*tview.InputField).SetText(subject)
+
+		// An email forwarded as an attachment speaks for itself
+		if c.mode == ComposeForward {
+			forwardBody := signature + "\n\n---------- Forwarded message ----------\n"
+			forwardBody += "From: " + replyTo.From + "\n"
+			forwardBody += "Date: " + replyTo.Date.Format("Mon, 02 Jan 2006 15:04:05 -0700") + "\n"
+			forwardBody += "Subject: " + replyTo.Subject + "\n"
+			forwardBody += "To: " + replyTo.To + "\n"
+			if replyTo.Cc != "" {
+				forwardBody += "Cc: " + replyTo.Cc + "\n"
+			}
+			forwardBody += "\n" + replyTo.Body
+
+			c.bodyArea.SetText(forwardBody, true)
+		}
+	}
+
 	// Pre-fill form if replying
-	if replyTo != nil {
-		// Set To field to original sender
-		c.form.GetFormItem(0).(*tview.InputField).SetText(replyTo.From)
+	if replyTo != nil && !c.mode.isForward() {
+		// Reply to the sender, or with reply-all to everyone but ourselves
+		self := ""
+		if c.provider != nil {
+			if userInfo, err := c.provider.GetUserInfo(); err == nil {
+				self = userInfo.Email
+			}
+		}
+		to, cc := email.ReplyRecipients(replyTo, self, c.mode == ComposeReplyAll)
+		c.form.GetFormItem(ToField).(*tview.InputField).SetText(strings.Join(to, ", "))
+		c.form.GetFormItem(CcField).(*tview.InputField).SetText(strings.Join(cc, ", "))
 
 		// Set Subject with Re: prefix if needed
 		subject := replyTo.Subject
 		if !strings.HasPrefix(strings.ToLower(subject), "re:") {
 			subject = "Re: " + subject
 		}
-		c.form.GetFormItem(3).(*tview.InputField).SetText(subject)
+		c.form.GetFormItem(SubjectField).(*tview.InputField).SetText(subject)
 
 		// Add reply content to body
-		replyBody := "\n\n-------- Original Message --------\n"
+		replyBody := signature + "\n\n-------- Original Message --------\n"
 		replyBody += "From: " + replyTo.From + "\n"
 		replyBody += "Date: " + replyTo.Date.Format("Mon, 02 Jan 2006 15:04:05 -0700") + "\n"
 		replyBody += "Subject: " + replyTo.Subject + "\n\n"
@@ -210,12 +288,98 @@ func (c *EmailComposer) createLayout(replyTo *email.IncomingMessage) {
 	c.pages.AddPage("main", c.layout, true, true)
 }
 
+// loadForwardedAttachments downloads what a forward carries along in the
+// background, showing its progress in the status bar. Sending waits until
+// it is done.
+func (c *EmailComposer) loadForwardedAttachments() {
+	attachments, err := fetchForwarded(c.provider, c.replyTo, c.mode, func(name string, n, total int) {
+		c.app.QueueUpdateDraw(func() {
+			c.updateStatus(fmt.Sprintf("[yellow]Attaching %s (%d of %d)...[white]", tview.Escape(name), n, total))
+		})
+	})
+
+	c.app.QueueUpdateDraw(func() {
+		c.forwarding = false
+		c.forwarded = attachments
+		c.updateAttachmentField()
+		switch {
+		case err != nil:
+			c.updateStatus(fmt.Sprintf("[red]Forward incomplete: %s[white]", tview.Escape(err.Error())))
+		case len(attachments) > 0:
+			c.updateStatus("[green]Attachments ready[white]")
+		default:
+			c.updateStatus("")
+		}
+	})
+}
+
+// forwardFetcher is the part of a provider a forward downloads with
+type forwardFetcher interface {
+	FetchRawMessage(email *email.IncomingMessage) ([]byte, error)
+	FetchPart(email *email.IncomingMessage, part *email.MIMEPart) ([]byte, error)
+}
+
+// fetchForwarded downloads what a forward of original carries along: its
+// attachments, or the whole email when it goes as an attachment. progress
+// is called before each download. What could be downloaded is returned
+// even when some of it failed.
+func fetchForwarded(provider forwardFetcher, original *email.IncomingMessage, mode ComposeMode, progress func(name string, n, total int)) ([]*email.Attachment, error) {
+	if mode == ComposeForwardAttachment {
+		filename := forwardedFilename(original.Subject)
+		progress(filename, 1, 1)
+		raw, err := provider.FetchRawMessage(original)
+		if err != nil {
+			return nil, fmt.Errorf("could not attach the original email: %v", err)
+		}
+		return []*email.Attachment{{Filename: filename, ContentType: "message/rfc822", Content: raw}}, nil
+	}
+
+	var attachments []*email.Attachment
+	var failed []string
+	parts := original.AttachmentParts()
+	for i, part := range parts {
+		progress(part.Filename, i+1, len(parts))
+		content, err := provider.FetchPart(original, part)
+		if err != nil {
+			failed = append(failed, part.Filename)
+			continue
+		}
+		attachments = append(attachments, &email.Attachment{
+			Filename:    part.Filename,
+			ContentType: part.ContentType,
+			Content:     content,
+		})
+	}
+	if len(failed) > 0 {
+		return attachments, fmt.Errorf("could not attach %s", strings.Join(failed, ", "))
+	}
+	return attachments, nil
+}
+
+// forwardedFilename names the attachment an email is forwarded as after
+// its subject
+func forwardedFilename(subject string) string {
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < ' ' {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(subject))
+	if name == "" {
+		name = "message"
+	}
+	return name + ".eml"
+}
+
 // updateAttachmentField updates the attachment field display
 func (c *EmailComposer) updateAttachmentField() {
 	// Update attachment display
 	attachText := "None"
-	if len(c.attachments) > 0 {
+	if len(c.attachments) > 0 || len(c.forwarded) > 0 {
 		var fileNames []string
+		for _, attachment := range c.forwarded {
+			fileNames = append(fileNames, attachment.Filename)
+		}
 		for _, path := range c.attachments {
 			fileNames = append(fileNames, filepath.Base(path))
 		}
@@ -234,6 +398,9 @@ func (c *EmailComposer) Run() error {
 	c.app.SetFocus(c.form)
 	c.updateStatus("Form Mode")
 	c.provider.Connect()
+	if c.forwarding {
+		go c.loadForwardedAttachments()
+	}
 	return c.app.SetRoot(c.pages, true).EnableMouse(true).Run()
 }
 
@@ -305,6 +472,10 @@ func (c *EmailComposer) sendEmail() {
 	if c.sending {
 		return
 	}
+	if c.forwarding {
+		c.updateStatus("[yellow]Still downloading the forwarded attachments...[white]")
+		return
+	}
 
 	// Update status and set sending flag
 	c.sending = true
@@ -334,38 +505,34 @@ func (c *EmailComposer) sendEmail() {
 		return
 	}
 
-	// Add recipients
-	toAddresses := strings.Split(toField.GetText(), ",")
-	for _, to := range toAddresses {
-		to = strings.TrimSpace(to)
-		if to != "" {
-			message.AddRecipient(to)
-		}
+	// Add recipients, keeping names such as "Smith, Bob" whole
+	for _, to := range email.SplitAddresses(toField.GetText()) {
+		message.AddRecipient(to)
 	}
 
 	// Add CC recipients
-	ccAddresses := strings.Split(ccField.GetText(), ",")
-	for _, cc := range ccAddresses {
-		cc = strings.TrimSpace(cc)
-		if cc != "" {
-			message.AddCC(cc)
-		}
+	for _, cc := range email.SplitAddresses(ccField.GetText()) {
+		message.AddCC(cc)
 	}
 
 	// Add BCC recipients
-	bccAddresses := strings.Split(bccField.GetText(), ",")
-	for _, bcc := range bccAddresses {
-		bcc = strings.TrimSpace(bcc)
-		if bcc != "" {
-			message.AddBCC(bcc)
-		}
+	for _, bcc := range email.SplitAddresses(bccField.GetText()) {
+		message.AddBCC(bcc)
 	}
 
 	// Set subject and body
 	message.Subject = strings.TrimSpace(subjectField.GetText())
 	message.SetTextBody(c.bodyArea.GetText())
 
+	// Keep replies in the thread they answer
+	if c.replyTo != nil && !c.mode.isForward() {
+		message.SetReplyHeaders(c.replyTo)
+	}
+
 	// Add attachments
+	for _, attachment := range c.forwarded {
+		message.AttachContent(attachment.Filename, attachment.ContentType, attachment.Content)
+	}
 	for _, path := range c.attachments {
 		if err := message.AppendAttachmentPath(path); err != nil {
 			c.showError(fmt.Sprintf("Error adding attachment %s: %v", filepath.Base(path), err))
@@ -0,0 +1,176 @@
+package ui
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// mailcapEntry is a viewer a mailcap file gives for a content type
+type mailcapEntry struct {
+	contentType   string // e.g. application/pdf or image/*
+	command       string // Shell command, with %s for the file
+	test          string // Command that must succeed for the entry to apply
+	needsTerminal bool   // The viewer runs in the terminal rather than its own window
+}
+
+// mailcapPaths returns the mailcap files to search, most personal first
+// (RFC 1524 appendix A), or those in $MAILCAPS
+func mailcapPaths() []string {
+	if paths := os.Getenv("MAILCAPS"); paths != "" {
+		return filepath.SplitList(paths)
+	}
+	home, _ := os.UserHomeDir()
+	return []string{
+		filepath.Join(home, ".mailcap"),
+		"/etc/mailcap",
+		"/usr/etc/mailcap",
+		"/usr/local/etc/mailcap",
+	}
+}
+
+// parseMailcap reads the entries of a mailcap file
+func parseMailcap(content string) []mailcapEntry {
+	var entries []mailcapEntry
+	var line strings.Builder
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		text := scanner.Text()
+		if strings.HasPrefix(strings.TrimSpace(text), "#") && line.Len() == 0 {
+			continue
+		}
+		// A backslash at the end continues the entry on the next line
+		if strings.HasSuffix(text, `\`) && !strings.HasSuffix(text, `\\`) {
+			line.WriteString(strings.TrimSuffix(text, `\`))
+			continue
+		}
+		line.WriteString(text)
+		if entry, ok := parseMailcapEntry(line.String()); ok {
+			entries = append(entries, entry)
+		}
+		line.Reset()
+	}
+	return entries
+}
+
+// parseMailcapEntry parses an entry such as
+// "application/pdf; zathura %s; test=test -n "$DISPLAY""
+func parseMailcapEntry(line string) (mailcapEntry, bool) {
+	fields := splitMailcapFields(line)
+	if len(fields) < 2 || fields[0] == "" || fields[1] == "" {
+		return mailcapEntry{}, false
+	}
+	entry := mailcapEntry{
+		contentType: strings.ToLower(fields[0]),
+		command:     fields[1],
+	}
+	if !strings.Contains(entry.contentType, "/") {
+		entry.contentType += "/*" // "image" means any image (RFC 1524 section 3)
+	}
+	for _, field := range fields[2:] {
+		key, value, _ := strings.Cut(field, "=")
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "test":
+			entry.test = strings.TrimSpace(value)
+		case "needsterminal":
+			entry.needsTerminal = true
+		case "copiousoutput":
+			// Meant for pagers inside mail readers, which would garble the UI
+			return mailcapEntry{}, false
+		}
+	}
+	return entry, true
+}
+
+// splitMailcapFields splits an entry at its semicolons, except escaped ones
+func splitMailcapFields(line string) []string {
+	var fields []string
+	var field strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line):
+			i++
+			if line[i] != ';' {
+				field.WriteByte('\\')
+			}
+			field.WriteByte(line[i])
+		case line[i] == ';':
+			fields = append(fields, strings.TrimSpace(field.String()))
+			field.Reset()
+		default:
+			field.WriteByte(line[i])
+		}
+	}
+	return append(fields, strings.TrimSpace(field.String()))
+}
+
+// matches reports whether the entry applies to a content type
+func (entry mailcapEntry) matches(contentType string) bool {
+	if entry.contentType == contentType {
+		return true
+	}
+	major, _, _ := strings.Cut(contentType, "/")
+	return entry.contentType == major+"/*"
+}
+
+// expandMailcapCommand fills a file and its content type into a mailcap
+// command, and reports whether the command names the file; if not, the
+// command reads it from standard input
+func expandMailcapCommand(command, path, contentType string) (string, bool) {
+	usesFile := strings.Contains(command, "%s")
+	// Entries often quote the file themselves
+	command = strings.NewReplacer(
+		"'%s'", shellQuote(path),
+		`"%s"`, shellQuote(path),
+		"%s", shellQuote(path),
+		"%t", shellQuote(contentType),
+		`\%`, "%",
+	).Replace(command)
+	return command, usesFile
+}
+
+// shellQuote quotes a string for sh
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// openCommand returns the command that opens a file of a content type,
+// from the first mailcap entry for it whose test passes, or the desktop's
+// default opener. It reports whether the command takes over the terminal.
+func openCommand(contentType, path string) (*exec.Cmd, bool) {
+	contentType = strings.ToLower(contentType)
+	for _, mailcap := range mailcapPaths() {
+		content, err := os.ReadFile(mailcap)
+		if err != nil {
+			continue
+		}
+		for _, entry := range parseMailcap(string(content)) {
+			if !entry.matches(contentType) {
+				continue
+			}
+			if entry.test != "" {
+				test, _ := expandMailcapCommand(entry.test, path, contentType)
+				if exec.Command("sh", "-c", test).Run() != nil {
+					continue
+				}
+			}
+			command, usesFile := expandMailcapCommand(entry.command, path, contentType)
+			if !usesFile {
+				command += " < " + shellQuote(path)
+			}
+			return exec.Command("sh", "-c", command), entry.needsTerminal
+		}
+	}
+
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", path), false
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", path), false
+	default:
+		return exec.Command("xdg-open", path), false
+	}
+}
@@ -6,7 +6,9 @@ import (
 	"time"
 
 	"github.com/gdamore/tcell/v2"
+	"github.com/jacobbanks/tmail/auth"
 	"github.com/jacobbanks/tmail/email"
+	"github.com/mattn/go-runewidth"
 	"github.com/rivo/tview"
 )
 
@@ -14,28 +16,74 @@ import (
 
 // EmailReader implements a TUI for reading emails
 type EmailReader struct {
-	app          *tview.Application
-	pages        *tview.Pages
-	emails       []*email.IncomingMessage
-	mainLayout   *tview.Flex
-	emailList    *tview.List
-	contentView  *tview.TextView
-	statusBar    *tview.TextView
-	loadingModal tview.Primitive
-	provider     email.MailProvider
-	currentView  string // "list" or "content"
-	isLoading    bool
+	app           *tview.Application
+	pages         *tview.Pages
+	emails        []*email.IncomingMessage
+	mainLayout    *tview.Flex
+	contentArea   *tview.Flex
+	header        *tview.TextView
+	sidebar       *tview.TreeView
+	emailList     *tview.List
+	contentView   *tview.TextView
+	statusBar     *tview.TextView
+	loadingModal  tview.Primitive
+	provider      email.MailProvider
+	ownsProvider  bool   // The provider is of an account switched to, which the reader disconnects
+	mailbox       string // Mailbox being read
+	currentView   string // "sidebar", "list" or "content"
+	sidebarHidden bool
+	isLoading     bool
+	pageSize      int                              // Emails fetched per page, from the default_mails setting
+	loadingMore   bool                             // Whether the next page is being fetched
+	noMoreEmails  bool                             // Whether the oldest email of the mailbox has been loaded
+	syncing       bool                             // Whether cached emails are being synced with the server
+	threads       []*email.Thread                  // The emails grouped into conversations, one per list row
+	shownThread   *email.Thread                    // Thread in the content view
+	expanded      map[*email.IncomingMessage]bool  // Emails of shownThread shown in full, the others are folded
+	bodyErrors    map[*email.IncomingMessage]error // Why bodies of shownThread failed to download
+	loadingBody   bool                             // Whether a body of shownThread is being fetched
+	showHTML      bool                             // Show the HTML part of emails that also have plain text
+	syncPending   bool                             // Whether the server reported changes while the list was busy
+	unread        int                              // Unread emails in the mailbox, or -1 while unknown
+	stopWatch     chan struct{}                    // Closed to stop watching the mailbox
+	marked        map[string]bool                  // IDs of the emails marked for a move, archive or delete
+	mailboxes     []*email.Mailbox                 // Mailboxes in the sidebar, for the folder picker
+	searchQuery   string                           // Search whose results the list shows instead of the mailbox
+	searches      int                              // Counts searches, so the results of an abandoned one are dropped
+	loads         int                              // Counts list resets, so pages fetched for an earlier list are dropped
 }
 
-// NewEmailReader creates a new email reader TUI
+// spinnerFrames animate the content pane while a message body downloads
+var spinnerFrames = []rune("⠋⠙⠹⠸⠼⠴⠦⠧⠇⠏")
+
+// NewEmailReader creates a new email reader TUI for the inbox
 func NewEmailReader(emails []*email.IncomingMessage, provider email.MailProvider) *EmailReader {
+	return newEmailReader(emails, provider, email.InboxName)
+}
+
+// NewMailboxReader creates a new email reader TUI that loads the given mailbox
+func NewMailboxReader(mailbox string, provider email.MailProvider) *EmailReader {
+	return newEmailReader(nil, provider, mailbox)
+}
+
+func newEmailReader(emails []*email.IncomingMessage, provider email.MailProvider, mailbox string) *EmailReader {
 	app := tview.NewApplication()
 
+	config, _ := email.LoadUserConfig()
+	pageSize := config.DefaultNumMails
+	if pageSize <= 0 {
+		pageSize = email.DefaultUserConfig.DefaultNumMails
+	}
+
 	reader := &EmailReader{
 		app:         app,
 		pages:       tview.NewPages(),
 		emails:      emails,
 		provider:    provider,
+		mailbox:     mailbox,
+		pageSize:    pageSize,
+		unread:      -1,
+		marked:      map[string]bool{},
 		currentView: "list",
 		isLoading:   emails == nil, // If no emails provided, we'll load them in background
 	}
@@ -47,6 +95,7 @@ func NewEmailReader(emails []*email.IncomingMessage, provider email.MailProvider
 // setupUI initializes all UI components
 func (r *EmailReader) setupUI() {
 	// Refactor UI to increase render speed
+	r.setupSidebar()
 	r.setupEmailList()
 	r.setupContentView()
 	r.setupStatusBar()
@@ -68,7 +117,7 @@ func (r *EmailReader) setupUI() {
 func (r *EmailReader) setupEmailList() {
 	r.emailList = tview.NewList()
 	r.emailList.SetBorder(true)
-	r.emailList.SetTitle(" Inbox ")
+	r.updateListTitle()
 	r.emailList.SetTitleAlign(tview.AlignCenter)
 
 	// Apply theme
@@ -95,12 +144,34 @@ func (r *EmailReader) setupEmailList() {
 	r.emailList.SetHighlightFullLine(true)
 	r.emailList.SetWrapAround(false)
 
+	// Fetch the next page when the cursor reaches the bottom
+	r.emailList.SetChangedFunc(func(index int, mainText, secondaryText string, shortcut rune) {
+		if index >= len(r.threads)-1 {
+			r.loadMoreEmails()
+		}
+	})
+
 	// Only populate if we have emails already
 	if r.emails != nil {
 		r.populateEmailList()
 	}
 }
 
+// updateListTitle shows the mailbox being read above the email list
+func (r *EmailReader) updateListTitle() {
+	if r.searchQuery != "" {
+		r.emailList.SetTitle(" " + r.mailboxDisplayName() + ": " + r.searchQuery + " ")
+		return
+	}
+	r.emailList.SetTitle(" " + r.mailboxDisplayName() + " ")
+}
+
+// mailboxDisplayName returns the short name of the open mailbox
+func (r *EmailReader) mailboxDisplayName() string {
+	mailbox := &email.Mailbox{Name: r.mailbox, Delimiter: "/"}
+	return mailbox.DisplayName()
+}
+
 // setupContentView creates and configures the email content view
 func (r *EmailReader) setupContentView() {
 	r.contentView = tview.NewTextView()
@@ -153,28 +224,29 @@ func (r *EmailReader) setupMainLayout() {
 	}
 
 	// Add a title/header
-	header := tview.NewTextView()
-	header.SetText("Email Reader")
-	header.SetTextAlign(tview.AlignCenter)
-	header.SetTextColor(headerColor)
+	r.header = tview.NewTextView()
+	r.header.SetTextAlign(tview.AlignCenter)
+	r.header.SetTextColor(headerColor)
+	r.updateHeader()
 
-	// Create a flex for email list and content view
-	contentArea := tview.NewFlex()
-	contentArea.AddItem(r.emailList, 0, 1, true)
-	contentArea.AddItem(r.contentView, 0, 2, false)
+	// Create a flex for the folder sidebar, email list and content view
+	r.contentArea = tview.NewFlex()
+	r.contentArea.AddItem(r.sidebar, sidebarWidth, 0, false)
+	r.contentArea.AddItem(r.emailList, 0, 2, true)
+	r.contentArea.AddItem(r.contentView, 0, 3, false)
 
 	// Create a centered content layout
 	centeredFlex := tview.NewFlex().
 		AddItem(nil, 0, 1, false).
 		AddItem(
 			tview.NewFlex().SetDirection(tview.FlexRow).
-				AddItem(contentArea, 0, 1, true),
+				AddItem(r.contentArea, 0, 1, true),
 			0, 3, true,
 		).
 		AddItem(nil, 0, 1, false)
 
 	// Add components to the layout with proper spacing
-	r.mainLayout.AddItem(header, 1, 0, false)
+	r.mainLayout.AddItem(r.header, 1, 0, false)
 	r.mainLayout.AddItem(tview.NewBox(), 1, 0, false) // Spacing
 	r.mainLayout.AddItem(centeredFlex, 0, 1, true)    // Content takes remaining space
 	r.mainLayout.AddItem(r.statusBar, 1, 0, false)    // Status bar at bottom
@@ -183,15 +255,32 @@ func (r *EmailReader) setupMainLayout() {
 // setupKeybindings configures global keyboard shortcuts
 func (r *EmailReader) setupKeybindings() {
 	r.app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		// Pickers handle their own keys
+		if front, _ := r.pages.GetFrontPage(); front == "accounts" || front == "folders" || front == "search" || front == "attachments" {
+			return event
+		}
+
 		// Global shortcuts
 		switch event.Key() {
 		case tcell.KeyEscape:
-			if r.currentView == "content" {
-				r.currentView = "list"
-				r.app.SetFocus(r.emailList)
-				r.updateStatusBar()
+			if r.currentView != "list" {
+				r.focusPane("list")
+				return nil
+			}
+			if r.searchQuery != "" && !r.isLoading {
+				r.clearSearch()
 				return nil
 			}
+		case tcell.KeyTab:
+			if !r.isLoading {
+				r.cyclePane(1)
+			}
+			return nil
+		case tcell.KeyBacktab:
+			if !r.isLoading {
+				r.cyclePane(-1)
+			}
+			return nil
 		case tcell.KeyRune:
 			switch event.Rune() {
 			case 'q':
@@ -200,6 +289,14 @@ func (r *EmailReader) setupKeybindings() {
 			case '?':
 				r.showHelp()
 				return nil
+			case 'A':
+				if !r.isLoading {
+					r.showAccountPicker()
+				}
+				return nil
+			case 'b':
+				r.toggleSidebar()
+				return nil
 			case 'j':
 				if r.currentView == "list" {
 					current := r.emailList.GetCurrentItem()
@@ -226,29 +323,92 @@ func (r *EmailReader) setupKeybindings() {
 					}
 					return nil
 				}
+			case 'u':
+				if r.currentView != "sidebar" {
+					r.toggleFlag(email.SeenFlag)
+					return nil
+				}
+			case 's':
+				if r.currentView != "sidebar" {
+					r.toggleFlag(email.FlaggedFlag)
+					return nil
+				}
+			case 'd':
+				if r.currentView != "sidebar" && !r.isLoading {
+					r.deleteEmail()
+					return nil
+				}
+			case '/':
+				if !r.isLoading {
+					r.showSearchPrompt()
+					return nil
+				}
+			case 'x':
+				if r.currentView == "list" {
+					r.toggleMark()
+					return nil
+				}
+			case 'e':
+				if r.currentView != "sidebar" && !r.isLoading {
+					r.archiveEmail()
+					return nil
+				}
+			case 'm':
+				if r.currentView != "sidebar" && !r.isLoading {
+					r.showFolderPicker()
+					return nil
+				}
 			case 'r':
 				if r.currentView == "content" {
-					index := r.emailList.GetCurrentItem()
-					if index >= 0 && index < len(r.emails) {
-						r.replyToEmail(index)
+					if r.shownThread != nil {
+						r.respondTo(r.shownThread.Latest(), ComposeReply)
+					}
+					return nil
+				}
+			case 'a':
+				if r.currentView == "content" {
+					if r.shownThread != nil {
+						r.respondTo(r.shownThread.Latest(), ComposeReplyAll)
 					}
 					return nil
 				}
+			case 'f':
+				if r.currentView == "content" {
+					if r.shownThread != nil {
+						r.respondTo(r.shownThread.Latest(), ComposeForward)
+					}
+					return nil
+				}
+			case 'F':
+				if r.currentView == "content" {
+					if r.shownThread != nil {
+						r.respondTo(r.shownThread.Latest(), ComposeForwardAttachment)
+					}
+					return nil
+				}
+			case 'o':
+				if r.currentView == "content" {
+					r.toggleFolded()
+					return nil
+				}
+			case 'h':
+				if r.currentView == "content" {
+					r.toggleHTML()
+					return nil
+				}
+			case 'v':
+				if r.currentView == "content" {
+					r.showAttachmentPicker()
+					return nil
+				}
 			}
 		}
 		return event
 	})
 }
 
-// showEmail displays the selected email in the content view
-func (r *EmailReader) showEmail(index int) {
-	if index < 0 || index >= len(r.emails) {
-		return
-	}
-
-	email := r.emails[index]
-
-	// Format the email for display
+// formatEmail renders the headers of an email followed by body
+func formatEmail(email *email.IncomingMessage, body string) string {
 	var content strings.Builder
 
 	// Add header information with colors
@@ -258,39 +418,31 @@ func (r *EmailReader) showEmail(index int) {
 	content.WriteString(fmt.Sprintf("[yellow]Subject:[white] %s\n", email.Subject))
 
 	// Add attachment information if present
-	// TODO: Implment Attachment Downloading
 	if len(email.Attachments) > 0 {
-		content.WriteString(fmt.Sprintf("[yellow]Attachments:[white] %s\n", strings.Join(email.Attachments, ", ")))
+		content.WriteString(fmt.Sprintf("[yellow]Attachments:[white] %s [gray](v to save or open)[white]\n", tview.Escape(strings.Join(email.Attachments, ", "))))
 	}
 
 	// Add a separator
 	content.WriteString("\n[blue]" + strings.Repeat("─", 60) + "[white]\n\n")
 
-	content.WriteString(email.Body)
-
-	// Set the content view text
-	r.contentView.SetText(content.String())
-	r.contentView.ScrollToBeginning()
-
-	// Update the view state and focus
-	r.currentView = "content"
-	r.app.SetFocus(r.contentView)
-
-	// Update the status bar
-	r.updateStatusBar()
+	content.WriteString(body)
+	return content.String()
 }
 
-// replyToEmail opens a composer to reply to the selected email
-func (r *EmailReader) replyToEmail(index int) {
-	if index < 0 || index >= len(r.emails) {
-		return
-	}
-
+// respondTo opens a composer to reply to or forward an email
+func (r *EmailReader) respondTo(msg *email.IncomingMessage, mode ComposeMode) {
 	// Stop the current application
 	r.app.Stop()
 
-	// Create and run a new email composer in reply mode
-	composer := NewEmailComposer(r.emails[index], r.provider)
+	// Quote the whole message even if it was never opened
+	if !msg.BodyLoaded {
+		if full, err := r.provider.FetchMessage(msg); err == nil {
+			msg = full
+		}
+	}
+
+	// Create and run a new email composer in the chosen mode
+	composer := NewResponseComposer(msg, mode, r.provider)
 	composer.Run()
 }
 
@@ -299,9 +451,25 @@ func (r *EmailReader) showHelp() {
 	modal := tview.NewModal().
 		SetText("Keyboard Shortcuts:\n\n" +
 			"j/k: Navigate up/down\n" +
-			"Enter: View selected email\n" +
+			"Enter: View selected email or thread\n" +
+			"o: Unfold/fold the older emails of a thread\n" +
+			"h: Switch between the plain text and HTML of an email\n" +
+			"v: Save or open the attachments of current email\n" +
 			"Esc: Return to email list\n" +
+			"Tab/Shift+Tab: Move between folders, list and email\n" +
+			"b: Show/hide the folder sidebar\n" +
 			"r: Reply to current email\n" +
+			"a: Reply to everyone on current email\n" +
+			"f/F: Forward current email inline/as an attachment\n" +
+			"u: Mark current email read/unread\n" +
+			"s: Star/unstar current email\n" +
+			"d: Move current email to the trash\n" +
+			"e: Archive current email\n" +
+			"m: Move current email to another folder\n" +
+			"x: Mark email for d/e/m (they then act on all marked emails)\n" +
+			"/: Search the folder (from: to: subject: since: before: has:attachment is:unread)\n" +
+			"Esc: Leave search results\n" +
+			"A: Switch account\n" +
 			"q: Quit\n" +
 			"?: Show this help").
 		AddButtons([]string{"OK"}).
@@ -333,56 +501,234 @@ func (r *EmailReader) showHelp() {
 	r.app.SetFocus(modal)
 }
 
+// updateHeader shows the account being read in the header
+func (r *EmailReader) updateHeader() {
+	title := "Email Reader"
+	if r.provider != nil {
+		if userInfo, err := r.provider.GetUserInfo(); err == nil && userInfo.Email != "" {
+			title += " - " + userInfo.Email
+		}
+	}
+	if r.unread >= 0 {
+		title += fmt.Sprintf(" (%d unread)", r.unread)
+	}
+	r.header.SetText(title)
+}
+
+// showAccountPicker lets the user switch to another configured account
+func (r *EmailReader) showAccountPicker() {
+	names, defaultName, err := auth.ListAccounts()
+	if err != nil {
+		r.showModalError(fmt.Sprintf("Error loading accounts: %v", err))
+		return
+	}
+	if len(names) < 2 {
+		r.showModalError("No other accounts configured.\nAdd one with: tmail auth add <account>")
+		return
+	}
+
+	list := tview.NewList()
+	list.SetBorder(true)
+	list.SetTitle(" Switch Account ")
+	list.SetTitleAlign(tview.AlignCenter)
+	list.ShowSecondaryText(false)
+	for i, name := range names {
+		label := name
+		if name == defaultName {
+			label += " (default)"
+		}
+		account := name
+		list.AddItem(label, "", rune('1'+i), func() {
+			r.pages.RemovePage("accounts")
+			r.switchAccount(account)
+		})
+	}
+	list.SetDoneFunc(func() {
+		r.pages.RemovePage("accounts")
+		r.app.SetFocus(r.emailList)
+	})
+
+	// Center the picker
+	flex := tview.NewFlex()
+	flex.AddItem(nil, 0, 1, false)
+	flex.AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(nil, 0, 1, false).
+		AddItem(list, len(names)+2, 1, true).
+		AddItem(nil, 0, 1, false),
+		40, 1, true)
+	flex.AddItem(nil, 0, 1, false)
+
+	r.pages.AddPage("accounts", flex, true, true)
+	r.app.SetFocus(list)
+}
+
+// switchAccount replaces the provider with the named account's and reloads the inbox
+func (r *EmailReader) switchAccount(account string) {
+	provider, err := email.CreateMailProvider(account)
+	if err != nil {
+		r.showModalError(fmt.Sprintf("Error switching to account %s: %v", account, err))
+		return
+	}
+
+	// New messages and replies should now come from this account
+	auth.SetActiveAccount(account)
+	if r.ownsProvider {
+		go r.provider.Disconnect() // Waits for anything still using it
+	}
+	r.provider = provider
+	r.ownsProvider = true
+	r.mailbox = email.InboxName
+	r.resetEmails()
+	r.sidebar.SetRoot(tview.NewTreeNode(""))
+	r.mailboxes = nil
+	r.currentView = "list"
+	r.updateHeader()
+	r.updateListTitle()
+	r.updateStatusBar()
+
+	r.isLoading = true
+	r.showLoading()
+	go r.fetchEmails()
+}
+
 // updateStatusBar updates the status bar based on the current view
 func (r *EmailReader) updateStatusBar() {
-	if r.currentView == "list" {
-		r.statusBar.SetText("[blue]j/k[white]: Navigate | [blue]Enter[white]: View Email | [blue]q[white]: Quit")
-	} else {
-		r.statusBar.SetText("[blue]j/k[white]: Scroll | [blue]Esc[white]: Back to List | [blue]r[white]: Reply | [blue]q[white]: Quit")
+	switch r.currentView {
+	case "sidebar":
+		r.statusBar.SetText("[blue]j/k[white]: Navigate | [blue]Enter[white]: Open Folder | [blue]Tab[white]: Next Pane | [blue]b[white]: Hide Folders | [blue]q[white]: Quit")
+	case "list":
+		r.statusBar.SetText("[blue]j/k[white]: Navigate | [blue]Enter[white]: View Email | [blue]u/s/d/e/m[white]: Read/Star/Delete/Archive/Move | [blue]x[white]: Mark | [blue]Tab[white]: Next Pane | [blue]A[white]: Accounts | [blue]q[white]: Quit")
+	default:
+		r.statusBar.SetText("[blue]j/k[white]: Scroll | [blue]Esc[white]: Back to List | [blue]r/a[white]: Reply/All | [blue]f[white]: Forward | [blue]o[white]: Unfold Thread | [blue]h[white]: HTML | [blue]v[white]: Attachments | [blue]q[white]: Quit")
 	}
 }
 
-// populateEmailList adds emails to the list view
+// populateEmailList groups the emails into threads and lists them, one
+// row per thread
 func (r *EmailReader) populateEmailList() {
 	// Clear existing items
 	r.emailList.Clear()
 
-	// Add emails to the list
-	for i, email := range r.emails {
-		// Format the date for display
-		date := email.Date.Format("2006-01-02 15:04")
+	// Add threads to the list
+	r.threads = email.ThreadMessages(r.emails)
+	for i := range r.threads {
+		r.addThreadItem(i)
+	}
+}
 
-		// Format the subject (truncate if too long)
-		subject := email.Subject
-		if len(subject) > 40 {
-			subject = subject[:37] + "..."
-		}
+// addThreadItem adds the thread at index to the end of the list view
+func (r *EmailReader) addThreadItem(index int) {
+	text, secondaryText := r.threadItemText(r.threads[index])
 
-		// Format the sender (extract just the name or email address)
-		sender := email.From
-		if idx := strings.LastIndex(sender, "<"); idx > 0 {
-			sender = strings.TrimSpace(sender[:idx])
-		}
-		if len(sender) > 25 {
-			sender = sender[:22] + "..."
-		}
+	// Only the first screenful gets letter shortcuts
+	var shortcut rune
+	if index < 26 {
+		shortcut = rune('a' + index)
+	}
+	r.emailList.AddItem(text, secondaryText, shortcut, func() {
+		r.showThread(index)
+	})
+}
 
-		// Add attachment indicator if needed
-		attachmentIndicator := ""
-		if len(email.Attachments) > 0 {
-			attachmentIndicator = "📎 "
-		}
+// emailItemText formats the list row of an email. Unread emails are bold,
+// starred ones are marked and ones marked for a move are ticked.
+func emailItemText(email *email.IncomingMessage, marked bool) (string, string) {
+	// Format the date for display
+	date := email.Date.Format("2006-01-02 15:04")
 
-		// Create list item with formatted details
-		text := fmt.Sprintf("%s  %s%s", date, attachmentIndicator, subject)
-		secondaryText := fmt.Sprintf("From: %s", sender)
+	// Format the subject (truncate if too long, by the columns it takes up)
+	subject := runewidth.Truncate(email.Subject, 40, "...")
 
-		// Create a fixed value for the closure to capture
-		index := i
-		r.emailList.AddItem(text, secondaryText, rune('a'+i), func() {
-			r.showEmail(index)
-		})
+	// Format the sender (extract just the name or email address)
+	sender := email.From
+	if idx := strings.LastIndex(sender, "<"); idx > 0 {
+		sender = strings.TrimSpace(sender[:idx])
+	}
+	sender = runewidth.Truncate(sender, 25, "...")
+
+	// Add attachment indicator if needed
+	attachmentIndicator := ""
+	if len(email.Attachments) > 0 {
+		attachmentIndicator = "📎 "
 	}
+
+	// Mark starred emails
+	starIndicator := ""
+	if email.Flagged() {
+		starIndicator = "[yellow]★[-] "
+	}
+
+	// Tick emails marked for a move
+	markIndicator := ""
+	if marked {
+		markIndicator = "[green]✔[-] "
+	}
+
+	// Create list item with formatted details
+	text := fmt.Sprintf("%s%s  %s%s%s", markIndicator, date, starIndicator, attachmentIndicator, subject)
+	secondaryText := fmt.Sprintf("From: %s", sender)
+	if !email.Seen() {
+		text = "[::b]" + text
+		secondaryText = "[::b]" + secondaryText
+	}
+	return text, secondaryText
+}
+
+// loadMoreEmails fetches the page of emails older than the ones shown
+func (r *EmailReader) loadMoreEmails() {
+	if r.provider == nil || r.isLoading || r.syncing || r.loadingMore || r.noMoreEmails || len(r.emails) == 0 {
+		return
+	}
+	r.loadingMore = true
+	r.emailList.AddItem("[gray]Loading more emails...", "", 0, nil)
+
+	provider, mailbox, load := r.provider, r.mailbox, r.loads
+	before := r.emails[len(r.emails)-1].UID
+	go func() {
+		emails, err := provider.GetEmailsPage(mailbox, before, r.pageSize)
+
+		r.app.QueueUpdateDraw(func() {
+			if load != r.loads {
+				return // The list was reloaded for another folder, account or search
+			}
+			r.loadingMore = false
+			r.emailList.RemoveItem(r.emailList.GetItemCount() - 1)
+
+			if err != nil {
+				r.statusBar.SetText(fmt.Sprintf("[red]Error loading more emails: %v", err))
+				return
+			}
+			if len(emails) == 0 {
+				r.noMoreEmails = true
+				return
+			}
+
+			// Older emails may belong to threads already listed
+			r.replaceEmails(append(r.emails, emails...))
+			if r.syncPending {
+				r.syncMailbox()
+			}
+		})
+	}()
+}
+
+// resetEmails empties the list before another mailbox or account is loaded
+func (r *EmailReader) resetEmails() {
+	r.loads++
+	r.emails = nil
+	r.loadingMore = false
+	r.noMoreEmails = false
+	r.syncing = false
+	r.syncPending = false
+	r.marked = map[string]bool{}
+	r.searchQuery = ""
+	r.threads = nil
+	r.shownThread = nil
+	r.unread = -1
+	r.updateHeader()
+	r.stopWatching()
+	r.emailList.Clear()
+	r.contentView.Clear()
 }
 
 // setupLoadingModal creates a loading indicator
@@ -425,34 +771,109 @@ func (r *EmailReader) showLoading() {
 func (r *EmailReader) hideLoading() {
 	r.isLoading = false
 	r.pages.SwitchToPage("main")
-	r.app.SetFocus(r.emailList)
+	r.focusPane("list")
 }
 
-// fetchEmails loads emails in background
+// fetchEmails loads emails in background. Cached emails are shown right
+// away while the mailbox syncs with the server.
 func (r *EmailReader) fetchEmails() {
-	// First connect to the provider
-	if err := r.provider.Connect(); err != nil {
+	provider, mailbox := r.provider, r.mailbox
+	current := func() bool {
+		return provider == r.provider && mailbox == r.mailbox
+	}
+
+	if cached, _ := provider.CachedEmails(mailbox, r.pageSize); len(cached) > 0 {
 		r.app.QueueUpdateDraw(func() {
-			r.showModalError(fmt.Sprintf("Error connecting to mail server: %v", err))
+			if !current() {
+				return
+			}
+			r.emails = cached
+			r.syncing = true
+			r.populateEmailList()
+			r.hideLoading()
+			r.statusBar.SetText("[gray]Syncing...")
 		})
-		return
 	}
 
+	// First connect to the provider
+	err := provider.Connect()
+
 	// Then fetch emails
-	emails, err := r.provider.GetEmails(25)
+	var emails []*email.IncomingMessage
+	var status *email.MailboxStatus
+	if err == nil {
+		emails, err = provider.GetEmailsPage(mailbox, 0, r.pageSize)
+	} else {
+		err = fmt.Errorf("cannot connect to mail server: %v", err)
+	}
+	if err == nil {
+		status, _ = provider.MailboxStatus(mailbox)
+	}
 
 	// Update UI on main thread
 	r.app.QueueUpdateDraw(func() {
+		if !current() {
+			return
+		}
+		if r.syncing {
+			// The cached emails are on screen, so don't interrupt with a modal
+			r.syncing = false
+			if err != nil {
+				r.statusBar.SetText(fmt.Sprintf("[red]Offline, showing cached emails: %v", err))
+				return
+			}
+			r.replaceEmails(emails)
+			r.noMoreEmails = len(emails) < r.pageSize
+			r.updateStatusBar()
+			r.setUnread(status)
+			r.watchMailbox()
+			return
+		}
+
 		if err != nil {
 			r.showModalError(fmt.Sprintf("Error loading emails: %v", err))
-		} else if len(emails) == 0 {
-			r.showModalError("No emails found.")
 		} else {
 			r.emails = emails
+			r.noMoreEmails = len(emails) < r.pageSize
 			r.populateEmailList()
+			if len(emails) == 0 {
+				r.emailList.AddItem("No emails found.", "", 0, nil)
+			}
 			r.hideLoading()
+			r.setUnread(status)
+			r.watchMailbox()
 		}
 	})
+
+	// Refresh the folders and their unread counts
+	if err == nil {
+		r.loadMailboxes(provider)
+	}
+}
+
+// replaceEmails swaps the list for freshly synced emails, keeping the
+// selected thread selected
+func (r *EmailReader) replaceEmails(emails []*email.IncomingMessage) {
+	selected := map[string]bool{}
+	if _, thread := r.selectedThread(); thread != nil {
+		for _, msg := range thread.Messages {
+			selected[msg.ID()] = true
+		}
+	}
+
+	r.emails = emails
+	r.populateEmailList()
+	if len(emails) == 0 {
+		r.emailList.AddItem("No emails found.", "", 0, nil)
+	}
+	for i, thread := range r.threads {
+		for _, msg := range thread.Messages {
+			if selected[msg.ID()] {
+				r.emailList.SetCurrentItem(i)
+				return
+			}
+		}
+	}
 }
 
 // showModalError displays an error message in a modal dialog
@@ -502,7 +923,18 @@ func (r *EmailReader) Run() error {
 		}
 	}()
 
+	// Keep the open mailbox in sync while the reader runs. Emails handed in
+	// up front are already loaded; otherwise fetchEmails starts watching.
+	if !r.isLoading && r.provider != nil {
+		r.watchMailbox()
+	}
+	defer r.stopWatching()
+
 	// Start the application
 	r.app.SetRoot(r.pages, true).EnableMouse(true)
-	return r.app.Run()
+	err := r.app.Run()
+	if r.ownsProvider {
+		r.provider.Disconnect()
+	}
+	return err
 }
@@ -0,0 +1,113 @@
+package ui
+
+import (
+	"fmt"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/jacobbanks/tmail/email"
+	"github.com/rivo/tview"
+)
+
+// showSearchPrompt asks for a search query, see email.ParseSearchQuery
+func (r *EmailReader) showSearchPrompt() {
+	input := tview.NewInputField()
+	input.SetLabel("/")
+	input.SetText(r.searchQuery)
+	input.SetFieldBackgroundColor(tcell.ColorBlack)
+	input.SetBorder(true)
+	input.SetTitle(" Search " + r.mailboxDisplayName() + " ")
+	input.SetTitleAlign(tview.AlignLeft)
+	input.SetDoneFunc(func(key tcell.Key) {
+		r.pages.RemovePage("search")
+		r.focusPane("list")
+		if key == tcell.KeyEnter {
+			r.search(input.GetText())
+		}
+	})
+
+	// Center the prompt
+	flex := tview.NewFlex()
+	flex.AddItem(nil, 0, 1, false)
+	flex.AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(nil, 0, 1, false).
+		AddItem(input, 3, 1, true).
+		AddItem(nil, 0, 1, false),
+		70, 1, true)
+	flex.AddItem(nil, 0, 1, false)
+
+	r.pages.AddPage("search", flex, true, true)
+	r.app.SetFocus(input)
+}
+
+// search searches the open mailbox and replaces the list with the results.
+// Matches from the local index show right away and are replaced by the
+// server's once they arrive. Esc brings the mailbox back.
+func (r *EmailReader) search(text string) {
+	query, err := email.ParseSearchQuery(text)
+	if err != nil {
+		r.statusBar.SetText(fmt.Sprintf("[red]%v", err))
+		return
+	}
+	if r.provider == nil {
+		return
+	}
+	r.statusBar.SetText("[gray]Searching...")
+
+	r.searches++
+	provider, mailbox, search := r.provider, r.mailbox, r.searches
+	n := r.pageSize
+	go func() {
+		// abandoned reports whether the user moved on to another folder,
+		// account or search
+		abandoned := func() bool {
+			return provider != r.provider || mailbox != r.mailbox || search != r.searches
+		}
+
+		cached, _ := provider.SearchOffline(mailbox, query, n)
+		if len(cached) > 0 {
+			r.app.QueueUpdateDraw(func() {
+				if !abandoned() {
+					r.showSearchResults(text, cached)
+					r.statusBar.SetText("[gray]Searching the server...")
+				}
+			})
+		}
+
+		emails, err := provider.Search(mailbox, query, n)
+
+		r.app.QueueUpdateDraw(func() {
+			if abandoned() {
+				return
+			}
+			if err != nil {
+				if len(cached) > 0 {
+					r.statusBar.SetText(fmt.Sprintf("[red]Offline, showing cached results: %v", err))
+				} else {
+					r.statusBar.SetText(fmt.Sprintf("[red]Search failed: %v", err))
+				}
+				return
+			}
+			r.showSearchResults(text, emails)
+		})
+	}()
+}
+
+// showSearchResults replaces the list with the results of a search
+func (r *EmailReader) showSearchResults(text string, emails []*email.IncomingMessage) {
+	r.resetEmails()
+	r.searchQuery = text
+	r.noMoreEmails = true // Results come in one page
+	r.emails = emails
+	r.populateEmailList()
+	if len(emails) == 0 {
+		r.emailList.AddItem("No emails found.", "", 0, nil)
+	}
+	r.updateListTitle()
+	r.focusPane("list")
+}
+
+// clearSearch replaces the search results with the mailbox
+func (r *EmailReader) clearSearch() {
+	r.searches++
+	r.openMailbox(r.mailbox)
+}
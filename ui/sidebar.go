@@ -0,0 +1,217 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/jacobbanks/tmail/email"
+	"github.com/rivo/tview"
+)
+
+// sidebarWidth is the width of the folder sidebar when it is shown
+const sidebarWidth = 28
+
+// setupSidebar creates the folder sidebar. It is filled in once the mailboxes
+// have been listed by loadMailboxes.
+func (r *EmailReader) setupSidebar() {
+	r.sidebar = tview.NewTreeView()
+	r.sidebar.SetBorder(true)
+	r.sidebar.SetTitle(" Folders ")
+	r.sidebar.SetTitleAlign(tview.AlignCenter)
+
+	// Apply theme
+	config, _ := email.LoadUserConfig()
+	var borderColor tcell.Color
+	switch config.Theme {
+	case "dark":
+		borderColor = tcell.ColorDarkBlue
+	case "light":
+		borderColor = tcell.ColorLightBlue
+	default:
+		borderColor = tcell.ColorSteelBlue
+	}
+	r.sidebar.SetBorderColor(borderColor)
+	r.sidebar.SetGraphicsColor(tcell.ColorGray)
+	r.sidebar.SetRoot(tview.NewTreeNode(""))
+	r.sidebar.SetTopLevel(1) // Hide the root
+
+	r.sidebar.SetSelectedFunc(func(node *tview.TreeNode) {
+		mailbox, ok := node.GetReference().(*email.Mailbox)
+		if !ok || !mailbox.Selectable() {
+			// Folders that only hold other folders just expand and collapse
+			node.SetExpanded(!node.IsExpanded())
+			return
+		}
+		r.openMailbox(mailbox.Name)
+	})
+}
+
+// mailboxStatus pairs a mailbox with its counts, which may be missing
+type mailboxStatus struct {
+	mailbox *email.Mailbox
+	status  *email.MailboxStatus
+}
+
+// loadMailboxes lists the mailboxes of provider and their unread counts and
+// shows them in the sidebar, unless the account was switched meanwhile. It
+// runs in the background.
+func (r *EmailReader) loadMailboxes(provider email.MailProvider) {
+	mailboxes, err := provider.ListMailboxes()
+	if err != nil {
+		r.app.QueueUpdateDraw(func() {
+			if provider == r.provider {
+				r.sidebar.SetTitle(" Folders (unavailable) ")
+			}
+		})
+		return
+	}
+
+	entries := make([]mailboxStatus, 0, len(mailboxes))
+	for _, mailbox := range mailboxes {
+		entry := mailboxStatus{mailbox: mailbox}
+		if mailbox.Selectable() {
+			// A folder without counts is still worth showing
+			entry.status, _ = provider.MailboxStatus(mailbox.Name)
+		}
+		entries = append(entries, entry)
+	}
+
+	r.app.QueueUpdateDraw(func() {
+		if provider != r.provider {
+			return // The folders of another account
+		}
+		r.populateSidebar(entries)
+	})
+}
+
+// populateSidebar rebuilds the folder tree, nesting mailboxes by their
+// hierarchy delimiter and keeping the current folder selected
+func (r *EmailReader) populateSidebar(entries []mailboxStatus) {
+	root := tview.NewTreeNode("")
+	nodes := map[string]*tview.TreeNode{}
+	var current *tview.TreeNode
+
+	for _, entry := range entries {
+		mailbox := entry.mailbox
+		node := nodes[mailbox.Name]
+		if node == nil {
+			node = tview.NewTreeNode("")
+			nodes[mailbox.Name] = node
+			parentFor(root, nodes, mailbox).AddChild(node)
+		}
+
+		text := mailbox.DisplayName()
+		node.SetColor(tcell.ColorWhite)
+		if entry.status != nil && entry.status.Unseen > 0 {
+			text = fmt.Sprintf("%s (%d)", text, entry.status.Unseen)
+			node.SetColor(tcell.ColorYellow)
+		}
+		if !mailbox.Selectable() {
+			node.SetColor(tcell.ColorGray)
+		}
+		node.SetText(text)
+		node.SetReference(mailbox)
+
+		if mailbox.Name == r.mailbox {
+			current = node
+		}
+	}
+
+	r.mailboxes = make([]*email.Mailbox, len(entries))
+	for i, entry := range entries {
+		r.mailboxes[i] = entry.mailbox
+	}
+
+	r.sidebar.SetRoot(root)
+	if current != nil {
+		r.sidebar.SetCurrentNode(current)
+	}
+	r.sidebar.SetTitle(" Folders ")
+}
+
+// parentFor returns the node a mailbox belongs under, creating placeholder
+// nodes for parents the server did not list
+func parentFor(root *tview.TreeNode, nodes map[string]*tview.TreeNode, mailbox *email.Mailbox) *tview.TreeNode {
+	if mailbox.Delimiter == "" {
+		return root
+	}
+	i := strings.LastIndex(mailbox.Name, mailbox.Delimiter)
+	if i <= 0 {
+		return root
+	}
+
+	parentName := mailbox.Name[:i]
+	parent := nodes[parentName]
+	if parent == nil {
+		placeholder := &email.Mailbox{Name: parentName, Delimiter: mailbox.Delimiter}
+		parent = tview.NewTreeNode(placeholder.DisplayName()).SetColor(tcell.ColorGray)
+		nodes[parentName] = parent
+		parentFor(root, nodes, placeholder).AddChild(parent)
+	}
+	return parent
+}
+
+// openMailbox switches the message list to another mailbox
+func (r *EmailReader) openMailbox(name string) {
+	if r.isLoading {
+		return
+	}
+
+	r.mailbox = name
+	r.resetEmails()
+	r.updateListTitle()
+	r.focusPane("list")
+
+	r.isLoading = true
+	r.showLoading()
+	go r.fetchEmails()
+}
+
+// toggleSidebar shows or hides the folder sidebar
+func (r *EmailReader) toggleSidebar() {
+	r.sidebarHidden = !r.sidebarHidden
+	if r.sidebarHidden {
+		r.contentArea.ResizeItem(r.sidebar, 0, 0)
+		if r.currentView == "sidebar" {
+			r.focusPane("list")
+		}
+	} else {
+		r.contentArea.ResizeItem(r.sidebar, sidebarWidth, 0)
+	}
+}
+
+// panes lists the panes Tab cycles through, in screen order
+func (r *EmailReader) panes() []string {
+	if r.sidebarHidden {
+		return []string{"list", "content"}
+	}
+	return []string{"sidebar", "list", "content"}
+}
+
+// cyclePane moves the focus to the next (or previous) pane
+func (r *EmailReader) cyclePane(step int) {
+	panes := r.panes()
+	index := 0
+	for i, pane := range panes {
+		if pane == r.currentView {
+			index = i
+		}
+	}
+	index = (index + step + len(panes)) % len(panes)
+	r.focusPane(panes[index])
+}
+
+// focusPane focuses one of the sidebar, list and content panes
+func (r *EmailReader) focusPane(pane string) {
+	r.currentView = pane
+	switch pane {
+	case "sidebar":
+		r.app.SetFocus(r.sidebar)
+	case "content":
+		r.app.SetFocus(r.contentView)
+	default:
+		r.app.SetFocus(r.emailList)
+	}
+	r.updateStatusBar()
+}
@@ -0,0 +1,134 @@
+package ui
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jacobbanks/tmail/email"
+)
+
+// watchRetryDelay is how long to wait before watching the mailbox again
+// after the watch connection drops
+const watchRetryDelay = 30 * time.Second
+
+// watchMailbox watches the open mailbox for changes on the server, syncing
+// it whenever the server reports one, and stops watching the previous
+// mailbox. It runs on the UI thread.
+func (r *EmailReader) watchMailbox() {
+	r.stopWatching()
+	stop := make(chan struct{})
+	r.stopWatch = stop
+
+	provider, mailbox := r.provider, r.mailbox
+	notify := func() {
+		r.app.QueueUpdate(r.syncMailbox)
+	}
+	go func() {
+		for {
+			if err := provider.WatchMailbox(mailbox, stop, notify); err == nil {
+				return // Stopped
+			}
+			select {
+			case <-stop:
+				return
+			case <-time.After(watchRetryDelay):
+			}
+			// Catch up on what changed while the watcher was away
+			notify()
+		}
+	}()
+}
+
+// stopWatching stops watching the open mailbox
+func (r *EmailReader) stopWatching() {
+	if r.stopWatch != nil {
+		close(r.stopWatch)
+		r.stopWatch = nil
+	}
+}
+
+// syncMailbox fetches what changed in the open mailbox in the background
+// and applies it to the list. A sync asked for while the list is busy runs
+// once it is done.
+func (r *EmailReader) syncMailbox() {
+	if r.isLoading || r.searchQuery != "" {
+		return // The whole list is on its way, or shows search results
+	}
+	if r.syncing || r.loadingMore {
+		r.syncPending = true
+		return
+	}
+	r.syncing = true
+	r.syncPending = false
+
+	provider, mailbox := r.provider, r.mailbox
+	n := r.pageSize
+	go func() {
+		changes, err := provider.SyncMailbox(mailbox, n)
+		var status *email.MailboxStatus
+		if err == nil {
+			status, _ = provider.MailboxStatus(mailbox)
+		}
+
+		r.app.QueueUpdateDraw(func() {
+			if provider != r.provider || mailbox != r.mailbox || r.searchQuery != "" {
+				return // The user moved on to another folder, account or search
+			}
+			r.syncing = false
+			if err != nil {
+				r.statusBar.SetText(fmt.Sprintf("[red]Sync failed: %v", err))
+				return
+			}
+			r.setUnread(status)
+			r.applyChanges(changes)
+			if r.syncPending {
+				r.syncMailbox()
+			}
+		})
+	}()
+}
+
+// setUnread shows the unread count of the open mailbox in the header. A
+// missing status leaves the count as it was.
+func (r *EmailReader) setUnread(status *email.MailboxStatus) {
+	if status == nil {
+		return
+	}
+	r.unread = int(status.Unseen)
+	r.updateHeader()
+}
+
+// applyChanges updates the list with the changes of a sync: new emails go
+// on top, expunged ones disappear and changed flags are copied over, all
+// without fetching the list again
+func (r *EmailReader) applyChanges(changes *email.MailboxChanges) {
+	if changes.Reset {
+		r.openMailbox(r.mailbox)
+		return
+	}
+	if changes.Empty() {
+		return
+	}
+
+	expunged := make(map[uint32]bool, len(changes.Expunged))
+	for _, uid := range changes.Expunged {
+		expunged[uid] = true
+	}
+	updated := make(map[uint32]*email.IncomingMessage, len(changes.Updated))
+	for _, msg := range changes.Updated {
+		updated[msg.UID] = msg
+	}
+
+	emails := make([]*email.IncomingMessage, 0, len(changes.New)+len(r.emails))
+	emails = append(emails, changes.New...)
+	for _, msg := range r.emails {
+		if expunged[msg.UID] {
+			continue
+		}
+		if changed, ok := updated[msg.UID]; ok {
+			msg.Flags = changed.Flags
+		}
+		emails = append(emails, msg)
+	}
+	r.replaceEmails(emails)
+}
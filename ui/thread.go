@@ -0,0 +1,237 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jacobbanks/tmail/email"
+	"github.com/mattn/go-runewidth"
+)
+
+// threadItemText formats the list row of a thread. A single email gets the
+// usual email row; a conversation is collapsed into one row with its
+// participants and the number of emails.
+func (r *EmailReader) threadItemText(thread *email.Thread) (string, string) {
+	latest := thread.Latest()
+	if len(thread.Messages) == 1 {
+		return emailItemText(latest, r.marked[latest.ID()])
+	}
+
+	date := latest.Date.Format("2006-01-02 15:04")
+
+	subject := runewidth.Truncate(thread.Subject(), 35, "...")
+	participants := runewidth.Truncate(strings.Join(thread.Participants(), ", "), 40, "...")
+
+	attachmentIndicator := ""
+	if thread.HasAttachments() {
+		attachmentIndicator = "📎 "
+	}
+
+	starIndicator := ""
+	if thread.Flagged() {
+		starIndicator = "[yellow]★[-] "
+	}
+
+	markIndicator := ""
+	if r.threadMarked(thread) {
+		markIndicator = "[green]✔[-] "
+	}
+
+	text := fmt.Sprintf("%s%s  %s%s%s [gray](%d)[-]", markIndicator, date, starIndicator, attachmentIndicator, subject, len(thread.Messages))
+	secondaryText := fmt.Sprintf("From: %s", participants)
+	if thread.Unread() > 0 {
+		text = "[::b]" + text
+		secondaryText = "[::b]" + secondaryText
+	}
+	return text, secondaryText
+}
+
+// showThread displays the selected thread in the content view. The newest
+// email and the unread ones are shown in full, older ones are folded to a
+// line; their bodies are downloaded first if the list came without them.
+func (r *EmailReader) showThread(index int) {
+	if index < 0 || index >= len(r.threads) {
+		return
+	}
+
+	thread := r.threads[index]
+	r.shownThread = thread
+	r.expanded = map[*email.IncomingMessage]bool{thread.Latest(): true}
+	r.bodyErrors = map[*email.IncomingMessage]error{}
+	for _, msg := range thread.Messages {
+		if !msg.Seen() {
+			r.expanded[msg] = true
+		}
+	}
+	for _, msg := range thread.Messages {
+		if !msg.Seen() {
+			r.setFlag(msg, email.SeenFlag, true)
+		}
+	}
+	r.renderThread(string(spinnerFrames[0]))
+	r.contentView.ScrollToBeginning()
+	r.loadBodies()
+
+	// Update the view state and focus
+	r.currentView = "content"
+	r.app.SetFocus(r.contentView)
+
+	// Update the status bar
+	r.updateStatusBar()
+}
+
+// toggleFolded unfolds every email of the shown thread, or folds all but
+// the newest again
+func (r *EmailReader) toggleFolded() {
+	thread := r.shownThread
+	if thread == nil || len(thread.Messages) == 1 {
+		return
+	}
+	unfold := len(r.expanded) < len(thread.Messages)
+	r.expanded = map[*email.IncomingMessage]bool{thread.Latest(): true}
+	if unfold {
+		for _, msg := range thread.Messages {
+			r.expanded[msg] = true
+		}
+	}
+	r.renderThread(string(spinnerFrames[0]))
+	r.loadBodies()
+}
+
+// renderThread shows the shown thread in the content view, with spinner
+// standing in for bodies still on their way
+func (r *EmailReader) renderThread(spinner string) {
+	thread := r.shownThread
+	if thread == nil {
+		return
+	}
+	if len(thread.Messages) == 1 {
+		r.contentView.SetText(formatEmail(thread.Latest(), r.threadBody(thread.Latest(), spinner)))
+		return
+	}
+
+	var content strings.Builder
+	content.WriteString(fmt.Sprintf("[yellow]Thread:[white] %s [gray](%d emails)[white]\n\n", thread.Subject(), len(thread.Messages)))
+	for _, msg := range thread.Messages {
+		if !r.expanded[msg] {
+			attachmentIndicator := ""
+			if len(msg.Attachments) > 0 {
+				attachmentIndicator = " 📎"
+			}
+			content.WriteString(fmt.Sprintf("[gray]▸ %s  %s%s[white]\n", msg.Date.Format("2006-01-02 15:04"), email.SenderName(msg.From), attachmentIndicator))
+			continue
+		}
+		content.WriteString("\n")
+		content.WriteString(formatEmail(msg, r.threadBody(msg, spinner)))
+		content.WriteString("\n\n")
+	}
+	r.contentView.SetText(content.String())
+}
+
+// threadBody returns what to show for the body of an email in the thread
+// view: the body, the error that kept it away, or a spinner
+func (r *EmailReader) threadBody(msg *email.IncomingMessage, spinner string) string {
+	if msg.BodyLoaded {
+		return r.bodyText(msg)
+	}
+	if err, ok := r.bodyErrors[msg]; ok {
+		return fmt.Sprintf("[red]Error loading message: %v[white]", err)
+	}
+	return "[gray]" + spinner + " Loading message...[white]"
+}
+
+// bodyText returns the body of an email as the reader shows it: its HTML
+// part rendered for the terminal when it has no plain text or the user
+// asked for HTML, otherwise the plain text
+func (r *EmailReader) bodyText(msg *email.IncomingMessage) string {
+	if msg.HTMLBody == "" || !(msg.HTMLOnly || r.showHTML) {
+		return msg.Body
+	}
+	_, _, width, _ := r.contentView.GetInnerRect()
+	return email.RenderHTML(msg.HTMLBody, width-1, true)
+}
+
+// toggleHTML switches the shown emails between their plain text and HTML
+// parts
+func (r *EmailReader) toggleHTML() {
+	r.showHTML = !r.showHTML
+	r.renderThread(string(spinnerFrames[0]))
+	if r.showHTML {
+		r.statusBar.SetText("[gray]Showing the HTML of emails that have it")
+	} else {
+		r.statusBar.SetText("[gray]Showing plain text, or HTML for emails without it")
+	}
+}
+
+// missingBody returns the first unfolded email of the shown thread whose
+// body has not been downloaded yet, or nil
+func (r *EmailReader) missingBody() *email.IncomingMessage {
+	if r.shownThread == nil {
+		return nil
+	}
+	for _, msg := range r.shownThread.Messages {
+		if _, failed := r.bodyErrors[msg]; r.expanded[msg] && !msg.BodyLoaded && !failed {
+			return msg
+		}
+	}
+	return nil
+}
+
+// loadBodies fetches the bodies of the unfolded emails of the shown thread
+// in the background, one at a time, animating a spinner until they arrive
+func (r *EmailReader) loadBodies() {
+	if r.loadingBody || r.provider == nil {
+		return // The provider handles one request at a time anyway
+	}
+	msg := r.missingBody()
+	if msg == nil {
+		return
+	}
+	r.loadingBody = true
+	thread := r.shownThread
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for frame := 1; ; frame++ {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				spinner := string(spinnerFrames[frame%len(spinnerFrames)])
+				r.app.QueueUpdateDraw(func() {
+					if r.shownThread == thread && !msg.BodyLoaded {
+						r.renderThread(spinner)
+					}
+				})
+			}
+		}
+	}()
+
+	provider := r.provider
+	go func() {
+		full, err := provider.FetchMessage(msg)
+		close(done)
+
+		r.app.QueueUpdateDraw(func() {
+			r.loadingBody = false
+			if err == nil {
+				// Flags may have been changed while the body downloaded
+				flags := msg.Flags
+				*msg = *full
+				msg.Flags = flags
+			}
+			if r.shownThread == thread {
+				if err != nil {
+					r.bodyErrors[msg] = err
+				}
+				r.renderThread(string(spinnerFrames[0]))
+			}
+			// Carry on with the rest of the thread, or the one the user
+			// opened meanwhile
+			r.loadBodies()
+		})
+	}()
+}
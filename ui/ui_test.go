@@ -1,10 +1,16 @@
 package ui
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"github.com/jacobbanks/tmail/email"
+	"github.com/rivo/tview"
 )
 
 // TODO: Implement these tests, skipping for now.
@@ -55,3 +61,457 @@ func TestNewEmailReader(t *testing.T) {
 	// Skip UI tests to avoid issues with terminal IO
 	t.Skip("Skipping UI tests that require terminal interaction")
 }
+
+func TestPopulateSidebar(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	reader := NewEmailReader([]*email.IncomingMessage{createTestEmail()}, nil)
+	reader.mailbox = "[Gmail]/Sent Mail"
+
+	reader.populateSidebar([]mailboxStatus{
+		{mailbox: &email.Mailbox{Name: "INBOX", Delimiter: "/"}, status: &email.MailboxStatus{Name: "INBOX", Unseen: 3}},
+		{mailbox: &email.Mailbox{Name: "[Gmail]", Delimiter: "/", Attributes: []string{"\\Noselect"}}},
+		{mailbox: &email.Mailbox{Name: "[Gmail]/Sent Mail", Delimiter: "/"}, status: &email.MailboxStatus{Name: "[Gmail]/Sent Mail"}},
+		{mailbox: &email.Mailbox{Name: "Work/Projects/tmail", Delimiter: "/"}},
+	})
+
+	top := reader.sidebar.GetRoot().GetChildren()
+	if len(top) != 3 {
+		t.Fatalf("Expected 3 top-level folders, got %d", len(top))
+	}
+	if got := top[0].GetText(); got != "Inbox (3)" {
+		t.Errorf("Expected the inbox with its unread count, got %q", got)
+	}
+	if children := top[1].GetChildren(); len(children) != 1 || children[0].GetText() != "Sent Mail" {
+		t.Errorf("Expected Sent Mail nested under [Gmail]")
+	}
+	if current := reader.sidebar.GetCurrentNode(); current == nil || current.GetText() != "Sent Mail" {
+		t.Error("Expected the open mailbox to be selected")
+	}
+
+	// Parents the server did not list get placeholder nodes
+	work := top[2]
+	if work.GetText() != "Work" || len(work.GetChildren()) != 1 || work.GetChildren()[0].GetChildren()[0].GetText() != "tmail" {
+		t.Error("Expected Work/Projects/tmail to be nested under placeholders")
+	}
+}
+
+func TestCyclePanes(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	reader := NewEmailReader([]*email.IncomingMessage{createTestEmail()}, nil)
+
+	reader.focusPane("list")
+	reader.cyclePane(1)
+	if reader.currentView != "content" {
+		t.Errorf("Expected content after list, got %s", reader.currentView)
+	}
+	reader.cyclePane(1)
+	if reader.currentView != "sidebar" {
+		t.Errorf("Expected to wrap around to the sidebar, got %s", reader.currentView)
+	}
+
+	reader.toggleSidebar()
+	if reader.currentView != "list" {
+		t.Errorf("Hiding the focused sidebar should focus the list, got %s", reader.currentView)
+	}
+	reader.cyclePane(-1)
+	if reader.currentView != "content" {
+		t.Errorf("Expected the hidden sidebar to be skipped, got %s", reader.currentView)
+	}
+}
+
+func TestApplyChanges(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	emails := []*email.IncomingMessage{
+		{Mailbox: "INBOX", UIDValidity: 1, UID: 3, Subject: "Three"},
+		{Mailbox: "INBOX", UIDValidity: 1, UID: 2, Subject: "Two"},
+		{Mailbox: "INBOX", UIDValidity: 1, UID: 1, Subject: "One"},
+	}
+	reader := NewEmailReader(emails, nil)
+	reader.populateEmailList()
+	reader.emailList.SetCurrentItem(2) // "One"
+
+	reader.applyChanges(&email.MailboxChanges{
+		Mailbox:  "INBOX",
+		New:      []*email.IncomingMessage{{Mailbox: "INBOX", UIDValidity: 1, UID: 4, Subject: "Four"}},
+		Updated:  []*email.IncomingMessage{{Mailbox: "INBOX", UIDValidity: 1, UID: 1, Flags: []string{"\\Seen"}}},
+		Expunged: []uint32{2},
+	})
+
+	var subjects []string
+	for _, msg := range reader.emails {
+		subjects = append(subjects, msg.Subject)
+	}
+	if len(subjects) != 3 || subjects[0] != "Four" || subjects[1] != "Three" || subjects[2] != "One" {
+		t.Fatalf("Expected Four, Three, One, got %v", subjects)
+	}
+	if reader.emailList.GetItemCount() != 3 {
+		t.Errorf("Expected 3 rows, got %d", reader.emailList.GetItemCount())
+	}
+	if flags := reader.emails[2].Flags; len(flags) != 1 || flags[0] != "\\Seen" {
+		t.Errorf("Expected the flags to be updated, got %v", flags)
+	}
+	if reader.emailList.GetCurrentItem() != 2 {
+		t.Errorf("Expected the selection to follow the selected email, got row %d", reader.emailList.GetCurrentItem())
+	}
+}
+
+func TestSetUnread(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	reader := NewEmailReader([]*email.IncomingMessage{createTestEmail()}, nil)
+	if strings.Contains(reader.header.GetText(true), "unread") {
+		t.Errorf("Expected no unread count before it is known, got %q", reader.header.GetText(true))
+	}
+
+	reader.setUnread(&email.MailboxStatus{Name: "INBOX", Unseen: 3})
+	if !strings.Contains(reader.header.GetText(true), "(3 unread)") {
+		t.Errorf("Expected the header to show 3 unread, got %q", reader.header.GetText(true))
+	}
+
+	reader.setUnread(nil)
+	if !strings.Contains(reader.header.GetText(true), "(3 unread)") {
+		t.Errorf("Expected a missing status to keep the count, got %q", reader.header.GetText(true))
+	}
+}
+
+func TestEmailItemText(t *testing.T) {
+	msg := createTestEmail()
+	if text, secondary := emailItemText(msg, false); !strings.HasPrefix(text, "[::b]") || !strings.HasPrefix(secondary, "[::b]") {
+		t.Errorf("Expected an unread email to be bold, got %q / %q", text, secondary)
+	}
+
+	msg.Flags = []string{email.SeenFlag, email.FlaggedFlag}
+	text, _ := emailItemText(msg, false)
+	if strings.HasPrefix(text, "[::b]") {
+		t.Errorf("Expected a read email not to be bold, got %q", text)
+	}
+	if !strings.Contains(text, "★") {
+		t.Errorf("Expected a starred email to be marked, got %q", text)
+	}
+
+	// Long subjects and names are cut by the columns they take up, between characters
+	msg.Subject = strings.Repeat("会議の議事録", 10)
+	msg.From = strings.Repeat("Jérôme ", 10) + "<jerome@example.com>"
+	text, secondary := emailItemText(msg, false)
+	if !utf8.ValidString(text) || !utf8.ValidString(secondary) {
+		t.Errorf("Expected whole characters, got %q / %q", text, secondary)
+	}
+	if !strings.HasSuffix(text, "会議の議事録会議の議事録会議の議事録...") {
+		t.Errorf("Expected the subject cut to 40 columns, got %q", text)
+	}
+	if !strings.HasSuffix(secondary, "From: Jérôme Jérôme Jérôme J...") {
+		t.Errorf("Expected the sender cut to 25 columns, got %q", secondary)
+	}
+}
+
+func TestToggleFlag(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	reader := NewEmailReader([]*email.IncomingMessage{createTestEmail()}, nil)
+	reader.populateEmailList()
+	reader.setUnread(&email.MailboxStatus{Name: "INBOX", Unseen: 1})
+
+	reader.toggleFlag(email.SeenFlag)
+	if !reader.emails[0].Seen() {
+		t.Fatal("Expected the email to be marked read")
+	}
+	if text, _ := reader.emailList.GetItemText(0); strings.HasPrefix(text, "[::b]") {
+		t.Errorf("Expected the row to be restyled, got %q", text)
+	}
+	if reader.unread != 0 {
+		t.Errorf("Expected no unread emails left, got %d", reader.unread)
+	}
+
+	reader.toggleFlag(email.SeenFlag)
+	if reader.emails[0].Seen() || reader.unread != 1 {
+		t.Errorf("Expected the email to be unread again, got %v with %d unread", reader.emails[0].Flags, reader.unread)
+	}
+}
+
+func TestRemoveEmails(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	emails := []*email.IncomingMessage{
+		{Mailbox: "INBOX", UIDValidity: 1, UID: 3, Subject: "Three"},
+		{Mailbox: "INBOX", UIDValidity: 1, UID: 2, Subject: "Two"},
+		{Mailbox: "INBOX", UIDValidity: 1, UID: 1, Subject: "One"},
+	}
+	reader := NewEmailReader(emails, nil)
+	reader.populateEmailList()
+
+	reader.removeEmails([]*email.IncomingMessage{emails[2]})
+	if len(reader.emails) != 2 || reader.emailList.GetItemCount() != 2 {
+		t.Fatalf("Expected 2 emails left, got %d", len(reader.emails))
+	}
+	if reader.emailList.GetCurrentItem() != 1 {
+		t.Errorf("Expected the last row to be selected, got row %d", reader.emailList.GetCurrentItem())
+	}
+}
+
+func TestTargetEmails(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	emails := []*email.IncomingMessage{
+		{Mailbox: "INBOX", UIDValidity: 1, UID: 3, Subject: "Three"},
+		{Mailbox: "INBOX", UIDValidity: 1, UID: 2, Subject: "Two"},
+		{Mailbox: "INBOX", UIDValidity: 1, UID: 1, Subject: "One"},
+	}
+	reader := NewEmailReader(emails, nil)
+	reader.populateEmailList()
+
+	if targets := reader.targetEmails(); len(targets) != 1 || targets[0] != emails[0] {
+		t.Fatalf("Expected the selected email without marks, got %v", targets)
+	}
+
+	reader.toggleMark() // Marks Three and moves to Two
+	reader.emailList.SetCurrentItem(2)
+	reader.toggleMark()
+	targets := reader.targetEmails()
+	if len(targets) != 2 || targets[0] != emails[0] || targets[1] != emails[2] {
+		t.Fatalf("Expected the marked emails, got %v", targets)
+	}
+	if text, _ := reader.emailList.GetItemText(0); !strings.Contains(text, "✔") {
+		t.Errorf("Expected the marked row to be ticked, got %q", text)
+	}
+
+	reader.removeEmails(targets)
+	if len(reader.emails) != 1 || len(reader.marked) != 0 {
+		t.Errorf("Expected Two left without marks, got %d emails and %d marks", len(reader.emails), len(reader.marked))
+	}
+}
+
+func TestSearchRejectsBadQuery(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	reader := NewEmailReader([]*email.IncomingMessage{createTestEmail()}, nil)
+
+	reader.search("since:yesterday")
+	if !strings.Contains(reader.statusBar.GetText(true), "invalid date") {
+		t.Errorf("Expected the status bar to explain the error, got %q", reader.statusBar.GetText(true))
+	}
+	if reader.searchQuery != "" {
+		t.Errorf("Expected the list to stay on the mailbox, got search %q", reader.searchQuery)
+	}
+}
+
+func TestShowSearchResults(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	reader := NewEmailReader([]*email.IncomingMessage{createTestEmail()}, nil)
+
+	reader.showSearchResults("lunch", nil)
+	if reader.searchQuery != "lunch" || !reader.noMoreEmails {
+		t.Errorf("Expected the list to show the search, got %q", reader.searchQuery)
+	}
+	if text, _ := reader.emailList.GetItemText(0); text != "No emails found." {
+		t.Errorf("Expected an empty result notice, got %q", text)
+	}
+
+	results := []*email.IncomingMessage{createTestEmail(), createTestEmail()}
+	reader.showSearchResults("lunch", results)
+	if reader.emailList.GetItemCount() != 2 {
+		t.Errorf("Expected the results to replace the notice, got %d rows", reader.emailList.GetItemCount())
+	}
+}
+
+func TestThreadRows(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	date := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	emails := []*email.IncomingMessage{
+		{Mailbox: "INBOX", UIDValidity: 1, UID: 3, From: "Bob <bob@example.com>", Subject: "Re: Lunch", Date: date.Add(2 * time.Hour),
+			MessageID: "c@x", InReplyTo: "a@x", Body: "Sure", BodyLoaded: true},
+		{Mailbox: "INBOX", UIDValidity: 1, UID: 2, From: "Carol <carol@example.com>", Subject: "Report", Date: date.Add(time.Hour),
+			MessageID: "b@x", Flags: []string{email.SeenFlag}, Body: "Attached", BodyLoaded: true},
+		{Mailbox: "INBOX", UIDValidity: 1, UID: 1, From: "Alice <alice@example.com>", Subject: "Lunch", Date: date,
+			MessageID: "a@x", Flags: []string{email.SeenFlag}, Body: "Pizza?", BodyLoaded: true},
+	}
+	reader := NewEmailReader(emails, nil)
+
+	if reader.emailList.GetItemCount() != 2 {
+		t.Fatalf("Expected the Lunch emails to share a row, got %d rows", reader.emailList.GetItemCount())
+	}
+	text, secondary := reader.emailList.GetItemText(0)
+	if !strings.Contains(text, "Lunch") || !strings.Contains(text, "(2)") || !strings.HasPrefix(text, "[::b]") {
+		t.Errorf("Expected a bold Lunch row counting 2 emails, got %q", text)
+	}
+	if !strings.Contains(secondary, "Alice, Bob") {
+		t.Errorf("Expected the row to name both participants, got %q", secondary)
+	}
+
+	reader.showThread(0)
+	content := reader.contentView.GetText(true)
+	if !strings.Contains(content, "▸") || strings.Contains(content, "Pizza?") || !strings.Contains(content, "Sure") {
+		t.Errorf("Expected Alice's email folded and Bob's shown, got %q", content)
+	}
+	if !emails[0].Seen() {
+		t.Errorf("Expected opening the thread to mark it read")
+	}
+
+	reader.toggleFolded()
+	if content := reader.contentView.GetText(true); !strings.Contains(content, "Pizza?") || !strings.Contains(content, "Sure") {
+		t.Errorf("Expected the whole thread unfolded, got %q", content)
+	}
+
+	reader.emailList.SetCurrentItem(0)
+	if targets := reader.targetEmails(); len(targets) != 2 {
+		t.Errorf("Expected actions to target the whole thread, got %d emails", len(targets))
+	}
+}
+
+func TestResponseComposer(t *testing.T) {
+	original := createTestEmail()
+	original.Cc = "carol@example.com"
+	original.ReplyTo = "list@example.com"
+
+	field := func(c *EmailComposer, index int) string {
+		return c.form.GetFormItem(index).(*tview.InputField).GetText()
+	}
+
+	replyAll := NewResponseComposer(original, ComposeReplyAll, nil)
+	if got := field(replyAll, ToField); got != "list@example.com, recipient@example.com" {
+		t.Errorf("Expected the reply to go to Reply-To and the other recipients, got %q", got)
+	}
+	if got := field(replyAll, CcField); got != "carol@example.com" {
+		t.Errorf("Expected Carol on Cc, got %q", got)
+	}
+
+	forward := NewResponseComposer(original, ComposeForward, nil)
+	if got := field(forward, ToField); got != "" {
+		t.Errorf("Expected a forward without recipients, got %q", got)
+	}
+	if got := field(forward, SubjectField); got != "Fwd: Test Subject" {
+		t.Errorf("Expected a Fwd: subject, got %q", got)
+	}
+	body := forward.bodyArea.GetText()
+	if !strings.Contains(body, "---------- Forwarded message ----------") || !strings.Contains(body, "Cc: carol@example.com") || !strings.Contains(body, original.Body) {
+		t.Errorf("Expected the forwarded email in the body, got %q", body)
+	}
+
+	if got := forwardedFilename("Q3: report/final"); got != "Q3_ report_final.eml" {
+		t.Errorf("Expected a safe file name, got %q", got)
+	}
+}
+
+// fakeFetcher serves forwarded downloads, failing for the named parts
+type fakeFetcher struct {
+	failing string
+}
+
+func (f fakeFetcher) FetchRawMessage(msg *email.IncomingMessage) ([]byte, error) {
+	return []byte("Subject: " + msg.Subject + "\r\n\r\n" + msg.Body), nil
+}
+
+func (f fakeFetcher) FetchPart(msg *email.IncomingMessage, part *email.MIMEPart) ([]byte, error) {
+	if part.Filename == f.failing {
+		return nil, fmt.Errorf("connection lost")
+	}
+	return []byte("content of " + part.Filename), nil
+}
+
+func TestFetchForwarded(t *testing.T) {
+	original := createTestEmail()
+	original.Parts = &email.MIMEPart{ContentType: "multipart/mixed", Children: []*email.MIMEPart{
+		{ContentType: "text/plain", Path: []int{1}},
+		{ContentType: "application/pdf", Disposition: "attachment", Filename: "report.pdf", Path: []int{2}},
+		{ContentType: "image/png", Disposition: "attachment", Filename: "chart.png", Path: []int{3}},
+	}}
+
+	var progress []string
+	attachments, err := fetchForwarded(fakeFetcher{failing: "chart.png"}, original, ComposeForward, func(name string, n, total int) {
+		progress = append(progress, fmt.Sprintf("%s %d/%d", name, n, total))
+	})
+	if strings.Join(progress, ", ") != "report.pdf 1/2, chart.png 2/2" {
+		t.Errorf("Unexpected progress %v", progress)
+	}
+	if len(attachments) != 1 || string(attachments[0].Content) != "content of report.pdf" {
+		t.Errorf("Expected the PDF to be attached, got %+v", attachments)
+	}
+	if err == nil || !strings.Contains(err.Error(), "chart.png") {
+		t.Errorf("Expected the failed download to be reported, got %v", err)
+	}
+
+	attachments, err = fetchForwarded(fakeFetcher{}, original, ComposeForwardAttachment, func(string, int, int) {})
+	if err != nil || len(attachments) != 1 || attachments[0].ContentType != "message/rfc822" {
+		t.Errorf("Expected the email as an attachment, got %+v (%v)", attachments, err)
+	}
+}
+
+func TestSendWaitsForForwardedAttachments(t *testing.T) {
+	composer := NewResponseComposer(createTestEmail(), ComposeForward, nil)
+	composer.forwarding = true
+	composer.form.GetFormItem(ToField).(*tview.InputField).SetText("bob@example.com")
+
+	composer.sendEmail()
+	if composer.sending || !strings.Contains(composer.statusBar.GetText(true), "Still downloading") {
+		t.Errorf("Expected sending to wait for the attachments, got %q", composer.statusBar.GetText(true))
+	}
+}
+
+func TestToggleHTML(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	msg := &email.IncomingMessage{Mailbox: "INBOX", UIDValidity: 1, UID: 1, From: "news@example.com", Subject: "Weekly",
+		Date: time.Now(), Flags: []string{email.SeenFlag}, Body: "Plain version",
+		HTMLBody: "<h1>Rich version</h1>", BodyLoaded: true}
+	reader := NewEmailReader([]*email.IncomingMessage{msg}, nil)
+
+	reader.showThread(0)
+	if content := reader.contentView.GetText(true); !strings.Contains(content, "Plain version") {
+		t.Errorf("Expected the plain text first, got %q", content)
+	}
+	reader.toggleHTML()
+	if content := reader.contentView.GetText(true); !strings.Contains(content, "Rich version") || strings.Contains(content, "Plain version") {
+		t.Errorf("Expected the rendered HTML, got %q", content)
+	}
+	reader.toggleHTML()
+
+	// Emails without plain text show their HTML either way
+	msg.HTMLOnly = true
+	reader.renderThread("")
+	if content := reader.contentView.GetText(true); !strings.Contains(content, "Rich version") {
+		t.Errorf("Expected the HTML of an HTML only email, got %q", content)
+	}
+}
+
+func TestParseMailcap(t *testing.T) {
+	entries := parseMailcap(`# Viewers
+application/pdf; zathura %s; test=test -n "$DISPLAY"
+text/html; w3m -dump %s; copiousoutput
+image; feh '%s'
+text/plain; less %s; \
+	needsterminal
+application/x-weird; echo a\;b
+`)
+
+	if len(entries) != 4 {
+		t.Fatalf("Expected 4 entries, got %d: %+v", len(entries), entries)
+	}
+	if entries[0].contentType != "application/pdf" || entries[0].command != "zathura %s" || entries[0].test != `test -n "$DISPLAY"` {
+		t.Errorf("Unexpected PDF entry %+v", entries[0])
+	}
+	if !entries[1].matches("image/png") || entries[1].matches("text/plain") {
+		t.Errorf("Expected image to match any image, got %+v", entries[1])
+	}
+	if !entries[2].needsTerminal || entries[2].contentType != "text/plain" {
+		t.Errorf("Expected a continued terminal entry, got %+v", entries[2])
+	}
+	if entries[3].command != "echo a;b" {
+		t.Errorf("Expected an escaped semicolon, got %q", entries[3].command)
+	}
+}
+
+func TestOpenCommand(t *testing.T) {
+	mailcap := filepath.Join(t.TempDir(), "mailcap")
+	os.WriteFile(mailcap, []byte("application/pdf; false-viewer %s; test=false\n"+
+		"application/pdf; pdf-viewer '%s'\n"+
+		"text/*; pager; needsterminal\n"), 0600)
+	t.Setenv("MAILCAPS", mailcap)
+
+	cmd, inTerminal := openCommand("application/PDF", "/tmp/it's.pdf")
+	if got := cmd.Args[len(cmd.Args)-1]; got != `pdf-viewer '/tmp/it'\''s.pdf'` || inTerminal {
+		t.Errorf("Expected the entry whose test passes, got %q", got)
+	}
+
+	cmd, inTerminal = openCommand("text/csv", "/tmp/data.csv")
+	if got := cmd.Args[len(cmd.Args)-1]; got != "pager < '/tmp/data.csv'" || !inTerminal {
+		t.Errorf("Expected the file on standard input of a terminal viewer, got %q", got)
+	}
+
+	cmd, _ = openCommand("application/zip", "/tmp/files.zip")
+	if got := cmd.Args[len(cmd.Args)-1]; got != "/tmp/files.zip" {
+		t.Errorf("Expected the desktop opener, got %v", cmd.Args)
+	}
+}